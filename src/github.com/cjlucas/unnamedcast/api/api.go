@@ -3,10 +3,12 @@ package api
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 )
@@ -43,6 +45,36 @@ type RGB struct {
 	Blue  int `json:"blue"`
 }
 
+type Category struct {
+	Name          string   `json:"name"`
+	Subcategories []string `json:"subcategories"`
+}
+
+type Person struct {
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+	Group    string `json:"group"`
+	ImageURL string `json:"image_url"`
+	URL      string `json:"url"`
+}
+
+type Funding struct {
+	URL   string `json:"url"`
+	Title string `json:"title"`
+}
+
+type Transcript struct {
+	URL      string `json:"url"`
+	Type     string `json:"type"`
+	Language string `json:"language"`
+	Rel      string `json:"rel"`
+}
+
+type Chapters struct {
+	URL  string `json:"url"`
+	Type string `json:"type"`
+}
+
 type Feed struct {
 	ID                 string    `json:"id,omitempty"`
 	Title              string    `json:"title"`
@@ -58,6 +90,24 @@ type Feed struct {
 	Items              []string  `json:"items"`
 	SourceETag         string    `json:"src_etag"`
 	SourceLastModified time.Time `json:"src_last_modified"`
+	SourceContentHash  string    `json:"src_content_hash"`
+
+	ScrapesSkippedNotModified int `json:"scrapes_skipped_not_modified"`
+	ScrapesSkippedContentHash int `json:"scrapes_skipped_content_hash"`
+
+	NextCheckTime       time.Time     `json:"next_check_time"`
+	CheckInterval       time.Duration `json:"check_interval"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+
+	LastError       string    `json:"last_error"`
+	LastStatusCode  int       `json:"last_status_code"`
+	LastSuccessTime time.Time `json:"last_success_time"`
+	Disabled        bool      `json:"disabled"`
+
+	HubURL          string    `json:"hub_url"`
+	HubTopic        string    `json:"hub_topic"`
+	HubLeaseExpiry  time.Time `json:"hub_lease_expiry"`
+	HubPendingUntil time.Time `json:"hub_pending_until"`
 
 	Category struct {
 		Name          string   `json:"name"`
@@ -65,6 +115,28 @@ type Feed struct {
 	} `json:"category"`
 
 	ImageColors []RGB `json:"image_colors"`
+	Blocked     bool  `json:"blocked"`
+	Complete    bool  `json:"complete"`
+
+	URLAliases []string `json:"url_aliases"`
+
+	Description string     `json:"description"`
+	Link        string     `json:"link"`
+	Language    string     `json:"language"`
+	Copyright   string     `json:"copyright"`
+	Explicit    bool       `json:"explicit"`
+	Type        string     `json:"type"`
+	Categories  []Category `json:"categories"`
+
+	Owner struct {
+		Name  string `json:"name"`
+		Email string `json:"email"`
+	} `json:"owner"`
+
+	PodcastGUID string    `json:"podcast_guid"`
+	Locked      bool      `json:"locked"`
+	Funding     []Funding `json:"funding"`
+	Persons     []Person  `json:"persons"`
 }
 
 type Item struct {
@@ -82,6 +154,22 @@ type Item struct {
 	ImageURL         string        `json:"image_url"`
 	CreationTime     time.Time     `json:"creation_time"`
 	ModificationTime time.Time     `json:"modification_time"`
+	RemovedTime      *time.Time    `json:"removed_time"`
+
+	// Keys used to match an item whose GUID has changed
+	MediaURLKey string `json:"media_url_key"`
+	ContentKey  string `json:"content_key"`
+
+	EpisodeType    string  `json:"episode_type"`
+	Explicit       bool    `json:"explicit"`
+	Season         int     `json:"season"`
+	SeasonName     string  `json:"season_name"`
+	Episode        float64 `json:"episode"`
+	EpisodeDisplay string  `json:"episode_display"`
+
+	Transcripts []Transcript `json:"transcripts"`
+	Chapters    *Chapters    `json:"chapters"`
+	Persons     []Person     `json:"persons"`
 }
 
 type Job struct {
@@ -103,6 +191,10 @@ type Job struct {
 
 type API struct {
 	Host string
+
+	// Token is sent as a bearer token with every request if set.
+	// It can be either a session token or an API key.
+	Token string
 }
 
 type apiRoundTrip struct {
@@ -129,6 +221,9 @@ func (api *API) makeRequest(apiReq *apiRoundTrip) error {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	if api.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+api.Token)
+	}
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
@@ -139,7 +234,8 @@ func (api *API) makeRequest(apiReq *apiRoundTrip) error {
 
 	apiReq.Response = resp
 
-	if apiReq.ResponseBody != nil {
+	// Responses without a body (e.g. 404) leave ResponseBody untouched
+	if apiReq.ResponseBody != nil && len(data) > 0 {
 		if err := json.Unmarshal(data, apiReq.ResponseBody); err != nil {
 			return err
 		}
@@ -150,15 +246,47 @@ func (api *API) makeRequest(apiReq *apiRoundTrip) error {
 
 func (api *API) CreateUser(username, password string) (*User, error) {
 	var user User
-	endpoint := fmt.Sprintf("/api/users?username=%s&password=%s", username, password)
 	err := api.makeRequest(&apiRoundTrip{
-		Method:       "POST",
-		Endpoint:     endpoint,
+		Method:   "POST",
+		Endpoint: "/api/users",
+		RequestBody: map[string]string{
+			"username": username,
+			"password": password,
+		},
 		ResponseBody: &user,
 	})
 	return &user, err
 }
 
+// Login creates a new session for the given user and returns its token.
+// Set the returned token on API.Token to make authenticated requests.
+func (api *API) Login(username, password string) (string, error) {
+	params := url.Values{}
+	params.Set("username", username)
+	params.Set("password", password)
+
+	var out struct {
+		Token        string `json:"token"`
+		TOTPRequired bool   `json:"totp_required"`
+	}
+	req := apiRoundTrip{
+		Method:       "GET",
+		Endpoint:     "/login?" + params.Encode(),
+		ResponseBody: &out,
+	}
+	if err := api.makeRequest(&req); err != nil {
+		return "", err
+	}
+	if req.Response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("login failed with status %d", req.Response.StatusCode)
+	}
+	if out.TOTPRequired {
+		return "", errors.New("login requires a one-time code")
+	}
+
+	return out.Token, nil
+}
+
 func (api *API) UpdateUserFeeds(userID string, feedIDs []string) error {
 	return api.makeRequest(&apiRoundTrip{
 		Method:      "PUT",
@@ -200,6 +328,29 @@ func (api *API) UpdateFeed(feed *Feed) error {
 	})
 }
 
+// LeaseDueFeed holds back a feed that is due to be updated until the given
+// time. It reports whether the feed was leased, which it is not if it is no
+// longer due.
+func (api *API) LeaseDueFeed(feedID string, until time.Time) (bool, error) {
+	req := apiRoundTrip{
+		Method:      "POST",
+		Endpoint:    fmt.Sprintf("/api/feeds/%s/lease", feedID),
+		RequestBody: map[string]time.Time{"until": until},
+	}
+	if err := api.makeRequest(&req); err != nil {
+		return false, err
+	}
+
+	switch req.Response.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusConflict:
+		return false, nil
+	default:
+		return false, fmt.Errorf("unexpected status code: %d", req.Response.StatusCode)
+	}
+}
+
 func (api *API) CreateFeed(feed *Feed) (*Feed, error) {
 	var respFeed Feed
 	err := api.makeRequest(&apiRoundTrip{
@@ -214,7 +365,7 @@ func (api *API) CreateFeed(feed *Feed) (*Feed, error) {
 func (api *API) feedExistsWithKey(key, value string) (bool, error) {
 	req := apiRoundTrip{
 		Method:   "GET",
-		Endpoint: fmt.Sprintf("/api/feeds?%s=%s", key, value),
+		Endpoint: fmt.Sprintf("/api/feeds?%s=%s", key, url.QueryEscape(value)),
 	}
 	err := api.makeRequest(&req)
 	return req.Response.StatusCode == http.StatusOK, err
@@ -228,17 +379,27 @@ func (api *API) FeedExistsWithiTunesID(id int) (bool, error) {
 	return api.feedExistsWithKey("itunes_id", strconv.Itoa(id))
 }
 
+// FeedForURL returns the feed at, or moved from, feedURL. If there is no
+// such feed, nil is returned.
 func (api *API) FeedForURL(feedURL string) (*Feed, error) {
-	var feeds []Feed
-	err := api.makeRequest(&apiRoundTrip{
+	var feed Feed
+	req := apiRoundTrip{
 		Method:       "GET",
-		Endpoint:     fmt.Sprintf("/api/feeds?url=%s", feedURL),
-		ResponseBody: &feeds,
-	})
-	if len(feeds) == 0 {
+		Endpoint:     "/api/feeds?url=" + url.QueryEscape(feedURL),
+		ResponseBody: &feed,
+	}
+	if err := api.makeRequest(&req); err != nil {
 		return nil, err
 	}
-	return &feeds[0], err
+
+	switch req.Response.StatusCode {
+	case http.StatusOK:
+		return &feed, nil
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unexpected status code: %d", req.Response.StatusCode)
+	}
 }
 
 func (api *API) CreateFeedItem(feedID string, item *Item) error {
@@ -262,16 +423,53 @@ func (api *API) UpdateFeedItem(feedID string, item *Item) (*Item, error) {
 	return &out, err
 }
 
+// GetFeedItems returns all of the feed's items, including those that have
+// been removed from the feed.
 func (api *API) GetFeedItems(feedID string) ([]Item, error) {
 	var items []Item
 	err := api.makeRequest(&apiRoundTrip{
 		Method:       "GET",
-		Endpoint:     fmt.Sprintf("/api/feeds/%s/items", feedID),
+		Endpoint:     fmt.Sprintf("/api/feeds/%s/items?include_removed=true", feedID),
 		ResponseBody: &items,
 	})
 	return items, err
 }
 
+// ItemKeys identify the items to be returned by FindFeedItems.
+type ItemKeys struct {
+	GUIDs        []string `json:"guids"`
+	MediaURLKeys []string `json:"media_url_keys"`
+	ContentKeys  []string `json:"content_keys"`
+}
+
+// FindFeedItems returns the feed's items, including those that have been
+// removed from the feed, that match any of the given keys.
+func (api *API) FindFeedItems(feedID string, keys *ItemKeys) ([]Item, error) {
+	var items []Item
+	err := api.makeRequest(&apiRoundTrip{
+		Method:       "POST",
+		Endpoint:     fmt.Sprintf("/api/feeds/%s/items/find", feedID),
+		RequestBody:  keys,
+		ResponseBody: &items,
+	})
+	return items, err
+}
+
+// MarkRemovedFeedItems marks the feed's items that are not in keepIDs as
+// removed. It returns the number of items marked.
+func (api *API) MarkRemovedFeedItems(feedID string, keepIDs []string) (int, error) {
+	var resp struct {
+		Removed int `json:"removed"`
+	}
+	err := api.makeRequest(&apiRoundTrip{
+		Method:       "POST",
+		Endpoint:     fmt.Sprintf("/api/feeds/%s/items/mark_removed", feedID),
+		RequestBody:  map[string][]string{"keep_ids": keepIDs},
+		ResponseBody: &resp,
+	})
+	return resp.Removed, err
+}
+
 func (api *API) GetFeedsUsers(feedID string) ([]User, error) {
 	var users []User
 	err := api.makeRequest(&apiRoundTrip{
@@ -282,6 +480,28 @@ func (api *API) GetFeedsUsers(feedID string) ([]User, error) {
 	return users, err
 }
 
+// GetSubscribedFeeds returns every feed with at least one subscriber.
+func (api *API) GetSubscribedFeeds() ([]Feed, error) {
+	var feeds []Feed
+	err := api.makeRequest(&apiRoundTrip{
+		Method:       "GET",
+		Endpoint:     "/api/feeds?subscribed=true",
+		ResponseBody: &feeds,
+	})
+	return feeds, err
+}
+
+// GetDueFeeds returns the subscribed feeds that are due to be updated.
+func (api *API) GetDueFeeds() ([]Feed, error) {
+	var feeds []Feed
+	err := api.makeRequest(&apiRoundTrip{
+		Method:       "GET",
+		Endpoint:     "/api/feeds?due=true",
+		ResponseBody: &feeds,
+	})
+	return feeds, err
+}
+
 func (api *API) GetUsers() ([]User, error) {
 	var users []User
 	err := api.makeRequest(&apiRoundTrip{
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/cjlucas/unnamedcast/db/utctime"
+)
+
+// APIKeyPrefix is prepended to every API key so they can be told apart from
+// session tokens without a database lookup.
+const APIKeyPrefix = "key_"
+
+// Scopes limit which endpoints an API key can call.
+const (
+	ScopeFeeds = "feeds"
+	ScopeItems = "items"
+	ScopeJobs  = "jobs"
+
+	// ScopeUserStates grants writing the item states of every user, as
+	// the worker does when it finds new items. API keys are not bound to
+	// a user, so it should only be granted to the worker.
+	ScopeUserStates = "user_states"
+)
+
+var Scopes = []string{ScopeFeeds, ScopeItems, ScopeJobs, ScopeUserStates}
+
+// APIKey is a credential for non-user principals such as the worker.
+// Like sessions, only the hash of the key is persisted.
+type APIKey struct {
+	ID           ID           `json:"id" bson:"_id,omitempty"`
+	Name         string       `json:"name" bson:"name"`
+	KeyHash      string       `json:"key_hash" bson:"key_hash" index:",unique"`
+	Scopes       []string     `json:"scopes" bson:"scopes"`
+	CreationTime utctime.Time `json:"creation_time" bson:"creation_time"`
+}
+
+// HasScope reports whether the key has been granted the given scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type APIKeyCollection struct {
+	collection
+}
+
+// ValidScope reports whether scope is one of Scopes.
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Create generates a new API key with the given scopes. The returned key is
+// the only copy of the secret.
+func (c APIKeyCollection) Create(name string, scopes []string) (*APIKey, string, error) {
+	for _, s := range scopes {
+		if !ValidScope(s) {
+			return nil, "", fmt.Errorf("unknown scope: %s", s)
+		}
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+	key := APIKeyPrefix + token
+
+	apiKey := APIKey{
+		ID:           NewID(),
+		Name:         name,
+		KeyHash:      hashToken(key),
+		Scopes:       scopes,
+		CreationTime: utctime.Now(),
+	}
+
+	if err := c.insert(&apiKey); err != nil {
+		return nil, "", err
+	}
+
+	return &apiKey, key, nil
+}
+
+// APIKeyByKey returns the API key matching the given secret.
+// ErrNotFound is returned if no such key exists.
+func (c APIKeyCollection) APIKeyByKey(key string) (*APIKey, error) {
+	cur := c.Find(&Query{
+		Filter: M{"key_hash": hashToken(key)},
+	})
+
+	var apiKey APIKey
+	if err := cur.One(&apiKey); err != nil {
+		return nil, err
+	}
+	return &apiKey, nil
+}
+
+// Revoke deletes the API key with the given ID.
+func (c APIKeyCollection) Revoke(id ID) error {
+	return c.c.RemoveId(id)
+}
@@ -0,0 +1,50 @@
+package db
+
+import "testing"
+
+func TestAPIKeyByKey(t *testing.T) {
+	db := newDB()
+
+	apiKey, key, err := db.APIKeys.Create("worker", []string{ScopeFeeds})
+	if err != nil {
+		t.Fatal("Could not create api key:", err)
+	}
+
+	out, err := db.APIKeys.APIKeyByKey(key)
+	if err != nil {
+		t.Fatal("Could not find api key:", err)
+	}
+
+	if out.ID != apiKey.ID {
+		t.Errorf("id mismatch: %s != %s", out.ID, apiKey.ID)
+	}
+
+	if !out.HasScope(ScopeFeeds) || out.HasScope(ScopeJobs) {
+		t.Errorf("unexpected scopes: %v", out.Scopes)
+	}
+
+	if _, err := db.APIKeys.APIKeyByKey(APIKeyPrefix + "badkey"); err != ErrNotFound {
+		t.Errorf("unexpected error: %v != %v", err, ErrNotFound)
+	}
+}
+
+func TestAPIKey_CreateUnknownScope(t *testing.T) {
+	db := newDB()
+
+	if _, _, err := db.APIKeys.Create("worker", []string{"bogus"}); err == nil {
+		t.Error("expected error for unknown scope")
+	}
+}
+
+func TestAPIKey_Revoke(t *testing.T) {
+	db := newDB()
+
+	apiKey, key, _ := db.APIKeys.Create("worker", []string{ScopeFeeds})
+	if err := db.APIKeys.Revoke(apiKey.ID); err != nil {
+		t.Fatal("Could not revoke api key:", err)
+	}
+
+	if _, err := db.APIKeys.APIKeyByKey(key); err != ErrNotFound {
+		t.Errorf("unexpected error: %v != %v", err, ErrNotFound)
+	}
+}
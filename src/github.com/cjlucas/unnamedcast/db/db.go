@@ -14,7 +14,7 @@ var ErrNotFound = mgo.ErrNotFound
 var ErrOutdatedResource = errors.New("resource is out of date")
 
 func IsDup(err error) bool {
-	return mgo.IsDup(err)
+	return err == ErrDuplicateURL || mgo.IsDup(err)
 }
 
 type M bson.M
@@ -50,11 +50,13 @@ type DB struct {
 	cfg         Config
 	collections []*collection
 
-	Users UserCollection
-	Feeds FeedCollection
-	Items ItemCollection
-	Logs  LogCollection
-	Jobs  JobCollection
+	Users    UserCollection
+	Feeds    FeedCollection
+	Items    ItemCollection
+	Logs     LogCollection
+	Jobs     JobCollection
+	Sessions SessionCollection
+	APIKeys  APIKeyCollection
 }
 
 type Config struct {
@@ -81,6 +83,8 @@ func New(cfg Config) (*DB, error) {
 	ret.addCollection("items", &ret.Items.collection, Item{})
 	ret.addCollection("logs", &ret.Logs.collection, Log{})
 	ret.addCollection("jobs", &ret.Jobs.collection, Job{})
+	ret.addCollection("sessions", &ret.Sessions.collection, Session{})
+	ret.addCollection("api_keys", &ret.APIKeys.collection, APIKey{})
 	ret.addSubCollection(&ret.Users.ItemStateCollection, ItemState{})
 
 	for _, c := range ret.collections {
@@ -2,6 +2,8 @@ package db
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/cjlucas/unnamedcast/db/utctime"
@@ -13,6 +15,41 @@ type RGB struct {
 	Blue  int `json:"blue" bson:"blue"`
 }
 
+// Category is an iTunes category along with its subcategories.
+type Category struct {
+	Name          string   `json:"name" bson:"name"`
+	Subcategories []string `json:"subcategories" bson:"subcategories,omitempty"`
+}
+
+// Person is a podcast:person credit.
+type Person struct {
+	Name     string `json:"name" bson:"name"`
+	Role     string `json:"role" bson:"role"`
+	Group    string `json:"group" bson:"group"`
+	ImageURL string `json:"image_url" bson:"image_url"`
+	URL      string `json:"url" bson:"url"`
+}
+
+// Funding is a podcast:funding link.
+type Funding struct {
+	URL   string `json:"url" bson:"url"`
+	Title string `json:"title" bson:"title"`
+}
+
+// Transcript is a podcast:transcript link.
+type Transcript struct {
+	URL      string `json:"url" bson:"url"`
+	Type     string `json:"type" bson:"type"`
+	Language string `json:"language" bson:"language"`
+	Rel      string `json:"rel" bson:"rel"`
+}
+
+// Chapters is a podcast:chapters link.
+type Chapters struct {
+	URL  string `json:"url" bson:"url"`
+	Type string `json:"type" bson:"type"`
+}
+
 type Feed struct {
 	ID                 ID           `bson:"_id,omitempty" json:"id"`
 	Title              string       `json:"title" bson:"title" index:",text"`
@@ -28,12 +65,85 @@ type Feed struct {
 	SourceETag         string       `json:"src_etag" bson:"src_etag"`
 	SourceLastModified utctime.Time `json:"src_last_modified" bson:"src_last_modified"`
 
+	// SourceContentHash is only set if the source offers neither an ETag
+	// nor a Last-Modified time
+	SourceContentHash string `json:"src_content_hash" bson:"src_content_hash"`
+
+	// Number of scrapes skipped because the source was not modified, as
+	// reported by the server or as found by comparing content hashes
+	ScrapesSkippedNotModified int `json:"scrapes_skipped_not_modified" bson:"scrapes_skipped_not_modified"`
+	ScrapesSkippedContentHash int `json:"scrapes_skipped_content_hash" bson:"scrapes_skipped_content_hash"`
+
+	// NextCheckTime is when the feed is next due to be updated. It is
+	// derived from CheckInterval, or from ConsecutiveFailures if the last
+	// update failed.
+	NextCheckTime       utctime.Time  `json:"next_check_time" bson:"next_check_time" index:"next_check_time"`
+	CheckInterval       time.Duration `json:"check_interval" bson:"check_interval"`
+	ConsecutiveFailures int           `json:"consecutive_failures" bson:"consecutive_failures" index:"consecutive_failures"`
+
+	// Outcome of the most recent updates. LastStatusCode is zero if the
+	// last update failed before a response was received.
+	LastError       string       `json:"last_error" bson:"last_error"`
+	LastStatusCode  int          `json:"last_status_code" bson:"last_status_code"`
+	LastSuccessTime utctime.Time `json:"last_success_time" bson:"last_success_time"`
+
+	// Disabled feeds are no longer scheduled for updates. Feeds are
+	// disabled once they are gone or have been failing for too long.
+	Disabled bool `json:"disabled" bson:"disabled"`
+
+	// WebSub (PubSubHubbub) hub advertised by the feed. HubTopic is the
+	// URL the feed is known by at the hub. HubLeaseExpiry is set when the
+	// hub verifies a subscription, and is cleared if the hub or topic
+	// changes. Hubs may only verify a subscription until HubPendingUntil,
+	// which is set when the subscription is requested.
+	HubURL          string       `json:"hub_url" bson:"hub_url"`
+	HubTopic        string       `json:"hub_topic" bson:"hub_topic"`
+	HubLeaseExpiry  utctime.Time `json:"hub_lease_expiry" bson:"hub_lease_expiry"`
+	HubPendingUntil utctime.Time `json:"hub_pending_until" bson:"hub_pending_until"`
+
 	Category struct {
 		Name          string   `json:"name" bson:"name"`
 		Subcategories []string `json:"subcategories" bson:"subcategories"`
 	} `json:"category"`
 
 	ImageColors []RGB `json:"image_colors" bson:"image_colors"`
+
+	Description string `json:"description" bson:"description"`
+	Link        string `json:"link" bson:"link"`
+	Language    string `json:"language" bson:"language"`
+	Copyright   string `json:"copyright" bson:"copyright"`
+	Explicit    bool   `json:"explicit" bson:"explicit"`
+
+	// Type is either episodic or serial
+	Type string `json:"type" bson:"type"`
+
+	// Categories holds every category in the feed. Category is the first.
+	Categories []Category `json:"categories" bson:"categories,omitempty"`
+
+	Owner struct {
+		Name  string `json:"name" bson:"name"`
+		Email string `json:"email" bson:"email"`
+	} `json:"owner" bson:"owner"`
+
+	// Blocked feeds are hidden from search
+	Blocked bool `json:"blocked" bson:"blocked"`
+
+	// Complete feeds will not publish new episodes and are scraped less often
+	Complete bool `json:"complete" bson:"complete"`
+
+	// URLAliases holds the URLs the feed has moved from. It is maintained
+	// by Update whenever URL changes.
+	URLAliases []string `json:"url_aliases" bson:"url_aliases,omitempty" index:"url_aliases"`
+
+	// MergedIDs holds the IDs of the duplicate feeds that were merged into
+	// this one by MergeFeeds. ResolveFeed resolves them to this feed.
+	MergedIDs []ID `json:"merged_ids" bson:"merged_ids,omitempty" index:"merged_ids"`
+
+	// Podcasting 2.0 namespace
+	PodcastGUID string    `json:"podcast_guid" bson:"podcast_guid"`
+	Locked      bool      `json:"locked" bson:"locked"`
+	Funding     []Funding `json:"funding" bson:"funding,omitempty"`
+	Persons     []Person  `json:"persons" bson:"persons,omitempty"`
 }
 
 type Item struct {
@@ -52,6 +162,29 @@ type Item struct {
 	CreationTime     utctime.Time  `json:"creation_time" bson:"creation_time"`
 	ModificationTime utctime.Time  `json:"modification_time" bson:"modification_time"`
 	ImageURL         string        `json:"image_url" bson:"image_url"`
+
+	// RemovedTime is set once the item no longer appears in its feed
+	RemovedTime *utctime.Time `json:"removed_time" bson:"removed_time,omitempty"`
+
+	// Keys set by the worker to match an item whose GUID has changed
+	MediaURLKey string `json:"media_url_key" bson:"media_url_key,omitempty" index:"media_url_key"`
+	ContentKey  string `json:"content_key" bson:"content_key,omitempty" index:"content_key"`
+
+	// EpisodeType is one of full, trailer or bonus
+	EpisodeType string `json:"episode_type" bson:"episode_type"`
+	Explicit    bool   `json:"explicit" bson:"explicit"`
+
+	// Season and Episode are taken from the podcast namespace, falling
+	// back to their iTunes equivalents
+	Season         int     `json:"season" bson:"season"`
+	SeasonName     string  `json:"season_name" bson:"season_name"`
+	Episode        float64 `json:"episode" bson:"episode"`
+	EpisodeDisplay string  `json:"episode_display" bson:"episode_display"`
+
+	// Podcasting 2.0 namespace
+	Transcripts []Transcript `json:"transcripts" bson:"transcripts,omitempty"`
+	Chapters    *Chapters    `json:"chapters" bson:"chapters,omitempty"`
+	Persons     []Person     `json:"persons" bson:"persons,omitempty"`
 }
 
 type FeedCollection struct {
@@ -66,7 +199,34 @@ func (c FeedCollection) FeedByID(id ID) (*Feed, error) {
 	return &feed, nil
 }
 
+// ResolveFeed returns the feed with the given ID, or the feed it was
+// merged into.
+func (c FeedCollection) ResolveFeed(id ID) (*Feed, error) {
+	var feed Feed
+	err := c.Find(&Query{Filter: M{"$or": []M{{"_id": id}, {"merged_ids": id}}}}).One(&feed)
+	if err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+// ErrDuplicateURL is returned by Create if a feed with an equivalent URL
+// exists.
+var ErrDuplicateURL = errors.New("feed with an equivalent url exists")
+
+// Create creates the feed with its URL in canonical form.
 func (c FeedCollection) Create(feed *Feed) error {
+	feed.URL = CanonicalURL(feed.URL)
+
+	// The unique index only catches URLs that are written the same way
+	n, err := c.Find(&Query{Filter: c.URLFilter(feed.URL)}).Count()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return ErrDuplicateURL
+	}
+
 	feed.ID = NewID()
 	feed.CreationTime = utctime.Now()
 	feed.ModificationTime = utctime.Now()
@@ -79,7 +239,7 @@ func (c FeedCollection) Update(feed *Feed) error {
 		return err
 	}
 
-	ignoredFields := []string{"ID", "CreationTime", "ModificationTime"}
+	ignoredFields := []string{"ID", "CreationTime", "ModificationTime", "URLAliases", "MergedIDs", "HubLeaseExpiry"}
 	// Ignore Category if both are equal in the case where both subcats are 0 len
 	// This is necessary due to how DeepEqual and JSON/BSON unmarshalling work.
 	// BSON unmarshalling will still make the slice even if there is no subcat,
@@ -95,13 +255,190 @@ func (c FeedCollection) Update(feed *Feed) error {
 		ignoredFields = append(ignoredFields, "Category")
 	}
 
+	if feed.URL != origFeed.URL {
+		origFeed.URLAliases = moveURL(origFeed.URLAliases, origFeed.URL, feed.URL)
+	}
+
+	// A subscription to the old hub or topic does not carry over
+	if feed.HubURL != origFeed.HubURL || feed.HubTopic != origFeed.HubTopic {
+		origFeed.HubLeaseExpiry = utctime.Time{}
+	}
+
 	if CopyModel(origFeed, feed, ignoredFields...) {
 		origFeed.ModificationTime = utctime.Now()
 	}
+	feed.URLAliases = origFeed.URLAliases
+	feed.MergedIDs = origFeed.MergedIDs
+	feed.HubLeaseExpiry = origFeed.HubLeaseExpiry
 
 	return c.c.UpdateId(origFeed.ID, &origFeed)
 }
 
+// SetHubLeaseExpiry records the expiry of the feed's WebSub subscription
+// and closes its pending subscription. A zero expiry marks the feed as not
+// subscribed.
+func (c FeedCollection) SetHubLeaseExpiry(id ID, expiry time.Time) error {
+	return c.c.UpdateId(id, M{"$set": M{
+		"hub_lease_expiry":  expiry.UTC(),
+		"hub_pending_until": time.Time{},
+		"modification_time": utctime.Now(),
+	}})
+}
+
+// moveURL returns aliases with from added and to removed.
+func moveURL(aliases []string, from, to string) []string {
+	var out []string
+	for _, s := range aliases {
+		if s != from && s != to {
+			out = append(out, s)
+		}
+	}
+	if from != "" {
+		out = append(out, from)
+	}
+	return out
+}
+
+// DueFilter matches the feeds that are due to be updated at the given time.
+func (c FeedCollection) DueFilter(now time.Time) M {
+	return M{
+		"disabled": M{"$ne": true},
+		"$or": []M{
+			{"next_check_time": M{"$lte": now}},
+			{"next_check_time": M{"$exists": false}},
+		},
+	}
+}
+
+// LeaseDue holds back the feed with the given ID until the given time if it
+// is due to be updated at now. Only next_check_time is written, and only if
+// the feed is still due, so that concurrent updates to the feed are kept and
+// a due feed is leased by one caller at most. It reports whether the feed
+// was leased.
+func (c FeedCollection) LeaseDue(id ID, now, until time.Time) (bool, error) {
+	filter := c.DueFilter(now)
+	filter["_id"] = id
+
+	err := c.c.Update(filter, M{"$set": M{"next_check_time": until.UTC()}})
+	if err == ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Feed health states, as used by HealthFilter
+const (
+	FeedHealthy  = "healthy"
+	FeedFailing  = "failing"
+	FeedDisabled = "disabled"
+)
+
+// HealthFilter matches the feeds in the given health state. Failing feeds
+// include those that have been disabled.
+func (c FeedCollection) HealthFilter(health string) (M, error) {
+	switch health {
+	case FeedHealthy:
+		return M{"consecutive_failures": 0, "disabled": M{"$ne": true}}, nil
+	case FeedFailing:
+		return M{"$or": []M{
+			{"consecutive_failures": M{"$gt": 0}},
+			{"disabled": true},
+		}}, nil
+	case FeedDisabled:
+		return M{"disabled": true}, nil
+	default:
+		return nil, fmt.Errorf("unknown feed health: %q", health)
+	}
+}
+
+// URLFilter matches the feed with the given URL, or the feed that has
+// moved from it. URLs match if they are equivalent once in canonical
+// form, whether they use http or https.
+func (c FeedCollection) URLFilter(url string) M {
+	variants := M{"$in": urlVariants(url)}
+	return M{"$or": []M{{"url": variants}, {"url_aliases": variants}}}
+}
+
+// DuplicateFeeds returns the groups of feeds that are the same show. Feeds
+// are the same show if their URLs, or the URLs they have moved from, are
+// equivalent, or if they share an iTunes ID or podcast GUID. Feeds are
+// returned with only the fields used to tell them apart, and each group is
+// ordered by creation time.
+func (c FeedCollection) DuplicateFeeds() ([][]Feed, error) {
+	var feeds []Feed
+	query := Query{
+		SelectedFields: []string{"url", "url_aliases", "itunes_id", "podcast_guid", "title", "creation_time"},
+		SortField:      "creation_time",
+	}
+	if err := c.Find(&query).All(&feeds); err != nil {
+		return nil, err
+	}
+	return groupDuplicates(feeds), nil
+}
+
+// duplicateKeys returns the keys shared by the feeds that are the same
+// show as feed.
+func duplicateKeys(feed *Feed) []string {
+	var keys []string
+	for _, u := range append([]string{feed.URL}, feed.URLAliases...) {
+		keys = append(keys, "url "+urlKey(u))
+	}
+	if feed.ITunesID != 0 {
+		keys = append(keys, fmt.Sprintf("itunes %d", feed.ITunesID))
+	}
+	if guid := strings.ToLower(strings.TrimSpace(feed.PodcastGUID)); guid != "" {
+		keys = append(keys, "guid "+guid)
+	}
+	return keys
+}
+
+// groupDuplicates groups the feeds that share a key, keeping the order of
+// feeds. Feeds without duplicates are left out.
+func groupDuplicates(feeds []Feed) [][]Feed {
+	// Union-find over the feeds, joined through their keys
+	parent := make([]int, len(feeds))
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	owners := make(map[string]int)
+	for i := range feeds {
+		parent[i] = i
+		for _, key := range duplicateKeys(&feeds[i]) {
+			if j, ok := owners[key]; ok {
+				parent[find(i)] = find(j)
+			} else {
+				owners[key] = i
+			}
+		}
+	}
+
+	members := make(map[int][]Feed)
+	var roots []int
+	for i := range feeds {
+		root := find(i)
+		if _, ok := members[root]; !ok {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], feeds[i])
+	}
+
+	var groups [][]Feed
+	for _, root := range roots {
+		if len(members[root]) > 1 {
+			groups = append(groups, members[root])
+		}
+	}
+	return groups
+}
+
 type ItemCollection struct {
 	collection
 }
@@ -117,6 +454,12 @@ func (c ItemCollection) Create(item *Item) error {
 	}
 	item.CreationTime = utctime.Now()
 	item.ModificationTime = utctime.Now()
+
+	// Items without a GUID cannot be told apart, upserting them would
+	// collapse them into a single item
+	if item.GUID == "" {
+		return c.insert(item)
+	}
 	return c.upsert(M{"guid": item.GUID, "feed_id": item.FeedID}, item)
 }
 
@@ -127,12 +470,69 @@ func (c ItemCollection) Update(item *Item) error {
 	}
 
 	if CopyModel(&origItem, item, "CreationTime", "ModificationTime") {
-		item.ModificationTime = utctime.Now()
+		origItem.ModificationTime = utctime.Now()
+		item.ModificationTime = origItem.ModificationTime
 	}
 
 	return c.c.UpdateId(origItem.ID, &origItem)
 }
 
+// NotRemovedFilter matches the items that have not been removed from their
+// feed.
+func (c ItemCollection) NotRemovedFilter() M {
+	return M{"removed_time": nil}
+}
+
+// MatchingItems returns the items of the feed, including removed items,
+// that have any of the given GUIDs, media URL keys or content keys.
+func (c ItemCollection) MatchingItems(feedID ID, guids, mediaURLKeys, contentKeys []string) ([]Item, error) {
+	var or []M
+	for field, values := range map[string][]string{
+		"guid":          guids,
+		"media_url_key": mediaURLKeys,
+		"content_key":   contentKeys,
+	} {
+		if len(values) > 0 {
+			or = append(or, M{field: M{"$in": values}})
+		}
+	}
+
+	var items []Item
+	if len(or) == 0 {
+		return items, nil
+	}
+
+	err := c.Find(&Query{Filter: M{"feed_id": feedID, "$or": or}}).All(&items)
+	return items, err
+}
+
+// MarkRemoved marks the items of the feed that are not in keepIDs and have
+// not already been removed as removed at the given time. It returns the
+// number of items marked.
+func (c ItemCollection) MarkRemoved(feedID ID, keepIDs []ID, t time.Time) (int, error) {
+	// $nin requires an array
+	if keepIDs == nil {
+		keepIDs = []ID{}
+	}
+
+	filter := M{
+		"feed_id": feedID,
+		"_id":     M{"$nin": keepIDs},
+	}
+	for k, v := range c.NotRemovedFilter() {
+		filter[k] = v
+	}
+
+	info, err := c.c.UpdateAll(filter, M{"$set": M{
+		"removed_time":      t.UTC(),
+		"modification_time": utctime.Now(),
+	}})
+	if err != nil {
+		return 0, err
+	}
+	return info.Updated, nil
+}
+
 func (c ItemCollection) ItemsWithFeedID(feedID ID) *Result {
 	return c.Find(&Query{
 		Filter: M{"feed_id": feedID},
@@ -1,6 +1,10 @@
 package db
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+	"time"
+)
 
 func createFeed(t *testing.T, db *DB, feed *Feed) *Feed {
 	if err := db.Feeds.Create(feed); err != nil {
@@ -30,6 +34,74 @@ func TestCreateFeed(t *testing.T) {
 	}
 }
 
+func TestCreateFeed_EquivalentURL(t *testing.T) {
+	db := newDB()
+
+	feed := createFeed(t, db, &Feed{URL: "HTTP://Feeds.FeedBurner.com/Show/?format=xml"})
+	if feed.URL != "http://feeds.feedburner.com/Show" {
+		t.Errorf("URL was not made canonical: %s", feed.URL)
+	}
+
+	for _, u := range []string{"https://feeds.feedburner.com/Show", "http://feeds.feedburner.com/Show/"} {
+		if err := db.Feeds.Create(&Feed{URL: u}); !IsDup(err) {
+			t.Errorf("Expected a duplicate error for %s, got %v", u, err)
+		}
+	}
+}
+
+func TestDuplicateFeeds(t *testing.T) {
+	db := newDB()
+
+	// Created before URLs were made canonical
+	a := createFeed(t, db, &Feed{URL: "http://example.com/a"})
+	b := createFeed(t, db, &Feed{URL: "http://example.com/b"})
+	if err := db.Feeds.c.Insert(&Feed{ID: NewID(), URL: "https://example.com/a/"}); err != nil {
+		t.Fatal(err)
+	}
+
+	groups, err := db.Feeds.DuplicateFeeds()
+	if err != nil {
+		t.Fatal("DuplicateFeeds failed:", err)
+	}
+	if len(groups) != 1 || len(groups[0]) != 2 || groups[0][0].ID != a.ID {
+		t.Errorf("Unexpected groups: %v", groups)
+	}
+	for _, feed := range groups[0] {
+		if feed.ID == b.ID {
+			t.Errorf("Unexpected feed in group: %v", feed)
+		}
+	}
+}
+
+func TestGroupDuplicates(t *testing.T) {
+	feeds := []Feed{
+		{Title: "a", URL: "http://example.com/a"},
+		{Title: "b", URL: "http://example.com/b"},
+		{Title: "a2", URL: "https://EXAMPLE.com/a/"},
+		{Title: "c", URL: "http://example.com/c", URLAliases: []string{"http://example.com/b"}},
+		{Title: "d", URL: "http://example.com/d"},
+		{Title: "a3", URL: "https://example.com/new", URLAliases: []string{"http://example.com/a#old"}},
+		{Title: "e", URL: "http://example.com/e", ITunesID: 42},
+		{Title: "f", URL: "http://example.com/f", PodcastGUID: "917393E3-1B1E-5CEF-ACE4-EDAA54E1F810"},
+		{Title: "e2", URL: "http://other.com/e", ITunesID: 42},
+		{Title: "f2", URL: "http://other.com/f", PodcastGUID: "917393e3-1b1e-5cef-ace4-edaa54e1f810 "},
+	}
+
+	var titles [][]string
+	for _, group := range groupDuplicates(feeds) {
+		var names []string
+		for _, feed := range group {
+			names = append(names, feed.Title)
+		}
+		titles = append(titles, names)
+	}
+
+	expected := [][]string{{"a", "a2", "a3"}, {"b", "c"}, {"e", "e2"}, {"f", "f2"}}
+	if !reflect.DeepEqual(titles, expected) {
+		t.Errorf("groupDuplicates = %v, expected %v", titles, expected)
+	}
+}
+
 func TestUpdateItem_NoModification(t *testing.T) {
 	db := newDB()
 
@@ -53,6 +125,46 @@ func TestUpdateItem_NoModification(t *testing.T) {
 	}
 }
 
+func TestUpdateItem_Removed(t *testing.T) {
+	db := newDB()
+
+	item := &Item{
+		GUID:   "http://google.com/1",
+		FeedID: createFeed(t, db, &Feed{URL: "http://google.com"}).ID,
+	}
+	createItem(t, db, item)
+
+	modTime := item.ModificationTime
+	removedTime := modTime.Add(time.Second)
+
+	// Stored times have millisecond precision
+	time.Sleep(10 * time.Millisecond)
+	item.RemovedTime = &removedTime
+	if err := db.Items.Update(item); err != nil {
+		t.Fatal("Could not update item:", err)
+	}
+
+	var out Item
+	if err := db.Items.FindByID(item.ID).One(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if out.RemovedTime == nil || !out.RemovedTime.Equal(removedTime) {
+		t.Errorf("RemovedTime mismatch: %v != %v", out.RemovedTime, removedTime)
+	}
+	if !modTime.Before(out.ModificationTime) {
+		t.Errorf("ModificationTime was not updated: %v", out.ModificationTime)
+	}
+
+	n, err := db.Items.Find(&Query{Filter: db.Items.NotRemovedFilter()}).Count()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Errorf("num items mismatch: %d != 0", n)
+	}
+}
+
 func TestItemsWithFeedID(t *testing.T) {
 	db := newDB()
 
@@ -73,6 +185,114 @@ func TestItemsWithFeedID(t *testing.T) {
 	}
 }
 
+func TestLeaseDue(t *testing.T) {
+	db := newDB()
+
+	feed := createFeed(t, db, &Feed{URL: "http://google.com"})
+	now := time.Now()
+
+	leased, err := db.Feeds.LeaseDue(feed.ID, now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal("LeaseDue failed:", err)
+	}
+	if !leased {
+		t.Error("due feed was not leased")
+	}
+
+	leased, err = db.Feeds.LeaseDue(feed.ID, now.Add(time.Minute), now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal("LeaseDue failed:", err)
+	}
+	if leased {
+		t.Error("leased feed was leased again")
+	}
+}
+
+func TestMatchingItems(t *testing.T) {
+	db := newDB()
+
+	feed := createFeed(t, db, &Feed{URL: "http://google.com"})
+	other := createFeed(t, db, &Feed{URL: "http://yahoo.com"})
+
+	byGUID := createItem(t, db, &Item{GUID: "a", FeedID: feed.ID})
+	byMediaURL := createItem(t, db, &Item{GUID: "b", MediaURLKey: "google.com/b.mp3", FeedID: feed.ID})
+	byContent := createItem(t, db, &Item{GUID: "c", ContentKey: "c", FeedID: feed.ID})
+	createItem(t, db, &Item{GUID: "d", FeedID: feed.ID})
+	createItem(t, db, &Item{GUID: "a", FeedID: other.ID})
+
+	items, err := db.Items.MatchingItems(feed.ID, []string{"a"}, []string{"google.com/b.mp3"}, []string{"c"})
+	if err != nil {
+		t.Fatal("MatchingItems failed:", err)
+	}
+
+	found := make(map[ID]bool)
+	for _, item := range items {
+		found[item.ID] = true
+	}
+	for _, item := range []*Item{byGUID, byMediaURL, byContent} {
+		if !found[item.ID] {
+			t.Errorf("item %q was not matched", item.GUID)
+		}
+	}
+	if len(items) != 3 {
+		t.Errorf("num items mismatch: %d != 3", len(items))
+	}
+
+	items, err = db.Items.MatchingItems(feed.ID, nil, nil, nil)
+	if err != nil {
+		t.Fatal("MatchingItems failed:", err)
+	}
+	if len(items) != 0 {
+		t.Errorf("num items mismatch: %d != 0", len(items))
+	}
+}
+
+func TestMarkRemoved(t *testing.T) {
+	db := newDB()
+
+	feed := createFeed(t, db, &Feed{URL: "http://google.com"})
+	other := createFeed(t, db, &Feed{URL: "http://yahoo.com"})
+
+	kept := createItem(t, db, &Item{GUID: "a", FeedID: feed.ID})
+	removed := createItem(t, db, &Item{GUID: "b", FeedID: feed.ID})
+	otherItem := createItem(t, db, &Item{GUID: "c", FeedID: other.ID})
+
+	removedTime := time.Date(2016, time.April, 11, 1, 15, 0, 0, time.UTC)
+	n, err := db.Items.MarkRemoved(feed.ID, []ID{kept.ID}, removedTime)
+	if err != nil {
+		t.Fatal("MarkRemoved failed:", err)
+	}
+	if n != 1 {
+		t.Errorf("num removed mismatch: %d != 1", n)
+	}
+
+	for _, c := range []struct {
+		Item    *Item
+		Removed bool
+	}{
+		{kept, false},
+		{removed, true},
+		{otherItem, false},
+	} {
+		var out Item
+		if err := db.Items.FindByID(c.Item.ID).One(&out); err != nil {
+			t.Fatal(err)
+		}
+		if (out.RemovedTime != nil) != c.Removed {
+			t.Errorf("item %q: RemovedTime = %v, expected removed: %t", c.Item.GUID, out.RemovedTime, c.Removed)
+		}
+	}
+
+	// Items that have already been removed are left alone
+	n, err = db.Items.MarkRemoved(feed.ID, []ID{kept.ID}, removedTime.Add(time.Hour))
+	if err != nil {
+		t.Fatal("MarkRemoved failed:", err)
+	}
+	if n != 0 {
+		t.Errorf("num removed mismatch: %d != 0", n)
+	}
+}
+
 func TestCreateItem(t *testing.T) {
 	db := newDB()
 
@@ -115,3 +335,62 @@ func TestCreateItem_Dupe(t *testing.T) {
 		t.Errorf("num items mismatch: %d != 1", n)
 	}
 }
+
+func TestCreateItem_NoGUID(t *testing.T) {
+	db := newDB()
+
+	feedID := createFeed(t, db, &Feed{URL: "http://google.com"}).ID
+	createItem(t, db, &Item{Title: "Episode 1", FeedID: feedID})
+	createItem(t, db, &Item{Title: "Episode 2", FeedID: feedID})
+
+	n, err := db.Items.Find(nil).Count()
+	if err != nil {
+		t.Fatalf("find items failed: %s", err)
+	}
+
+	if n != 2 {
+		t.Errorf("num items mismatch: %d != 2", n)
+	}
+}
+
+func TestUpdateFeed_URLAliases(t *testing.T) {
+	db := newDB()
+
+	feed := createFeed(t, db, &Feed{URL: "http://google.com/a"})
+
+	for _, url := range []string{"http://google.com/b", "http://google.com/c", "http://google.com/a"} {
+		update := *feed
+		update.URL = url
+		update.URLAliases = nil
+		if err := db.Feeds.Update(&update); err != nil {
+			t.Fatal("Could not update feed:", err)
+		}
+	}
+
+	out, err := db.Feeds.FeedByID(feed.ID)
+	if err != nil {
+		t.Fatal("FeedByID failed:", err)
+	}
+
+	if out.URL != "http://google.com/a" {
+		t.Errorf("URL mismatch: %s", out.URL)
+	}
+
+	expected := []string{"http://google.com/b", "http://google.com/c"}
+	if len(out.URLAliases) != len(expected) {
+		t.Fatalf("URLAliases mismatch: %v != %v", out.URLAliases, expected)
+	}
+	for i := range expected {
+		if out.URLAliases[i] != expected[i] {
+			t.Errorf("URLAliases mismatch: %v != %v", out.URLAliases, expected)
+		}
+	}
+
+	var byAlias Feed
+	if err := db.Feeds.Find(&Query{Filter: db.Feeds.URLFilter("http://google.com/b")}).One(&byAlias); err != nil {
+		t.Fatal("Could not find feed by alias:", err)
+	}
+	if byAlias.ID != feed.ID {
+		t.Errorf("ID mismatch: %s != %s", byAlias.ID, feed.ID)
+	}
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/cjlucas/unnamedcast/db/utctime"
+)
+
+// MergeFeeds merges a duplicate feed into the feed that is kept. The items
+// of the duplicate are moved to the kept feed, except for those whose GUID
+// the kept feed already has, which are replaced by the kept feed's items.
+// Subscribers of the duplicate are subscribed to the kept feed instead, and
+// their item states follow the items unless they have a state for the
+// replacing item already. The duplicate's ID and URLs are recorded on the
+// kept feed so that they still resolve to it.
+//
+// The steps are not atomic. A merge that fails part way can be run again.
+func (db *DB) MergeFeeds(keepID, dupID ID) error {
+	if keepID == dupID {
+		return errors.New("cannot merge a feed into itself")
+	}
+
+	keep, err := db.Feeds.FeedByID(keepID)
+	if err != nil {
+		return err
+	}
+	dup, err := db.Feeds.FeedByID(dupID)
+	if err != nil {
+		return err
+	}
+
+	// Recorded first so that the duplicate resolves to the kept feed while
+	// the rest is moved
+	if err := db.Feeds.addMerged(keep, dup); err != nil {
+		return err
+	}
+
+	replaced, err := db.Items.moveItems(keepID, dupID)
+	if err != nil {
+		return err
+	}
+
+	// The replaced items are only removed once nothing refers to them, so
+	// that a failed merge can be run again
+	if err := db.Users.moveFeed(keepID, dupID, replaced); err != nil {
+		return err
+	}
+
+	if len(replaced) > 0 {
+		var ids []ID
+		for id := range replaced {
+			ids = append(ids, id)
+		}
+		if _, err := db.Items.c.RemoveAll(M{"_id": M{"$in": ids}}); err != nil {
+			return err
+		}
+	}
+
+	return db.Feeds.c.RemoveId(dupID)
+}
+
+// addMerged records the ID and URLs of a feed merged into keep, along with
+// those of the feeds that were merged into it.
+func (c FeedCollection) addMerged(keep, dup *Feed) error {
+	ids := append([]ID{dup.ID}, dup.MergedIDs...)
+
+	var urls []string
+	for _, u := range append([]string{dup.URL}, dup.URLAliases...) {
+		if u != keep.URL {
+			urls = append(urls, u)
+		}
+	}
+
+	return c.c.UpdateId(keep.ID, M{
+		"$addToSet": M{
+			"merged_ids":  M{"$each": ids},
+			"url_aliases": M{"$each": urls},
+		},
+		"$set": M{"modification_time": utctime.Now()},
+	})
+}
+
+// moveItems moves the items of the duplicate feed to the kept feed. Items
+// with a GUID the kept feed already has are left in place, and are
+// returned mapped to the kept feed's item with that GUID.
+func (c ItemCollection) moveItems(keepID, dupID ID) (map[ID]ID, error) {
+	var kept, dups []Item
+	query := Query{
+		Filter:         M{"feed_id": keepID, "guid": M{"$ne": ""}},
+		SelectedFields: []string{"guid"},
+	}
+	if err := c.Find(&query).All(&kept); err != nil {
+		return nil, err
+	}
+
+	query = Query{
+		Filter:         M{"feed_id": dupID},
+		SelectedFields: []string{"guid"},
+	}
+	if err := c.Find(&query).All(&dups); err != nil {
+		return nil, err
+	}
+
+	byGUID := make(map[string]ID)
+	for _, item := range kept {
+		byGUID[item.GUID] = item.ID
+	}
+
+	replaced := make(map[ID]ID)
+	var moved []ID
+	for _, item := range dups {
+		if id, ok := byGUID[item.GUID]; ok && item.GUID != "" {
+			replaced[item.ID] = id
+		} else {
+			moved = append(moved, item.ID)
+		}
+	}
+
+	if len(moved) > 0 {
+		_, err := c.c.UpdateAll(M{"_id": M{"$in": moved}}, M{"$set": M{
+			"feed_id":           keepID,
+			"modification_time": utctime.Now(),
+		}})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return replaced, nil
+}
+
+// moveFeed moves the subscriptions to the duplicate feed to the kept feed,
+// and the item states of replaced items to the items that replaced them.
+// Users that already have a state for the item that replaced another keep
+// that state. Only the affected elements are updated, so that changes made
+// to users during the merge are not lost.
+func (c UserCollection) moveFeed(keepID, dupID ID, replaced map[ID]ID) error {
+	updateAll := func(filter, change M) error {
+		_, err := c.c.UpdateAll(filter, change)
+		return err
+	}
+
+	// A field cannot be added to and pulled from in the same update
+	err := updateAll(M{"feed_ids": dupID}, M{
+		"$addToSet": M{"feed_ids": keepID},
+		"$set":      M{"modification_time": utctime.Now()},
+	})
+	if err != nil {
+		return err
+	}
+	err = updateAll(M{"feed_ids": dupID}, M{"$pull": M{"feed_ids": dupID}})
+	if err != nil {
+		return err
+	}
+
+	for oldID, newID := range replaced {
+		err := updateAll(M{"states.item_id": M{"$all": []ID{oldID, newID}}}, M{
+			"$pull": M{"states": M{"item_id": oldID}},
+			"$set":  M{"modification_time": utctime.Now()},
+		})
+		if err != nil {
+			return err
+		}
+
+		now := utctime.Now()
+		err = updateAll(M{"states.item_id": oldID}, M{"$set": M{
+			"states.$.item_id":           newID,
+			"states.$.modification_time": now,
+			"modification_time":          now,
+		}})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/cjlucas/unnamedcast/db/utctime"
+)
+
+func TestMergeFeeds(t *testing.T) {
+	db := newDB()
+
+	keep := createFeed(t, db, &Feed{URL: "https://example.com/feed", ITunesID: 42})
+	dup := &Feed{ID: NewID(), URL: "http://example.com/feed/", URLAliases: []string{"http://example.com/old"}}
+	if err := db.Feeds.c.Insert(dup); err != nil {
+		t.Fatal(err)
+	}
+
+	keptItem := createItem(t, db, &Item{FeedID: keep.ID, GUID: "1"})
+	replacedItem := createItem(t, db, &Item{FeedID: dup.ID, GUID: "1"})
+	movedItem := createItem(t, db, &Item{FeedID: dup.ID, GUID: "2"})
+
+	user, _ := db.Users.Create("chris", "hithere")
+	user.FeedIDs = []ID{dup.ID}
+	if err := db.Users.Update(user); err != nil {
+		t.Fatal(err)
+	}
+	now := utctime.Now()
+	stale := now.Add(-time.Hour)
+	if err := db.Users.UpsertItemState(user.ID, &ItemState{ItemID: replacedItem.ID, Position: 30, ModificationTime: stale}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Subscribed to both, with a state for both of the items with GUID 1
+	other := NewID()
+	both, _ := db.Users.Create("john", "hithere")
+	both.FeedIDs = []ID{keep.ID, other, dup.ID}
+	if err := db.Users.Update(both); err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range []ItemState{
+		{ItemID: keptItem.ID, Position: 10, ModificationTime: utctime.Now()},
+		{ItemID: replacedItem.ID, Position: 20, ModificationTime: utctime.Now()},
+		{ItemID: movedItem.ID, Position: 40, ModificationTime: utctime.Now()},
+	} {
+		if err := db.Users.UpsertItemState(both.ID, &state); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.MergeFeeds(keep.ID, dup.ID); err != nil {
+		t.Fatal("MergeFeeds failed:", err)
+	}
+
+	if _, err := db.Feeds.FeedByID(dup.ID); err != ErrNotFound {
+		t.Errorf("Expected the duplicate to be removed, got %v", err)
+	}
+
+	resolved, err := db.Feeds.ResolveFeed(dup.ID)
+	if err != nil {
+		t.Fatal("ResolveFeed failed:", err)
+	}
+	if resolved.ID != keep.ID {
+		t.Errorf("ID mismatch: %s != %s", resolved.ID, keep.ID)
+	}
+	if !reflect.DeepEqual(resolved.URLAliases, []string{"http://example.com/feed/", "http://example.com/old"}) {
+		t.Errorf("Unexpected URL aliases: %v", resolved.URLAliases)
+	}
+
+	var items []Item
+	if err := db.Items.ItemsWithFeedID(keep.ID).All(&items); err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Errorf("Unexpected # of items: %d != 2", len(items))
+	}
+	for _, item := range items {
+		if item.ID != keptItem.ID && item.ID != movedItem.ID {
+			t.Errorf("Unexpected item: %v", item)
+		}
+	}
+
+	var outUser User
+	if err := db.Users.FindByID(user.ID).One(&outUser); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(outUser.FeedIDs, []ID{keep.ID}) {
+		t.Errorf("Unexpected feed ids: %v", outUser.FeedIDs)
+	}
+	if len(outUser.ItemStates) != 1 || outUser.ItemStates[0].ItemID != keptItem.ID || outUser.ItemStates[0].Position != 30 {
+		t.Errorf("Unexpected item states: %v", outUser.ItemStates)
+	} else if outUser.ItemStates[0].ModificationTime.Before(now.Add(-time.Second)) {
+		t.Errorf("Item state modification time was not updated: %s",
+			outUser.ItemStates[0].ModificationTime.Format(time.RFC3339))
+	}
+
+	if err := db.Users.FindByID(both.ID).One(&outUser); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(outUser.FeedIDs, []ID{keep.ID, other}) {
+		t.Errorf("Unexpected feed ids: %v", outUser.FeedIDs)
+	}
+	positions := make(map[ID]float64)
+	for _, state := range outUser.ItemStates {
+		positions[state.ItemID] = state.Position
+	}
+	if !reflect.DeepEqual(positions, map[ID]float64{keptItem.ID: 10, movedItem.ID: 40}) {
+		t.Errorf("Unexpected item states: %v", outUser.ItemStates)
+	}
+}
@@ -154,6 +154,12 @@ func fieldsEqual(a, b reflect.Value) bool {
 	switch t := inA.(type) {
 	case utctime.Time:
 		return t.Equal(inB.(utctime.Time))
+	case *utctime.Time:
+		u := inB.(*utctime.Time)
+		if t == nil || u == nil {
+			return t == u
+		}
+		return t.Equal(*u)
 	}
 
 	return reflect.DeepEqual(inA, inB)
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/cjlucas/unnamedcast/db/utctime"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// SessionLifetime is the duration a session is valid for after creation.
+const SessionLifetime = 30 * 24 * time.Hour
+
+// PendingSessionLifetime is the duration a user has to complete the second
+// step of a two-step login.
+const PendingSessionLifetime = 5 * time.Minute
+
+// MaxPendingSessionAttempts is the number of incorrect one-time codes
+// accepted before a pending session is revoked.
+const MaxPendingSessionAttempts = 5
+
+// Session represents an authenticated login. The token handed to the client
+// is never persisted, only its hash, so a leaked database cannot be used to
+// impersonate users.
+type Session struct {
+	ID             ID           `json:"id" bson:"_id,omitempty"`
+	UserID         ID           `json:"user_id" bson:"user_id" index:"user_id"`
+	TokenHash      string       `json:"token_hash" bson:"token_hash" index:",unique"`
+	CreationTime   utctime.Time `json:"creation_time" bson:"creation_time"`
+	ExpirationTime utctime.Time `json:"expiration_time" bson:"expiration_time"`
+
+	// Pending sessions have passed the password step of a two-step login
+	// but not the one-time code step. They do not authenticate requests.
+	Pending  bool `json:"pending" bson:"pending,omitempty"`
+	Attempts int  `json:"attempts" bson:"attempts,omitempty"`
+}
+
+type SessionCollection struct {
+	collection
+}
+
+func newToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c SessionCollection) create(userID ID, lifetime time.Duration, pending bool) (*Session, string, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := utctime.Now()
+	session := Session{
+		ID:             NewID(),
+		UserID:         userID,
+		TokenHash:      hashToken(token),
+		CreationTime:   now,
+		ExpirationTime: now.Add(lifetime),
+		Pending:        pending,
+	}
+
+	if err := c.insert(&session); err != nil {
+		return nil, "", err
+	}
+
+	return &session, token, nil
+}
+
+// Create starts a new session for the given user. The returned token is the
+// only copy of the session's secret and must be given to the client.
+func (c SessionCollection) Create(userID ID) (*Session, string, error) {
+	return c.create(userID, SessionLifetime, false)
+}
+
+// CreatePending starts a pending session for a user that must complete
+// a second login step. See Create for details on the returned token.
+func (c SessionCollection) CreatePending(userID ID) (*Session, string, error) {
+	return c.create(userID, PendingSessionLifetime, true)
+}
+
+func (c SessionCollection) sessionByToken(token string, pending bool) (*Session, error) {
+	filter := M{
+		"token_hash":      hashToken(token),
+		"expiration_time": M{"$gt": utctime.Now()},
+	}
+	if pending {
+		filter["pending"] = true
+	} else {
+		filter["pending"] = M{"$ne": true}
+	}
+
+	var session Session
+	if err := c.Find(&Query{Filter: filter}).One(&session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// SessionByToken returns the unexpired session for the given token.
+// ErrNotFound is returned if no such session exists.
+func (c SessionCollection) SessionByToken(token string) (*Session, error) {
+	return c.sessionByToken(token, false)
+}
+
+// PendingSessionByToken returns the unexpired pending session for the
+// given token. ErrNotFound is returned if no such session exists.
+func (c SessionCollection) PendingSessionByToken(token string) (*Session, error) {
+	return c.sessionByToken(token, true)
+}
+
+// RecordFailedAttempt counts an incorrect one-time code against the given
+// pending session, revoking it once MaxPendingSessionAttempts is reached.
+func (c SessionCollection) RecordFailedAttempt(session *Session) error {
+	session.Attempts++
+	if session.Attempts >= MaxPendingSessionAttempts {
+		return c.Revoke(session.ID)
+	}
+
+	return c.c.UpdateId(session.ID, bson.M{
+		"$inc": bson.M{"attempts": 1},
+	})
+}
+
+// Revoke deletes the session with the given ID.
+func (c SessionCollection) Revoke(id ID) error {
+	return c.c.RemoveId(id)
+}
+
+// RevokeAll deletes every session belonging to the given user.
+func (c SessionCollection) RevokeAll(userID ID) error {
+	_, err := c.c.RemoveAll(bson.M{"user_id": userID})
+	return err
+}
+
+// RevokeOthers deletes every session belonging to the given user except
+// the session with the given ID.
+func (c SessionCollection) RevokeOthers(userID, sessionID ID) error {
+	_, err := c.c.RemoveAll(bson.M{
+		"user_id": userID,
+		"_id":     bson.M{"$ne": sessionID},
+	})
+	return err
+}
@@ -0,0 +1,87 @@
+package db
+
+import "testing"
+
+func TestSessionByToken(t *testing.T) {
+	db := newDB()
+
+	session, token, err := db.Sessions.Create(NewID())
+	if err != nil {
+		t.Fatal("Could not create session:", err)
+	}
+
+	out, err := db.Sessions.SessionByToken(token)
+	if err != nil {
+		t.Fatal("Could not find session:", err)
+	}
+
+	if out.ID != session.ID {
+		t.Errorf("id mismatch: %s != %s", out.ID, session.ID)
+	}
+
+	if _, err := db.Sessions.SessionByToken("badtoken"); err != ErrNotFound {
+		t.Errorf("unexpected error: %v != %v", err, ErrNotFound)
+	}
+}
+
+func TestSession_Revoke(t *testing.T) {
+	db := newDB()
+
+	session, token, _ := db.Sessions.Create(NewID())
+	if err := db.Sessions.Revoke(session.ID); err != nil {
+		t.Fatal("Could not revoke session:", err)
+	}
+
+	if _, err := db.Sessions.SessionByToken(token); err != ErrNotFound {
+		t.Errorf("unexpected error: %v != %v", err, ErrNotFound)
+	}
+}
+
+func TestSession_RevokeAll(t *testing.T) {
+	db := newDB()
+
+	userID := NewID()
+	_, token1, _ := db.Sessions.Create(userID)
+	_, token2, _ := db.Sessions.Create(userID)
+	if err := db.Sessions.RevokeAll(userID); err != nil {
+		t.Fatal("Could not revoke sessions:", err)
+	}
+
+	for _, token := range []string{token1, token2} {
+		if _, err := db.Sessions.SessionByToken(token); err != ErrNotFound {
+			t.Errorf("unexpected error: %v != %v", err, ErrNotFound)
+		}
+	}
+}
+
+func TestSession_Pending(t *testing.T) {
+	db := newDB()
+
+	session, token, err := db.Sessions.CreatePending(NewID())
+	if err != nil {
+		t.Fatal("Could not create session:", err)
+	}
+
+	// Pending sessions must not authenticate requests
+	if _, err := db.Sessions.SessionByToken(token); err != ErrNotFound {
+		t.Errorf("unexpected error: %v != %v", err, ErrNotFound)
+	}
+
+	out, err := db.Sessions.PendingSessionByToken(token)
+	if err != nil {
+		t.Fatal("Could not find pending session:", err)
+	}
+	if out.ID != session.ID {
+		t.Errorf("id mismatch: %s != %s", out.ID, session.ID)
+	}
+
+	for i := 0; i < MaxPendingSessionAttempts; i++ {
+		if err := db.Sessions.RecordFailedAttempt(out); err != nil {
+			t.Fatal("Could not record failed attempt:", err)
+		}
+	}
+
+	if _, err := db.Sessions.PendingSessionByToken(token); err != ErrNotFound {
+		t.Errorf("session was not revoked: %v != %v", err, ErrNotFound)
+	}
+}
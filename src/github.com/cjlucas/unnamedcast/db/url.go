@@ -0,0 +1,92 @@
+package db
+
+import (
+	"net/url"
+	"strings"
+)
+
+// feedBurnerHosts serve the same feed with and without a format parameter.
+var feedBurnerHosts = map[string]bool{
+	"feeds.feedburner.com":  true,
+	"feeds2.feedburner.com": true,
+	"feedproxy.google.com":  true,
+}
+
+// CanonicalURL returns the canonical form of a feed URL, so that the many
+// ways of writing the same URL are stored and looked up as one. The scheme
+// is kept as it is what the feed is fetched with, URLFilter treats http
+// and https as equivalent instead.
+//
+// URLs that are not http or https URLs are returned as is.
+func CanonicalURL(rawurl string) string {
+	rawurl = strings.TrimSpace(rawurl)
+
+	u, err := url.Parse(rawurl)
+	if err != nil || u.Host == "" {
+		return rawurl
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return rawurl
+	}
+
+	u.Host = strings.ToLower(u.Host)
+	if u.Scheme == "http" {
+		u.Host = strings.TrimSuffix(u.Host, ":80")
+	} else {
+		u.Host = strings.TrimSuffix(u.Host, ":443")
+	}
+
+	u.Path = strings.TrimRight(u.Path, "/")
+	u.RawPath = ""
+	u.Fragment = ""
+	u.ForceQuery = false
+
+	if feedBurnerHosts[u.Host] {
+		q := u.Query()
+		q.Del("format")
+		u.RawQuery = q.Encode()
+	}
+
+	return u.String()
+}
+
+// urlVariants returns the URLs that are equivalent to rawurl: as given, in
+// canonical form, and in canonical form with the other of http and https.
+func urlVariants(rawurl string) []string {
+	rawurl = strings.TrimSpace(rawurl)
+	canonical := CanonicalURL(rawurl)
+	variants := []string{rawurl}
+
+	add := func(s string) {
+		for _, v := range variants {
+			if v == s {
+				return
+			}
+		}
+		variants = append(variants, s)
+	}
+
+	add(canonical)
+	switch {
+	case strings.HasPrefix(canonical, "http://"):
+		add("https://" + strings.TrimPrefix(canonical, "http://"))
+	case strings.HasPrefix(canonical, "https://"):
+		add("http://" + strings.TrimPrefix(canonical, "https://"))
+	}
+
+	return variants
+}
+
+// urlKey identifies the feeds whose URLs are equivalent. It is the
+// canonical URL without its scheme.
+func urlKey(rawurl string) string {
+	canonical := CanonicalURL(rawurl)
+	for _, scheme := range []string{"http:", "https:"} {
+		if strings.HasPrefix(canonical, scheme) {
+			return strings.TrimPrefix(canonical, scheme)
+		}
+	}
+	return canonical
+}
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCanonicalURL(t *testing.T) {
+	cases := []struct {
+		URL      string
+		Expected string
+	}{
+		{" http://example.com/feed ", "http://example.com/feed"},
+		{"HTTP://Example.COM/Feed", "http://example.com/Feed"},
+		{"http://example.com/feed/", "http://example.com/feed"},
+		{"http://example.com/", "http://example.com"},
+		{"http://example.com:80/feed", "http://example.com/feed"},
+		{"https://example.com:443/feed", "https://example.com/feed"},
+		{"http://example.com:8080/feed", "http://example.com:8080/feed"},
+		{"http://example.com/feed#latest", "http://example.com/feed"},
+		{"http://example.com/feed?", "http://example.com/feed"},
+		{"http://example.com/feed?format=xml", "http://example.com/feed?format=xml"},
+		{"https://feeds.feedburner.com/Show?format=xml", "https://feeds.feedburner.com/Show"},
+		{"http://feeds.feedburner.com/Show/?fmt=1&format=rss", "http://feeds.feedburner.com/Show?fmt=1"},
+		{"ftp://example.com/feed/", "ftp://example.com/feed/"},
+		{"wrongurl", "wrongurl"},
+	}
+
+	for _, c := range cases {
+		if out := CanonicalURL(c.URL); out != c.Expected {
+			t.Errorf("CanonicalURL(%q) = %q, expected %q", c.URL, out, c.Expected)
+		}
+	}
+}
+
+func TestURLVariants(t *testing.T) {
+	out := urlVariants("HTTPS://example.com/feed/")
+	expected := []string{"HTTPS://example.com/feed/", "https://example.com/feed", "http://example.com/feed"}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("urlVariants = %v, expected %v", out, expected)
+	}
+
+	out = urlVariants("wrongurl")
+	if !reflect.DeepEqual(out, []string{"wrongurl"}) {
+		t.Errorf("urlVariants = %v, expected [wrongurl]", out)
+	}
+}
@@ -1,6 +1,14 @@
 package db
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
 	"github.com/cjlucas/unnamedcast/db/utctime"
 
 	"golang.org/x/crypto/bcrypt"
@@ -25,14 +33,89 @@ type ItemState struct {
 	ModificationTime utctime.Time `json:"modification_time" bson:"modification_time"`
 }
 
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Limits enforced by ValidateUsername and ValidatePassword
+const (
+	MinUsernameLength = 3
+	MaxUsernameLength = 32
+	MinPasswordLength = 8
+
+	// bcrypt ignores anything past the first 72 bytes
+	MaxPasswordLength = 72
+)
+
+// ResetTokenLifetime is the duration a password reset token is valid for.
+const ResetTokenLifetime = 24 * time.Hour
+
+// NumRecoveryCodes is the number of recovery codes issued when TOTP is enabled.
+const NumRecoveryCodes = 10
+
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+var ErrInvalidRecoveryCode = errors.New("invalid recovery code")
+var ErrTOTPCodeReused = errors.New("totp code has already been used")
+
+// ValidateUsername returns an error describing why the given username is
+// unacceptable, or nil if it is valid. Usernames may contain letters,
+// digits, '.', '_' and '-'.
+func ValidateUsername(username string) error {
+	if n := utf8.RuneCountInString(username); n < MinUsernameLength || n > MaxUsernameLength {
+		return fmt.Errorf("username must be between %d and %d characters",
+			MinUsernameLength, MaxUsernameLength)
+	}
+
+	for _, r := range username {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '.', r == '_', r == '-':
+		default:
+			return fmt.Errorf("username contains invalid character: %q", r)
+		}
+	}
+
+	return nil
+}
+
+// ValidatePassword returns an error describing why the given password is
+// unacceptable, or nil if it is valid.
+func ValidatePassword(password string) error {
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+	if len(password) > MaxPasswordLength {
+		return fmt.Errorf("password must be at most %d bytes", MaxPasswordLength)
+	}
+	return nil
+}
+
 type User struct {
 	ID               ID           `bson:"_id,omitempty" json:"id"`
 	Username         string       `json:"username" bson:"username" index:",unique"`
 	Password         string       `json:"-" bson:"password"` // encrypted
+	Role             string       `json:"role" bson:"role"`
 	FeedIDs          []ID         `json:"feeds" bson:"feed_ids" index:"feed_ids"`
 	ItemStates       []ItemState  `json:"states" bson:"states"`
 	CreationTime     utctime.Time `json:"creation_time" bson:"creation_time"`
 	ModificationTime utctime.Time `json:"modification_time" bson:"modification_time"`
+
+	// Hash of the outstanding password reset token, if any
+	ResetTokenHash           string       `json:"-" bson:"reset_token_hash,omitempty"`
+	ResetTokenExpirationTime utctime.Time `json:"-" bson:"reset_token_expiration_time,omitempty"`
+
+	// TOTP two-factor authentication. TOTPSecret is set on enrollment, but
+	// is not required at login until the user confirms it with a code.
+	TOTPEnabled        bool     `json:"totp_enabled" bson:"totp_enabled"`
+	TOTPSecret         string   `json:"-" bson:"totp_secret,omitempty"`
+	TOTPLastStep       int64    `json:"-" bson:"totp_last_step,omitempty"`
+	RecoveryCodeHashes []string `json:"-" bson:"recovery_code_hashes,omitempty"`
+}
+
+// CheckPassword reports whether password matches the user's password.
+func (u *User) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password)) == nil
 }
 
 type UserCollection struct {
@@ -41,8 +124,16 @@ type UserCollection struct {
 	ItemStateCollection collection
 }
 
-func (c UserCollection) Create(username, password string) (*User, error) {
+func hashPassword(password string) (string, error) {
 	pw, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(pw), nil
+}
+
+func (c UserCollection) Create(username, password string) (*User, error) {
+	pw, err := hashPassword(password)
 	if err != nil {
 		return nil, err
 	}
@@ -51,7 +142,8 @@ func (c UserCollection) Create(username, password string) (*User, error) {
 	user := User{
 		ID:               NewID(),
 		Username:         username,
-		Password:         string(pw),
+		Password:         pw,
+		Role:             RoleUser,
 		CreationTime:     now,
 		ModificationTime: now,
 	}
@@ -138,13 +230,249 @@ func (c UserCollection) Update(user *User) error {
 		return err
 	}
 
-	if CopyModel(&origUser, user, "ID", "Username", "Password") {
+	if CopyModel(&origUser, user, "ID", "Username", "Password", "Role",
+		"ResetTokenHash", "ResetTokenExpirationTime",
+		"TOTPEnabled", "TOTPSecret", "TOTPLastStep", "RecoveryCodeHashes") {
 		user.ModificationTime = utctime.Now()
 	}
 
 	return c.c.UpdateId(origUser.ID, &origUser)
 }
 
+// SetRole changes the role of the user with the given ID.
+func (c UserCollection) SetRole(userID ID, role string) error {
+	return c.c.UpdateId(userID, bson.M{
+		"$set": bson.M{
+			"role":              role,
+			"modification_time": utctime.Now(),
+		},
+	})
+}
+
+// SetPassword changes the password of the user with the given ID.
+// Any outstanding password reset token is invalidated.
+func (c UserCollection) SetPassword(userID ID, password string) error {
+	pw, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return c.c.UpdateId(userID, bson.M{
+		"$set": bson.M{
+			"password":          pw,
+			"modification_time": utctime.Now(),
+		},
+		"$unset": bson.M{
+			"reset_token_hash":            "",
+			"reset_token_expiration_time": "",
+		},
+	})
+}
+
+// CreateResetToken issues a password reset token for the user with the given
+// ID, replacing any previously issued token. The returned token is the only
+// copy of the secret.
+func (c UserCollection) CreateResetToken(userID ID) (string, utctime.Time, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", utctime.Time{}, err
+	}
+
+	now := utctime.Now()
+	expiration := now.Add(ResetTokenLifetime)
+	err = c.c.UpdateId(userID, bson.M{
+		"$set": bson.M{
+			"reset_token_hash":            hashToken(token),
+			"reset_token_expiration_time": expiration,
+		},
+	})
+	if err != nil {
+		return "", utctime.Time{}, err
+	}
+
+	return token, expiration, nil
+}
+
+// ResetPassword sets the password of the user with the given ID if token
+// matches their unexpired reset token. The token is consumed in the same
+// update, so it can only be used once. ErrInvalidResetToken is returned
+// if the token does not match.
+func (c UserCollection) ResetPassword(userID ID, token, password string) error {
+	pw, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	sel := bson.M{
+		"_id":                         userID,
+		"reset_token_hash":            hashToken(token),
+		"reset_token_expiration_time": bson.M{"$gt": utctime.Now()},
+	}
+
+	err = c.c.Update(sel, bson.M{
+		"$set": bson.M{
+			"password":          pw,
+			"modification_time": utctime.Now(),
+		},
+		"$unset": bson.M{
+			"reset_token_hash":            "",
+			"reset_token_expiration_time": "",
+		},
+	})
+	if err == ErrNotFound {
+		return ErrInvalidResetToken
+	}
+	return err
+}
+
+// SetTOTPSecret begins TOTP enrollment for the user with the given ID.
+// The secret is not required at login until EnableTOTP is called.
+func (c UserCollection) SetTOTPSecret(userID ID, secret string) error {
+	return c.c.UpdateId(userID, bson.M{
+		"$set": bson.M{
+			"totp_secret":       secret,
+			"modification_time": utctime.Now(),
+		},
+	})
+}
+
+// normalizeRecoveryCode allows recovery codes to be entered regardless of
+// case or grouping.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+func newRecoveryCode() (string, error) {
+	buf := make([]byte, 5)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	s := hex.EncodeToString(buf)
+	return s[:5] + "-" + s[5:], nil
+}
+
+// EnableTOTP requires TOTP at login for the user with the given ID and
+// issues a new set of recovery codes, replacing any existing ones.
+// The returned codes are the only copy of the secrets.
+func (c UserCollection) EnableTOTP(userID ID) ([]string, error) {
+	codes := make([]string, NumRecoveryCodes)
+	hashes := make([]string, NumRecoveryCodes)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = hashToken(normalizeRecoveryCode(code))
+	}
+
+	err := c.c.UpdateId(userID, bson.M{
+		"$set": bson.M{
+			"totp_enabled":         true,
+			"recovery_code_hashes": hashes,
+			"modification_time":    utctime.Now(),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP removes the TOTP secret and recovery codes of the user with
+// the given ID.
+func (c UserCollection) DisableTOTP(userID ID) error {
+	return c.c.UpdateId(userID, bson.M{
+		"$set": bson.M{
+			"totp_enabled":      false,
+			"modification_time": utctime.Now(),
+		},
+		"$unset": bson.M{
+			"totp_secret":          "",
+			"totp_last_step":       "",
+			"recovery_code_hashes": "",
+		},
+	})
+}
+
+// UseTOTPStep records that the TOTP code for the given time step has been
+// used by the user with the given ID. ErrTOTPCodeReused is returned if a
+// code for this or a later step was already used, so a code observed by
+// an attacker cannot be replayed.
+func (c UserCollection) UseTOTPStep(userID ID, step int64) error {
+	sel := bson.M{
+		"_id": userID,
+		"$or": []bson.M{
+			{"totp_last_step": bson.M{"$exists": false}},
+			{"totp_last_step": bson.M{"$lt": step}},
+		},
+	}
+
+	err := c.c.Update(sel, bson.M{
+		"$set": bson.M{"totp_last_step": step},
+	})
+	if err == ErrNotFound {
+		return ErrTOTPCodeReused
+	}
+	return err
+}
+
+// UseRecoveryCode consumes one of the recovery codes of the user with the
+// given ID. ErrInvalidRecoveryCode is returned if the code does not match
+// an unused recovery code.
+func (c UserCollection) UseRecoveryCode(userID ID, code string) error {
+	hash := hashToken(normalizeRecoveryCode(code))
+
+	sel := bson.M{
+		"_id":                  userID,
+		"recovery_code_hashes": hash,
+	}
+
+	err := c.c.Update(sel, bson.M{
+		"$pull": bson.M{"recovery_code_hashes": hash},
+	})
+	if err == ErrNotFound {
+		return ErrInvalidRecoveryCode
+	}
+	return err
+}
+
+// Subscribe adds the feed to the user's subscriptions. It reports whether
+// the user was not already subscribed to it.
+func (c UserCollection) Subscribe(userID, feedID ID) (bool, error) {
+	err := c.c.Update(bson.M{
+		"_id":      userID,
+		"feed_ids": bson.M{"$ne": feedID},
+	}, bson.M{
+		"$push": bson.M{"feed_ids": feedID},
+		"$set":  bson.M{"modification_time": utctime.Now()},
+	})
+
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Unsubscribe removes the feed from the user's subscriptions. It reports
+// whether the user was subscribed to it.
+func (c UserCollection) Unsubscribe(userID, feedID ID) (bool, error) {
+	err := c.c.Update(bson.M{
+		"_id":      userID,
+		"feed_ids": feedID,
+	}, bson.M{
+		"$pull": bson.M{"feed_ids": feedID},
+		"$set":  bson.M{"modification_time": utctime.Now()},
+	})
+
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
 func (c UserCollection) DeleteItemState(userID, itemID ID) error {
 	return c.c.UpdateId(userID, bson.M{
 		"$pull": bson.M{
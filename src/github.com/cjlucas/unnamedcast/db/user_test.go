@@ -0,0 +1,176 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateUsername(t *testing.T) {
+	cases := []struct {
+		Username string
+		Valid    bool
+	}{
+		{"chris", true},
+		{"chris.lucas_1-2", true},
+		{"ch", false},
+		{"chris lucas", false},
+		{"chrisß", false},
+		{"abcdefghijklmnopqrstuvwxyzabcdefg", false},
+	}
+
+	for _, c := range cases {
+		if err := ValidateUsername(c.Username); (err == nil) != c.Valid {
+			t.Errorf("ValidateUsername(%q): unexpected result: %v", c.Username, err)
+		}
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	cases := []struct {
+		Password string
+		Valid    bool
+	}{
+		{"hithere1", true},
+		{"short", false},
+		{string(make([]byte, MaxPasswordLength+1)), false},
+	}
+
+	for _, c := range cases {
+		if err := ValidatePassword(c.Password); (err == nil) != c.Valid {
+			t.Errorf("ValidatePassword(%q): unexpected result: %v", c.Password, err)
+		}
+	}
+}
+
+func TestUser_SetPassword(t *testing.T) {
+	db := newDB()
+
+	user, _ := db.Users.Create("chris", "hithere")
+	if err := db.Users.SetPassword(user.ID, "newpassword"); err != nil {
+		t.Fatal("Could not set password:", err)
+	}
+
+	var out User
+	if err := db.Users.FindByID(user.ID).One(&out); err != nil {
+		t.Fatal("Could not find user:", err)
+	}
+
+	if !out.CheckPassword("newpassword") || out.CheckPassword("hithere") {
+		t.Error("password was not changed")
+	}
+}
+
+func TestUser_ResetPassword(t *testing.T) {
+	db := newDB()
+
+	user, _ := db.Users.Create("chris", "hithere")
+	token, _, err := db.Users.CreateResetToken(user.ID)
+	if err != nil {
+		t.Fatal("Could not create reset token:", err)
+	}
+
+	if err := db.Users.ResetPassword(user.ID, "badtoken", "newpassword"); err != ErrInvalidResetToken {
+		t.Errorf("unexpected error: %v != %v", err, ErrInvalidResetToken)
+	}
+
+	if err := db.Users.ResetPassword(user.ID, token, "newpassword"); err != nil {
+		t.Fatal("Could not reset password:", err)
+	}
+
+	if err := db.Users.ResetPassword(user.ID, token, "newpassword2"); err != ErrInvalidResetToken {
+		t.Errorf("token was reusable: %v != %v", err, ErrInvalidResetToken)
+	}
+
+	var out User
+	if err := db.Users.FindByID(user.ID).One(&out); err != nil {
+		t.Fatal("Could not find user:", err)
+	}
+
+	if !out.CheckPassword("newpassword") {
+		t.Error("password was not reset")
+	}
+}
+
+func TestUser_UseTOTPStep(t *testing.T) {
+	db := newDB()
+
+	user, _ := db.Users.Create("chris", "hithere")
+	if err := db.Users.UseTOTPStep(user.ID, 100); err != nil {
+		t.Fatal("Could not use step:", err)
+	}
+
+	for _, step := range []int64{99, 100} {
+		if err := db.Users.UseTOTPStep(user.ID, step); err != ErrTOTPCodeReused {
+			t.Errorf("unexpected error for step %d: %v != %v", step, err, ErrTOTPCodeReused)
+		}
+	}
+
+	if err := db.Users.UseTOTPStep(user.ID, 101); err != nil {
+		t.Error("Could not use later step:", err)
+	}
+}
+
+func TestUser_UseRecoveryCode(t *testing.T) {
+	db := newDB()
+
+	user, _ := db.Users.Create("chris", "hithere")
+	codes, err := db.Users.EnableTOTP(user.ID)
+	if err != nil {
+		t.Fatal("Could not enable totp:", err)
+	}
+	if len(codes) != NumRecoveryCodes {
+		t.Fatalf("Unexpected # of recovery codes: %d != %d", len(codes), NumRecoveryCodes)
+	}
+
+	// Codes are accepted regardless of case and grouping
+	code := strings.ToUpper(strings.Replace(codes[0], "-", "", -1))
+	if err := db.Users.UseRecoveryCode(user.ID, code); err != nil {
+		t.Fatal("Could not use recovery code:", err)
+	}
+
+	if err := db.Users.UseRecoveryCode(user.ID, codes[0]); err != ErrInvalidRecoveryCode {
+		t.Errorf("code was reusable: %v != %v", err, ErrInvalidRecoveryCode)
+	}
+
+	if err := db.Users.DisableTOTP(user.ID); err != nil {
+		t.Fatal("Could not disable totp:", err)
+	}
+	if err := db.Users.UseRecoveryCode(user.ID, codes[1]); err != ErrInvalidRecoveryCode {
+		t.Errorf("code survived disabling totp: %v != %v", err, ErrInvalidRecoveryCode)
+	}
+}
+
+func TestUser_Subscribe(t *testing.T) {
+	db := newDB()
+
+	user, _ := db.Users.Create("chris", "hithere")
+	feedID := NewID()
+
+	for i, expected := range []bool{true, false} {
+		added, err := db.Users.Subscribe(user.ID, feedID)
+		if err != nil {
+			t.Fatal("Could not subscribe:", err)
+		}
+		if added != expected {
+			t.Errorf("Subscribe #%d = %t, expected %t", i+1, added, expected)
+		}
+	}
+
+	var out User
+	if err := db.Users.FindByID(user.ID).One(&out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.FeedIDs) != 1 || out.FeedIDs[0] != feedID {
+		t.Errorf("Unexpected feed ids: %v", out.FeedIDs)
+	}
+
+	for i, expected := range []bool{true, false} {
+		removed, err := db.Users.Unsubscribe(user.ID, feedID)
+		if err != nil {
+			t.Fatal("Could not unsubscribe:", err)
+		}
+		if removed != expected {
+			t.Errorf("Unsubscribe #%d = %t, expected %t", i+1, removed, expected)
+		}
+	}
+}
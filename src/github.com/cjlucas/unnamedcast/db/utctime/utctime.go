@@ -68,3 +68,7 @@ func (t *Time) SetBSON(raw bson.Raw) error {
 func Now() Time {
 	return Time{time.Now().UTC()}
 }
+
+func New(t time.Time) Time {
+	return Time{t.UTC()}
+}
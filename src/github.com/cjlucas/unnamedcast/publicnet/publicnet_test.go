@@ -0,0 +1,94 @@
+package publicnet
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsPublic(t *testing.T) {
+	cases := []struct {
+		IP       string
+		Expected bool
+	}{
+		{"8.8.8.8", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"100.64.0.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+	}
+
+	for _, c := range cases {
+		if got := IsPublic(net.ParseIP(c.IP)); got != c.Expected {
+			t.Errorf("IsPublic(%s) = %t, expected %t", c.IP, got, c.Expected)
+		}
+	}
+}
+
+func TestClient_RefusesLoopback(t *testing.T) {
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer srv.Close()
+
+	// Names are checked once resolved
+	url := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+	for _, u := range []string{srv.URL, url} {
+		_, err := NewClient(time.Second).Get(u)
+		if err == nil || !strings.Contains(err.Error(), ErrNotPublic.Error()) {
+			t.Errorf("Get(%s) error = %v, expected %v", u, err, ErrNotPublic)
+		}
+	}
+
+	if requests != 0 {
+		t.Errorf("Server received %d requests", requests)
+	}
+}
+
+func TestClient_RefusesRedirectToLoopback(t *testing.T) {
+	var requests int
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer internal.Close()
+
+	// The public server is reached without the guard, as tests can only
+	// listen on loopback addresses
+	public := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusFound))
+	defer public.Close()
+
+	client := NewClient(time.Second)
+	transport := client.Transport.(*http.Transport)
+	guarded := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if addr == public.Listener.Addr().String() {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		}
+		return guarded(ctx, network, addr)
+	}
+
+	if _, err := client.Get(public.URL); err == nil || !strings.Contains(err.Error(), ErrNotPublic.Error()) {
+		t.Errorf("Get error = %v, expected %v", err, ErrNotPublic)
+	}
+	if requests != 0 {
+		t.Errorf("Internal server received %d requests", requests)
+	}
+}
@@ -7,7 +7,10 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -15,6 +18,11 @@ import (
 	"github.com/cjlucas/unnamedcast/api"
 	"github.com/cjlucas/unnamedcast/db"
 	"github.com/cjlucas/unnamedcast/db/utctime"
+	"github.com/cjlucas/unnamedcast/server/endpoint"
+	"github.com/cjlucas/unnamedcast/server/middleware"
+	"github.com/cjlucas/unnamedcast/server/opml"
+	"github.com/cjlucas/unnamedcast/server/totp"
+	"github.com/cjlucas/unnamedcast/websub"
 	"github.com/gin-gonic/gin"
 )
 
@@ -86,6 +94,31 @@ func createUser(t *testing.T, app *App, username, password string) *db.User {
 	return user
 }
 
+func createAdmin(t *testing.T, app *App, username, password string) *db.User {
+	user := createUser(t, app, username, password)
+	if err := app.DB.Users.SetRole(user.ID, db.RoleAdmin); err != nil {
+		t.Fatalf("Failed to set role: %s", err)
+	}
+	user.Role = db.RoleAdmin
+	return user
+}
+
+func createSession(t *testing.T, app *App, user *db.User) string {
+	_, token, err := app.DB.Sessions.Create(user.ID)
+	if err != nil {
+		t.Fatalf("Failed to create session: %s", err)
+	}
+	return token
+}
+
+func createAPIKey(t *testing.T, app *App, scopes ...string) string {
+	_, key, err := app.DB.APIKeys.Create("test", scopes)
+	if err != nil {
+		t.Fatalf("Failed to create api key: %s", err)
+	}
+	return key
+}
+
 func createJob(t *testing.T, app *App, job db.Job) db.Job {
 	job, err := app.DB.Jobs.Create(job)
 	if err != nil {
@@ -122,6 +155,9 @@ type endpointTestInfo struct {
 	Request      *http.Request
 	ExpectedCode int
 
+	// Authenticate the request as the given user. User must exist in App.
+	User *db.User
+
 	// Unmarshal given object from response body for further assertions
 	ResponseBody interface{}
 }
@@ -131,6 +167,11 @@ func testEndpoint(t *testing.T, info endpointTestInfo) {
 		info.App = newTestApp()
 	}
 
+	if info.User != nil {
+		token := createSession(t, info.App, info.User)
+		info.Request.Header.Set("Authorization", "Bearer "+token)
+	}
+
 	w := httptest.NewRecorder()
 	info.App.g.ServeHTTP(w, info.Request)
 
@@ -161,6 +202,66 @@ func TestSearchFeeds_WithoutQuery(t *testing.T) {
 	})
 }
 
+func TestRateLimit(t *testing.T) {
+	app := newTestApp()
+	now := time.Date(2017, time.March, 1, 12, 0, 0, 0, time.UTC)
+	app.Clock = func() time.Time { return now }
+	app.RateLimits.Set("GetUser", middleware.Rate{Requests: 1, Per: time.Minute, Burst: 2})
+
+	user := createUser(t, app, "chris", "hithere")
+	other := createUser(t, app, "john", "hithere")
+	token := createSession(t, app, user)
+
+	getUser := func(user *db.User, token string) *httptest.ResponseRecorder {
+		req := newRequest("GET", fmt.Sprintf("/api/users/%s", user.ID.Hex()), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		app.g.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := getUser(user, token); w.Code != http.StatusOK {
+			t.Fatalf("Unexpected status code: %d != %d", w.Code, http.StatusOK)
+		}
+	}
+
+	w := getUser(user, token)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Unexpected status code: %d != %d", w.Code, http.StatusTooManyRequests)
+	}
+	if h := w.Header().Get("Retry-After"); h != "60" {
+		t.Errorf("Unexpected Retry-After: %s != 60", h)
+	}
+
+	// Limits are tracked per user
+	if w := getUser(other, createSession(t, app, other)); w.Code != http.StatusOK {
+		t.Errorf("Unexpected status code: %d != %d", w.Code, http.StatusOK)
+	}
+
+	now = now.Add(time.Minute)
+	if w := getUser(user, token); w.Code != http.StatusOK {
+		t.Errorf("Unexpected status code: %d != %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestRateLimit_Unauthenticated(t *testing.T) {
+	app := newTestApp()
+	app.RateLimits.Set("SearchFeeds", middleware.Rate{Requests: 1, Per: time.Hour})
+
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		Request:      newRequest("GET", "/search_feeds", nil),
+		ExpectedCode: http.StatusBadRequest,
+	})
+
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		Request:      newRequest("GET", "/search_feeds", nil),
+		ExpectedCode: http.StatusTooManyRequests,
+	})
+}
+
 func TestLoginInvalidParameters(t *testing.T) {
 	app := newTestApp()
 	createUser(t, app, "chris", "hithere")
@@ -199,7 +300,10 @@ func TestLogin(t *testing.T) {
 	app := newTestApp()
 	createUser(t, app, "chris", "hithere")
 
-	var out db.User
+	var out struct {
+		Token string  `json:"token"`
+		User  db.User `json:"user"`
+	}
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
 		Request:      newRequest("GET", "/login?username=chris&password=hithere", nil),
@@ -207,832 +311,2672 @@ func TestLogin(t *testing.T) {
 		ResponseBody: &out,
 	})
 
-	if out.Username != "chris" {
-		t.Errorf("Username mismatch: %s != %s", out.Username, "chris")
+	if out.User.Username != "chris" {
+		t.Errorf("Username mismatch: %s != %s", out.User.Username, "chris")
 	}
-}
 
-func TestGetUsers(t *testing.T) {
-	app := newTestApp()
-	createUser(t, app, "chris", "hithere")
-	createUser(t, app, "john", "hithere")
+	if out.Token == "" {
+		t.Fatal("Token was not given")
+	}
 
-	var out []db.User
+	// Issued token should grant access to the api
+	req := newRequest("GET", fmt.Sprintf("/api/users/%s", out.User.ID.Hex()), nil)
+	req.Header.Set("Authorization", "Bearer "+out.Token)
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("GET", "/api/users", nil),
+		Request:      req,
 		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
 	})
-
-	if len(out) != 2 {
-		t.Errorf("Unexpected # of users: %d != %d", len(out), 2)
-	}
 }
 
-func TestCreateUserValidParams(t *testing.T) {
+func TestLogin_SessionCookie(t *testing.T) {
 	app := newTestApp()
-	req := newRequest("POST", "/api/users?username=chris&password=hi", nil)
+	createUser(t, app, "chris", "hithere")
 
-	var user db.User
-	testEndpoint(t, endpointTestInfo{
-		App:          app,
-		Request:      req,
-		ExpectedCode: http.StatusOK,
-		ResponseBody: &user,
-	})
+	sessionCookie := func() *http.Cookie {
+		w := httptest.NewRecorder()
+		app.g.ServeHTTP(w, newRequest("GET", "/login?username=chris&password=hithere", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Unexpected status code: %d != %d", w.Code, http.StatusOK)
+		}
 
-	expectedUsername := "chris"
-	if user.Username != expectedUsername {
-		t.Errorf("Username mismatch: %s != %s", user.Username, expectedUsername)
+		for _, cookie := range (&http.Response{Header: w.Header()}).Cookies() {
+			if cookie.Name == "session" {
+				return cookie
+			}
+		}
+		t.Fatal("Session cookie was not set")
+		return nil
 	}
 
-	if user.ID == emptyID {
-		t.Error("user.ID is invalid")
+	cookie := sessionCookie()
+	if !cookie.Secure || !cookie.HttpOnly || cookie.SameSite != http.SameSiteStrictMode {
+		t.Errorf("Unexpected cookie attributes: %s", cookie)
 	}
 
-	// Duplicate entry
-	testEndpoint(t, endpointTestInfo{
-		App:          app,
-		Request:      req,
-		ExpectedCode: http.StatusConflict,
-	})
-
-	// No parameters
-	testEndpoint(t, endpointTestInfo{
-		Request:      newRequest("POST", "/api/users", nil),
-		ExpectedCode: http.StatusBadRequest,
-	})
+	app.Cookies.Insecure = true
+	if cookie := sessionCookie(); cookie.Secure {
+		t.Errorf("Expected an insecure cookie: %s", cookie)
+	}
 }
 
-func TestGetUser(t *testing.T) {
+func TestSessionCookie_RedactsLog(t *testing.T) {
 	app := newTestApp()
 	user := createUser(t, app, "chris", "hithere")
+	token := createSession(t, app, user)
 
-	var out db.User
+	req := newRequest("GET", fmt.Sprintf("/api/users/%s", user.ID.Hex()), nil)
+	req.AddCookie(&http.Cookie{Name: middleware.SessionCookie, Value: token})
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("GET", fmt.Sprintf("/api/users/%s", user.ID.Hex()), nil),
+		Request:      req,
 		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
 	})
 
-	if out.ID != user.ID {
-		t.Errorf("ID mismatch: %s != %s", out.ID, user.ID)
+	var logs []db.Log
+	if err := app.DB.Logs.Find(nil).All(&logs); err != nil {
+		t.Fatal("Could not fetch logs:", err)
+	}
+	if len(logs) != 1 {
+		t.Fatalf("len(logs) = %d, expected 1", len(logs))
 	}
 
-	// Non-existant ID
-	testEndpoint(t, endpointTestInfo{
-		App:          app,
-		Request:      newRequest("GET", fmt.Sprintf("/api/users/%s", db.NewID().Hex()), nil),
-		ExpectedCode: http.StatusNotFound,
-	})
+	for k, values := range logs[0].RequestHeader {
+		for _, v := range values {
+			if strings.Contains(v, token) {
+				t.Errorf("session token was logged in %s header", k)
+			}
+		}
+	}
+	if cookie := logs[0].RequestHeader["Cookie"]; len(cookie) != 1 || cookie[0] != "[redacted]" {
+		t.Errorf("Cookie = %v, expected [redacted]", cookie)
+	}
 }
 
-func TestGetUserFeeds(t *testing.T) {
-	app := newTestApp()
-	user := createUser(t, app, "chris", "hithere")
-	user.FeedIDs = append(user.FeedIDs, db.NewID())
-
-	if err := app.DB.Users.Update(user); err != nil {
-		t.Fatal("Could not update user:", err)
-	}
+type loginResponse struct {
+	Token        string `json:"token"`
+	TOTPRequired bool   `json:"totp_required"`
+	Challenge    string `json:"challenge"`
+}
 
-	var out []db.ID
+func login(t *testing.T, app *App, username, password string) loginResponse {
+	var out loginResponse
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("GET", fmt.Sprintf("/api/users/%s/feeds", user.ID.Hex()), nil),
+		Request:      newRequest("GET", fmt.Sprintf("/login?username=%s&password=%s", username, password), nil),
 		ExpectedCode: http.StatusOK,
 		ResponseBody: &out,
 	})
+	return out
+}
 
-	if len(out) == len(user.FeedIDs) {
-		if out[0] != user.FeedIDs[0] {
-			t.Errorf("ID mismatch: %s != %s", out[0], user.FeedIDs[0])
-		}
-	} else {
-		t.Errorf("Unexpected # of feed IDs: %d != %d", len(out), len(user.FeedIDs))
+func verifyLogin(t *testing.T, app *App, challenge, code string, expectedCode int) loginResponse {
+	var out loginResponse
+	info := endpointTestInfo{
+		App:          app,
+		Request:      newRequest("GET", fmt.Sprintf("/login/verify?challenge=%s&code=%s", challenge, code), nil),
+		ExpectedCode: expectedCode,
+	}
+	if expectedCode == http.StatusOK {
+		info.ResponseBody = &out
 	}
+	testEndpoint(t, info)
+	return out
 }
 
-func TestGetUserItemStates(t *testing.T) {
-	app := newTestApp()
-	user := createUser(t, app, "chris", "hithere")
-	user.ItemStates = append(user.ItemStates, db.ItemState{
-		ItemID:   db.NewID(),
-		Position: 5,
+// enableTOTP enrolls user in TOTP at the app's current time and returns
+// the secret and recovery codes.
+func enableTOTP(t *testing.T, app *App, user *db.User) (string, []string) {
+	endpoint := fmt.Sprintf("/api/users/%s/totp", user.ID.Hex())
+
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("POST", endpoint, nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &enrollment,
 	})
 
-	if err := app.DB.Users.Update(user); err != nil {
-		t.Fatal("Could not update user:", err)
+	code, err := totp.Code(enrollment.Secret, app.Clock())
+	if err != nil {
+		t.Fatal("Could not generate code:", err)
 	}
 
-	var out []db.ItemState
+	var out struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("GET", fmt.Sprintf("/api/users/%s/states", user.ID.Hex()), nil),
+		User:         user,
+		Request:      newRequest("PUT", endpoint, gin.H{"code": code}),
 		ExpectedCode: http.StatusOK,
 		ResponseBody: &out,
 	})
 
-	if len(out) == len(user.ItemStates) {
-		if out[0].ItemID != user.ItemStates[0].ItemID {
-			t.Errorf("ID mismatch: %s != %s", out[0].ItemID, user.ItemStates[0].ItemID)
-		}
-	} else {
-		t.Errorf("Unexpected # of feed IDs: %d != %d", len(out), len(user.ItemStates))
-	}
+	return enrollment.Secret, out.RecoveryCodes
 }
 
-func TestGetUserItemStates_WithModifiedSinceParam(t *testing.T) {
+func TestLoginTOTP(t *testing.T) {
 	app := newTestApp()
-	user := createUser(t, app, "chris", "hithere")
-	user.ItemStates = append(user.ItemStates, db.ItemState{
-		ItemID:           db.NewID(),
-		Position:         5,
-		ModificationTime: utctime.Now(),
-	})
-
-	if err := app.DB.Users.Update(user); err != nil {
-		t.Fatal("Could not update user:", err)
-	}
+	now := time.Date(2017, time.March, 1, 12, 0, 0, 0, time.UTC)
+	app.Clock = func() time.Time { return now }
 
-	modTime := user.ItemStates[0].ModificationTime
+	user := createUser(t, app, "chris", "hithere")
+	secret, _ := enableTOTP(t, app, user)
 
-	urlWithTime := func(modTime utctime.Time) string {
-		return fmt.Sprintf("/api/users/%s/states?modified_since=%s", user.ID.Hex(), modTime.Format(time.RFC3339))
+	resp := login(t, app, "chris", "hithere")
+	if !resp.TOTPRequired || resp.Token != "" {
+		t.Fatalf("Expected a totp challenge: %#v", resp)
 	}
 
-	var out []db.ItemState
+	// The challenge does not authenticate requests
+	req := newRequest("GET", fmt.Sprintf("/api/users/%s", user.ID.Hex()), nil)
+	req.Header.Set("Authorization", "Bearer "+resp.Challenge)
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("GET", urlWithTime(modTime.Add(-1*time.Second)), nil),
-		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
+		Request:      req,
+		ExpectedCode: http.StatusUnauthorized,
 	})
 
-	if len(out) != 1 {
-		t.Errorf("Unexpected response length: %d != 1", len(out))
-	}
+	verifyLogin(t, app, resp.Challenge, "000000", http.StatusUnauthorized)
 
-	out = make([]db.ItemState, 0)
+	// The code used for enrollment cannot be replayed
+	code, _ := totp.Code(secret, now)
+	verifyLogin(t, app, resp.Challenge, code, http.StatusUnauthorized)
+
+	now = now.Add(totp.Period)
+	code, _ = totp.Code(secret, now)
+	out := verifyLogin(t, app, resp.Challenge, code, http.StatusOK)
+	if out.Token == "" {
+		t.Fatal("Expected a session token")
+	}
 
+	req = newRequest("GET", fmt.Sprintf("/api/users/%s", user.ID.Hex()), nil)
+	req.Header.Set("Authorization", "Bearer "+out.Token)
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("GET", urlWithTime(modTime.Add(1*time.Second)), nil),
+		Request:      req,
 		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
 	})
 
-	if len(out) != 0 {
-		t.Errorf("Unexpected response length: %d != 0", len(out))
-	}
+	// Challenges are single use
+	verifyLogin(t, app, resp.Challenge, code, http.StatusUnauthorized)
 }
 
-func TestPutUserFeeds(t *testing.T) {
+func TestLoginTOTP_RecoveryCode(t *testing.T) {
 	app := newTestApp()
+	now := time.Date(2017, time.March, 1, 12, 0, 0, 0, time.UTC)
+	app.Clock = func() time.Time { return now }
+
 	user := createUser(t, app, "chris", "hithere")
+	_, recoveryCodes := enableTOTP(t, app, user)
 
-	ids := []db.ID{db.NewID()}
-	req := newRequest("PUT", fmt.Sprintf("/api/users/%s/feeds", user.ID.Hex()), ids)
-	var out db.User
-	testEndpoint(t, endpointTestInfo{
-		App:          app,
-		Request:      req,
-		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
-	})
+	resp := login(t, app, "chris", "hithere")
+	verifyLogin(t, app, resp.Challenge, recoveryCodes[0], http.StatusOK)
 
-	if len(out.FeedIDs) == len(ids) {
-		if out.FeedIDs[0] != ids[0] {
-			t.Errorf("Feed ID mismatch: %s != %s", out.FeedIDs[0], ids[0])
-		}
-	} else {
-		t.Errorf("Unexpected # of feed IDs: %d != %d", len(out.FeedIDs), len(ids))
+	// Recovery codes are single use
+	resp = login(t, app, "chris", "hithere")
+	verifyLogin(t, app, resp.Challenge, recoveryCodes[0], http.StatusUnauthorized)
+	verifyLogin(t, app, resp.Challenge, recoveryCodes[1], http.StatusOK)
+}
+
+func TestLoginTOTP_MaxAttempts(t *testing.T) {
+	app := newTestApp()
+	now := time.Date(2017, time.March, 1, 12, 0, 0, 0, time.UTC)
+	app.Clock = func() time.Time { return now }
+
+	user := createUser(t, app, "chris", "hithere")
+	secret, _ := enableTOTP(t, app, user)
+
+	resp := login(t, app, "chris", "hithere")
+	for i := 0; i < db.MaxPendingSessionAttempts; i++ {
+		verifyLogin(t, app, resp.Challenge, "000000", http.StatusUnauthorized)
 	}
+
+	now = now.Add(totp.Period)
+	code, _ := totp.Code(secret, now)
+	verifyLogin(t, app, resp.Challenge, code, http.StatusUnauthorized)
 }
 
-func TestPutUserItemState(t *testing.T) {
+func TestDisableTOTP(t *testing.T) {
 	app := newTestApp()
 	user := createUser(t, app, "chris", "hithere")
-	item := createItem(t, app, &db.Item{
-		GUID:   "http://google.com/1",
-		FeedID: createFeed(t, app, &db.Feed{URL: "http://google.com"}).ID,
+	enableTOTP(t, app, user)
+
+	endpoint := fmt.Sprintf("/api/users/%s/totp", user.ID.Hex())
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("DELETE", endpoint, gin.H{"password": "wrong"}),
+		ExpectedCode: http.StatusForbidden,
 	})
 
-	state := api.ItemState{
-		ItemID:   item.ID.Hex(),
-		Position: 5,
-	}
-	req := newRequest("PUT", fmt.Sprintf("/api/users/%s/states/%s", user.ID.Hex(), state.ItemID), state)
-	var out api.ItemState
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      req,
+		User:         user,
+		Request:      newRequest("DELETE", endpoint, gin.H{"password": "hithere"}),
 		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
 	})
 
-	if out.ItemID != state.ItemID {
-		t.Errorf("Item ID mismatch: %s != %s", out.ItemID, state.ItemID)
+	if resp := login(t, app, "chris", "hithere"); resp.TOTPRequired || resp.Token == "" {
+		t.Errorf("Expected a session token: %#v", resp)
 	}
 }
 
-func TestPutUserItemState_WithOutdatedState(t *testing.T) {
+func TestLogout(t *testing.T) {
 	app := newTestApp()
 	user := createUser(t, app, "chris", "hithere")
-	item := createItem(t, app, &db.Item{
-		GUID:   "http://google.com/1",
-		FeedID: createFeed(t, app, &db.Feed{URL: "http://google.com"}).ID,
-	})
+	token := createSession(t, app, user)
 
-	state := api.ItemState{
-		ItemID:   item.ID.Hex(),
-		State:    api.StateInProgress,
-		Position: 5,
+	newAuthedRequest := func(method, endpoint string) *http.Request {
+		req := newRequest(method, endpoint, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req
 	}
 
-	req := newRequest("PUT", fmt.Sprintf("/api/users/%s/states/%s", user.ID.Hex(), state.ItemID), &state)
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      req,
+		Request:      newAuthedRequest("DELETE", "/api/session"),
 		ExpectedCode: http.StatusOK,
-		ResponseBody: &state,
 	})
 
-	state.ModificationTime = state.ModificationTime.Add(-1 * time.Second)
-
-	req = newRequest("PUT", fmt.Sprintf("/api/users/%s/states/%s", user.ID.Hex(), state.ItemID), &state)
+	// Session should no longer be valid
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      req,
-		ExpectedCode: http.StatusConflict,
+		Request:      newAuthedRequest("GET", fmt.Sprintf("/api/users/%s", user.ID.Hex())),
+		ExpectedCode: http.StatusUnauthorized,
 	})
 }
 
-func TestDeleteUserItemState(t *testing.T) {
+func TestAPI_Unauthenticated(t *testing.T) {
 	app := newTestApp()
 	user := createUser(t, app, "chris", "hithere")
-	item := createItem(t, app, &db.Item{
-		GUID:   "http://google.com/1",
-		FeedID: createFeed(t, app, &db.Feed{URL: "http://google.com"}).ID,
-	})
+	url := fmt.Sprintf("/api/users/%s", user.ID.Hex())
 
-	user.ItemStates = append(user.ItemStates, db.ItemState{
-		ItemID:   item.ID,
-		State:    api.StatePlayed,
-		Position: 0,
+	// No token
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		Request:      newRequest("GET", url, nil),
+		ExpectedCode: http.StatusUnauthorized,
 	})
 
-	if err := app.DB.Users.Update(user); err != nil {
-		t.Fatal("Could not update user:", err)
-	}
-
-	req := newRequest("DELETE", fmt.Sprintf("/api/users/%s/states/%s", user.ID.Hex(), item.ID.Hex()), nil)
+	// Unknown token
+	req := newRequest("GET", url, nil)
+	req.Header.Set("Authorization", "Bearer notarealtoken")
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
 		Request:      req,
-		ExpectedCode: http.StatusOK,
+		ExpectedCode: http.StatusUnauthorized,
 	})
-
-	if err := app.DB.Users.FindByID(user.ID).One(&user); err != nil {
-		t.Fatal("Could not find user:", err)
-	}
-
-	if len(user.ItemStates) != 0 {
-		t.Errorf("# of item states mismatch: %d != %d", len(user.ItemStates), 0)
-	}
 }
 
-func TestCreateFeed(t *testing.T) {
-	in := db.Feed{URL: "http://google.com"}
+func TestGetUsers(t *testing.T) {
+	app := newTestApp()
+	user := createAdmin(t, app, "chris", "hithere")
+	createUser(t, app, "john", "hithere")
 
-	var out db.Feed
+	var out []db.User
 	testEndpoint(t, endpointTestInfo{
-		Request:      newRequest("POST", "/api/feeds", &in),
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", "/api/users", nil),
 		ExpectedCode: http.StatusOK,
 		ResponseBody: &out,
 	})
 
-	if out.ID == emptyID {
-		t.Error("ID is invalid")
-	}
-
-	if out.URL != in.URL {
-		t.Errorf("URL mismatch: %s != %s", out.URL, in.URL)
+	if len(out) != 2 {
+		t.Errorf("Unexpected # of users: %d != %d", len(out), 2)
 	}
+}
 
-	// Duplicate entry
+func TestCreateUserValidParams(t *testing.T) {
 	app := newTestApp()
+	body := gin.H{"username": "chris", "password": "hithere1"}
+
+	var user db.User
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("POST", "/api/feeds", &in),
+		Request:      newRequest("POST", "/api/users", body),
 		ExpectedCode: http.StatusOK,
+		ResponseBody: &user,
 	})
+
+	expectedUsername := "chris"
+	if user.Username != expectedUsername {
+		t.Errorf("Username mismatch: %s != %s", user.Username, expectedUsername)
+	}
+
+	if user.ID == emptyID {
+		t.Error("user.ID is invalid")
+	}
+
+	// Duplicate entry
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("POST", "/api/feeds", &in),
+		Request:      newRequest("POST", "/api/users", body),
 		ExpectedCode: http.StatusConflict,
 	})
-	app.DB.Drop()
 
-	// No body given
+	// No body
 	testEndpoint(t, endpointTestInfo{
-		Request:      newRequest("POST", "/api/feeds", nil),
+		Request:      newRequest("POST", "/api/users", nil),
 		ExpectedCode: http.StatusBadRequest,
 	})
 }
 
-func TestGetFeed(t *testing.T) {
+func TestCreateUserInvalidParams(t *testing.T) {
+	app := newTestApp()
+
+	bodies := []gin.H{
+		{"username": "chris", "password": "short"},
+		{"username": "c", "password": "hithere1"},
+		{"username": "chris lucas", "password": "hithere1"},
+		{"password": "hithere1"},
+	}
+
+	for _, body := range bodies {
+		testEndpoint(t, endpointTestInfo{
+			App:          app,
+			Request:      newRequest("POST", "/api/users", body),
+			ExpectedCode: http.StatusBadRequest,
+		})
+	}
+}
+
+func TestCreateUser_RedactsLog(t *testing.T) {
 	app := newTestApp()
-	feed := createFeed(t, app, &db.Feed{URL: "http://google.com"})
 
-	req := newRequest("GET", fmt.Sprintf("/api/feeds/%s", feed.ID.Hex()), nil)
-	var out db.Feed
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      req,
+		Request:      newRequest("POST", "/api/users", gin.H{"username": "chris", "password": "hithere1"}),
 		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
 	})
 
-	if out.ID != feed.ID {
-		t.Errorf("ID mismatch: %s != %s", out.ID, feed.ID)
+	var logs []db.Log
+	if err := app.DB.Logs.Find(nil).All(&logs); err != nil {
+		t.Fatal("Could not fetch logs:", err)
+	}
+
+	for _, log := range logs {
+		if strings.Contains(log.RequestBody, "hithere1") || strings.Contains(log.Query, "hithere1") {
+			t.Errorf("password was logged: %#v", log)
+		}
 	}
 }
 
-func TestGetFeedWithoutParams(t *testing.T) {
+func TestChangePassword_Unauthenticated_RedactsLog(t *testing.T) {
 	app := newTestApp()
-	createFeed(t, app, &db.Feed{URL: "http://google.com"})
-	createFeed(t, app, &db.Feed{URL: "http://google2.com"})
+	user := createUser(t, app, "chris", "hithere")
 
-	var out []db.Feed
 	testEndpoint(t, endpointTestInfo{
-		App:          app,
-		Request:      newRequest("GET", "/api/feeds", nil),
-		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
+		App: app,
+		Request: newRequest("PUT", fmt.Sprintf("/api/users/%s/password", user.ID.Hex()), gin.H{
+			"old_password": "hithere",
+			"new_password": "newpassword",
+		}),
+		ExpectedCode: http.StatusUnauthorized,
 	})
 
-	if len(out) != 2 {
-		t.Errorf("Feed count mismatch: %d != %d", len(out), 2)
+	var logs []db.Log
+	if err := app.DB.Logs.Find(nil).All(&logs); err != nil {
+		t.Fatal("Could not fetch logs:", err)
+	}
+
+	if len(logs) != 1 {
+		t.Fatalf("len(logs) = %d, expected 1", len(logs))
+	}
+	if logs[0].RequestBody != "[redacted]" {
+		t.Errorf("RequestBody = %q, expected %q", logs[0].RequestBody, "[redacted]")
 	}
 }
 
-func TestGetFeedSortedByModificationTime(t *testing.T) {
+func TestChangePassword(t *testing.T) {
 	app := newTestApp()
-	feed1 := createFeed(t, app, &db.Feed{
-		URL:              "http://google.com",
-		ModificationTime: utctime.Now(),
+	user := createUser(t, app, "chris", "hithere")
+	otherSession := createSession(t, app, user)
+	endpoint := fmt.Sprintf("/api/users/%s/password", user.ID.Hex())
+
+	testEndpoint(t, endpointTestInfo{
+		App:  app,
+		User: user,
+		Request: newRequest("PUT", endpoint, gin.H{
+			"old_password": "wrong",
+			"new_password": "newpassword",
+		}),
+		ExpectedCode: http.StatusForbidden,
 	})
-	feed2 := createFeed(t, app, &db.Feed{
-		URL:              "http://google2.com",
-		ModificationTime: utctime.Now(),
+
+	testEndpoint(t, endpointTestInfo{
+		App:  app,
+		User: user,
+		Request: newRequest("PUT", endpoint, gin.H{
+			"old_password": "hithere",
+			"new_password": "short",
+		}),
+		ExpectedCode: http.StatusBadRequest,
 	})
 
-	var out []db.Feed
 	testEndpoint(t, endpointTestInfo{
-		App:          app,
-		Request:      newRequest("GET", "/api/feeds?sort_by=modification_time&sort_order=asc", nil),
+		App:  app,
+		User: user,
+		Request: newRequest("PUT", endpoint, gin.H{
+			"old_password": "hithere",
+			"new_password": "newpassword",
+		}),
 		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
 	})
 
-	if len(out) != 2 || out[0].URL != feed1.URL || out[1].URL != feed2.URL {
-		t.Error("feed order is incorrect sort_order=asc")
-	}
-
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("GET", "/api/feeds?sort_by=modification_time&sort_order=desc", nil),
+		Request:      newRequest("GET", "/login?username=chris&password=newpassword", nil),
 		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
 	})
 
-	if len(out) != 2 || out[0].URL != feed2.URL || out[1].URL != feed1.URL {
-		t.Error("feed order is incorrect sort_order=desc")
-	}
+	// Other sessions are signed out
+	req := newRequest("GET", fmt.Sprintf("/api/users/%s", user.ID.Hex()), nil)
+	req.Header.Set("Authorization", "Bearer "+otherSession)
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		Request:      req,
+		ExpectedCode: http.StatusUnauthorized,
+	})
 }
 
-func TestGetFeedByURL(t *testing.T) {
+func TestResetPassword(t *testing.T) {
 	app := newTestApp()
-	feed := createFeed(t, app, &db.Feed{URL: "http://google.com"})
+	admin := createAdmin(t, app, "admin", "hithere")
+	user := createUser(t, app, "chris", "hithere")
 
-	req := newRequest("GET", fmt.Sprintf("/api/feeds?url=%s", feed.URL), nil)
-	var out db.Feed
+	// Only admins can issue reset tokens
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      req,
-		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
+		User:         user,
+		Request:      newRequest("POST", fmt.Sprintf("/api/users/%s/reset_token", user.ID.Hex()), nil),
+		ExpectedCode: http.StatusForbidden,
 	})
 
-	if out.ID != feed.ID {
-		t.Errorf("ID mismatch: %s != %s", out.ID, feed.ID)
+	var out struct {
+		Token string `json:"token"`
 	}
-
-	// Non-existant URL
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("GET", "/api/feeds?url=wrongurl", nil),
-		ExpectedCode: http.StatusNotFound,
+		User:         admin,
+		Request:      newRequest("POST", fmt.Sprintf("/api/users/%s/reset_token", user.ID.Hex()), nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
 	})
-}
 
-func TestGetFeedByITunesID(t *testing.T) {
-	app := newTestApp()
-	feed := createFeed(t, app, &db.Feed{
-		URL:      "http://google.com",
-		ITunesID: 12345,
+	endpoint := fmt.Sprintf("/api/users/%s/reset_password", user.ID.Hex())
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		Request:      newRequest("POST", endpoint, gin.H{"token": "bad", "new_password": "newpassword"}),
+		ExpectedCode: http.StatusForbidden,
 	})
 
-	req := newRequest("GET", fmt.Sprintf("/api/feeds?itunes_id=%d", feed.ITunesID), nil)
-	var out db.Feed
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      req,
+		Request:      newRequest("POST", endpoint, gin.H{"token": out.Token, "new_password": "newpassword"}),
 		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
 	})
 
-	if out.ID != feed.ID {
-		t.Errorf("ID mismatch: %s != %s", out.ID, feed.ID)
-	}
-
-	// Non-existant iTunes ID
+	// Tokens can only be used once
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("GET", "/api/feeds?itunes_id=123", nil),
-		ExpectedCode: http.StatusNotFound,
+		Request:      newRequest("POST", endpoint, gin.H{"token": out.Token, "new_password": "newpassword2"}),
+		ExpectedCode: http.StatusForbidden,
 	})
 
-	// Invalid parameter
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("GET", "/api/feeds?itunes_id=notanum", nil),
-		ExpectedCode: http.StatusBadRequest,
+		Request:      newRequest("GET", "/login?username=chris&password=newpassword", nil),
+		ExpectedCode: http.StatusOK,
 	})
 }
 
-func TestPutFeed(t *testing.T) {
+func TestGetUser(t *testing.T) {
 	app := newTestApp()
-	feed := createFeed(t, app, &db.Feed{
-		URL:      "http://google.com",
-		ITunesID: 12345,
-	})
-	feed.Title = "Something"
+	user := createUser(t, app, "chris", "hithere")
 
-	url := fmt.Sprintf("/api/feeds/%s", feed.ID.Hex())
-	var out db.Feed
+	var out db.User
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("PUT", url, feed),
+		User:         user,
+		Request:      newRequest("GET", fmt.Sprintf("/api/users/%s", user.ID.Hex()), nil),
 		ExpectedCode: http.StatusOK,
 		ResponseBody: &out,
 	})
 
-	if out.Title != feed.Title {
-		t.Errorf("Unexpected title: %s != %s", out.Title, feed.Title)
+	if out.ID != user.ID {
+		t.Errorf("ID mismatch: %s != %s", out.ID, user.ID)
 	}
 
-	// No body given
+	// Non-existant ID
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("PUT", url, nil),
-		ExpectedCode: http.StatusBadRequest,
+		User:         user,
+		Request:      newRequest("GET", fmt.Sprintf("/api/users/%s", db.NewID().Hex()), nil),
+		ExpectedCode: http.StatusForbidden,
 	})
 }
 
-// Regression test to ensure items array is not modified
-func TestPutFeedWithExistingItems(t *testing.T) {
+func TestUserResources_OtherUser(t *testing.T) {
 	app := newTestApp()
-	feed := createFeed(t, app, &db.Feed{
-		URL: "http://google.com",
-	})
-	createItem(t, app, &db.Item{
-		GUID:   "http://google.com/item",
-		FeedID: feed.ID,
-	})
-
-	url := fmt.Sprintf("/api/feeds/%s", feed.ID.Hex())
-	testEndpoint(t, endpointTestInfo{
-		App:          app,
-		Request:      newRequest("PUT", url, feed),
-		ExpectedCode: http.StatusOK,
+	user := createUser(t, app, "chris", "hithere")
+	other := createUser(t, app, "john", "hithere")
+	item := createItem(t, app, &db.Item{
+		GUID:   "http://google.com/1",
+		FeedID: createFeed(t, app, &db.Feed{URL: "http://google.com"}).ID,
 	})
 
-	n, err := app.DB.Items.ItemsWithFeedID(feed.ID).Count()
-	if err != nil {
-		t.Fatalf("ItemsWithFeedID failed: %s", err)
+	requests := []*http.Request{
+		newRequest("GET", fmt.Sprintf("/api/users/%s", other.ID.Hex()), nil),
+		newRequest("GET", fmt.Sprintf("/api/users/%s/feeds", other.ID.Hex()), nil),
+		newRequest("PUT", fmt.Sprintf("/api/users/%s/feeds", other.ID.Hex()), []db.ID{}),
+		newRequest("GET", fmt.Sprintf("/api/users/%s/states", other.ID.Hex()), nil),
+		newRequest("PUT", fmt.Sprintf("/api/users/%s/states/%s", other.ID.Hex(), item.ID.Hex()), &api.ItemState{}),
+		newRequest("DELETE", fmt.Sprintf("/api/users/%s/states/%s", other.ID.Hex(), item.ID.Hex()), nil),
 	}
 
-	if n != 1 {
-		t.Errorf("num items mismatch: %d != 1", n)
+	for _, req := range requests {
+		testEndpoint(t, endpointTestInfo{
+			App:          app,
+			User:         user,
+			Request:      req,
+			ExpectedCode: http.StatusForbidden,
+		})
 	}
 }
 
-func TestGetUserFeedItems(t *testing.T) {
+func TestGetUserFeeds(t *testing.T) {
 	app := newTestApp()
-	feed := createFeed(t, app, &db.Feed{
-		URL: "http://google.com",
-	})
-	item := createItem(t, app, &db.Item{
-		GUID:   "http://google.com/item",
-		FeedID: feed.ID,
-	})
+	user := createUser(t, app, "chris", "hithere")
+	user.FeedIDs = append(user.FeedIDs, db.NewID())
 
-	req := newRequest("GET", fmt.Sprintf("/api/feeds/%s/items", feed.ID.Hex()), nil)
-	var items []db.Item
+	if err := app.DB.Users.Update(user); err != nil {
+		t.Fatal("Could not update user:", err)
+	}
+
+	var out []db.ID
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      req,
+		User:         user,
+		Request:      newRequest("GET", fmt.Sprintf("/api/users/%s/feeds", user.ID.Hex()), nil),
 		ExpectedCode: http.StatusOK,
-		ResponseBody: &items,
+		ResponseBody: &out,
 	})
 
-	if len(items) != 1 {
-		t.Errorf("items len mismatch: %d != %d", len(items), 1)
-	} else if items[0].ID != item.ID {
-		t.Errorf("item id mismatch: %s != %s", items[0].ID, item.ID)
+	if len(out) == len(user.FeedIDs) {
+		if out[0] != user.FeedIDs[0] {
+			t.Errorf("ID mismatch: %s != %s", out[0], user.FeedIDs[0])
+		}
+	} else {
+		t.Errorf("Unexpected # of feed IDs: %d != %d", len(out), len(user.FeedIDs))
 	}
 }
 
-func TestGetUserFeedItemsWithModTime(t *testing.T) {
+func TestGetUserItemStates(t *testing.T) {
 	app := newTestApp()
-	feed := createFeed(t, app, &db.Feed{
-		URL: "http://google.com",
-	})
-	item := createItem(t, app, &db.Item{
-		GUID:   "http://google.com/item",
-		FeedID: feed.ID,
+	user := createUser(t, app, "chris", "hithere")
+	user.ItemStates = append(user.ItemStates, db.ItemState{
+		ItemID:   db.NewID(),
+		Position: 5,
 	})
 
-	modTime := item.ModificationTime.Add(1 * time.Second)
-	url := fmt.Sprintf("/api/feeds/%s/items?modified_since=%s", feed.ID.Hex(), modTime.Format(time.RFC3339))
-	req := newRequest("GET", url, nil)
-	var items []db.Item
+	if err := app.DB.Users.Update(user); err != nil {
+		t.Fatal("Could not update user:", err)
+	}
+
+	var out []db.ItemState
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", fmt.Sprintf("/api/users/%s/states", user.ID.Hex()), nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if len(out) == len(user.ItemStates) {
+		if out[0].ItemID != user.ItemStates[0].ItemID {
+			t.Errorf("ID mismatch: %s != %s", out[0].ItemID, user.ItemStates[0].ItemID)
+		}
+	} else {
+		t.Errorf("Unexpected # of feed IDs: %d != %d", len(out), len(user.ItemStates))
+	}
+}
+
+func TestGetUserItemStates_WithModifiedSinceParam(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	user.ItemStates = append(user.ItemStates, db.ItemState{
+		ItemID:           db.NewID(),
+		Position:         5,
+		ModificationTime: utctime.Now(),
+	})
+
+	if err := app.DB.Users.Update(user); err != nil {
+		t.Fatal("Could not update user:", err)
+	}
+
+	modTime := user.ItemStates[0].ModificationTime
+
+	urlWithTime := func(modTime utctime.Time) string {
+		return fmt.Sprintf("/api/users/%s/states?modified_since=%s", user.ID.Hex(), modTime.Format(time.RFC3339))
+	}
+
+	var out []db.ItemState
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", urlWithTime(modTime.Add(-1*time.Second)), nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if len(out) != 1 {
+		t.Errorf("Unexpected response length: %d != 1", len(out))
+	}
+
+	out = make([]db.ItemState, 0)
+
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", urlWithTime(modTime.Add(1*time.Second)), nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if len(out) != 0 {
+		t.Errorf("Unexpected response length: %d != 0", len(out))
+	}
+}
+
+func TestPutUserFeeds(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+
+	ids := []db.ID{db.NewID()}
+	req := newRequest("PUT", fmt.Sprintf("/api/users/%s/feeds", user.ID.Hex()), ids)
+	var out db.User
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      req,
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if len(out.FeedIDs) == len(ids) {
+		if out.FeedIDs[0] != ids[0] {
+			t.Errorf("Feed ID mismatch: %s != %s", out.FeedIDs[0], ids[0])
+		}
+	} else {
+		t.Errorf("Unexpected # of feed IDs: %d != %d", len(out.FeedIDs), len(ids))
+	}
+}
+
+func TestPutUserItemState(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	item := createItem(t, app, &db.Item{
+		GUID:   "http://google.com/1",
+		FeedID: createFeed(t, app, &db.Feed{URL: "http://google.com"}).ID,
+	})
+
+	state := api.ItemState{
+		ItemID:   item.ID.Hex(),
+		Position: 5,
+	}
+	req := newRequest("PUT", fmt.Sprintf("/api/users/%s/states/%s", user.ID.Hex(), state.ItemID), state)
+	var out api.ItemState
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      req,
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if out.ItemID != state.ItemID {
+		t.Errorf("Item ID mismatch: %s != %s", out.ItemID, state.ItemID)
+	}
+}
+
+func TestPutUserItemState_WithOutdatedState(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	item := createItem(t, app, &db.Item{
+		GUID:   "http://google.com/1",
+		FeedID: createFeed(t, app, &db.Feed{URL: "http://google.com"}).ID,
+	})
+
+	state := api.ItemState{
+		ItemID:   item.ID.Hex(),
+		State:    api.StateInProgress,
+		Position: 5,
+	}
+
+	req := newRequest("PUT", fmt.Sprintf("/api/users/%s/states/%s", user.ID.Hex(), state.ItemID), &state)
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      req,
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &state,
+	})
+
+	state.ModificationTime = state.ModificationTime.Add(-1 * time.Second)
+
+	req = newRequest("PUT", fmt.Sprintf("/api/users/%s/states/%s", user.ID.Hex(), state.ItemID), &state)
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      req,
+		ExpectedCode: http.StatusConflict,
+	})
+}
+
+func TestDeleteUserItemState(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	item := createItem(t, app, &db.Item{
+		GUID:   "http://google.com/1",
+		FeedID: createFeed(t, app, &db.Feed{URL: "http://google.com"}).ID,
+	})
+
+	user.ItemStates = append(user.ItemStates, db.ItemState{
+		ItemID:   item.ID,
+		State:    api.StatePlayed,
+		Position: 0,
+	})
+
+	if err := app.DB.Users.Update(user); err != nil {
+		t.Fatal("Could not update user:", err)
+	}
+
+	req := newRequest("DELETE", fmt.Sprintf("/api/users/%s/states/%s", user.ID.Hex(), item.ID.Hex()), nil)
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      req,
+		ExpectedCode: http.StatusOK,
+	})
+
+	if err := app.DB.Users.FindByID(user.ID).One(&user); err != nil {
+		t.Fatal("Could not find user:", err)
+	}
+
+	if len(user.ItemStates) != 0 {
+		t.Errorf("# of item states mismatch: %d != %d", len(user.ItemStates), 0)
+	}
+}
+
+func TestCreateFeed(t *testing.T) {
+	app := newTestApp()
+	user := createAdmin(t, app, "chris", "hithere")
+	in := db.Feed{URL: "http://google.com"}
+
+	var out db.Feed
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("POST", "/api/feeds", &in),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if out.ID == emptyID {
+		t.Error("ID is invalid")
+	}
+
+	if out.URL != in.URL {
+		t.Errorf("URL mismatch: %s != %s", out.URL, in.URL)
+	}
+
+	// Duplicate entry
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("POST", "/api/feeds", &in),
+		ExpectedCode: http.StatusConflict,
+	})
+
+	// No body given
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("POST", "/api/feeds", nil),
+		ExpectedCode: http.StatusBadRequest,
+	})
+}
+
+func TestGetFeed(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{URL: "http://google.com"})
+
+	req := newRequest("GET", fmt.Sprintf("/api/feeds/%s", feed.ID.Hex()), nil)
+	var out db.Feed
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      req,
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if out.ID != feed.ID {
+		t.Errorf("ID mismatch: %s != %s", out.ID, feed.ID)
+	}
+}
+
+func TestGetFeed_Merged(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{URL: "http://google.com/a"})
+	dup := createFeed(t, app, &db.Feed{URL: "http://google.com/b"})
+
+	if err := app.DB.MergeFeeds(feed.ID, dup.ID); err != nil {
+		t.Fatal("MergeFeeds failed:", err)
+	}
+
+	var out db.Feed
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", fmt.Sprintf("/api/feeds/%s", dup.ID.Hex()), nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if out.ID != feed.ID {
+		t.Errorf("ID mismatch: %s != %s", out.ID, feed.ID)
+	}
+
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", fmt.Sprintf("/api/feeds/%s", db.NewID().Hex()), nil),
+		ExpectedCode: http.StatusNotFound,
+	})
+}
+
+func TestGetFeedItems_Merged(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{URL: "http://google.com/a"})
+	dup := createFeed(t, app, &db.Feed{URL: "http://google.com/b"})
+	createItem(t, app, &db.Item{GUID: "1", FeedID: feed.ID})
+	createItem(t, app, &db.Item{GUID: "2", FeedID: dup.ID})
+
+	if err := app.DB.MergeFeeds(feed.ID, dup.ID); err != nil {
+		t.Fatal("MergeFeeds failed:", err)
+	}
+
+	var items []db.Item
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", fmt.Sprintf("/api/feeds/%s/items", dup.ID.Hex()), nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &items,
+	})
+
+	if len(items) != 2 {
+		t.Errorf("items len mismatch: %d != 2", len(items))
+	}
+
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", fmt.Sprintf("/api/feeds/%s/items", db.NewID().Hex()), nil),
+		ExpectedCode: http.StatusNotFound,
+	})
+}
+
+func TestGetFeedWithoutParams(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	createFeed(t, app, &db.Feed{URL: "http://google.com"})
+	createFeed(t, app, &db.Feed{URL: "http://google2.com"})
+
+	var out []db.Feed
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", "/api/feeds", nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if len(out) != 2 {
+		t.Errorf("Feed count mismatch: %d != %d", len(out), 2)
+	}
+}
+
+func TestGetFeedSortedByModificationTime(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	feed1 := createFeed(t, app, &db.Feed{
+		URL:              "http://google.com",
+		ModificationTime: utctime.Now(),
+	})
+	feed2 := createFeed(t, app, &db.Feed{
+		URL:              "http://google2.com",
+		ModificationTime: utctime.Now(),
+	})
+
+	var out []db.Feed
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", "/api/feeds?sort_by=modification_time&sort_order=asc", nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if len(out) != 2 || out[0].URL != feed1.URL || out[1].URL != feed2.URL {
+		t.Error("feed order is incorrect sort_order=asc")
+	}
+
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", "/api/feeds?sort_by=modification_time&sort_order=desc", nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if len(out) != 2 || out[0].URL != feed2.URL || out[1].URL != feed1.URL {
+		t.Error("feed order is incorrect sort_order=desc")
+	}
+}
+
+func TestGetFeedByURL(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{URL: "http://google.com"})
+
+	req := newRequest("GET", fmt.Sprintf("/api/feeds?url=%s", feed.URL), nil)
+	var out db.Feed
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      req,
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if out.ID != feed.ID {
+		t.Errorf("ID mismatch: %s != %s", out.ID, feed.ID)
+	}
+
+	// Non-existant URL
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", "/api/feeds?url=wrongurl", nil),
+		ExpectedCode: http.StatusNotFound,
+	})
+}
+
+func TestGetFeedByURL_Alias(t *testing.T) {
+	app := newTestApp()
+	user := createAdmin(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{URL: "http://google.com/old"})
+
+	feed.URL = "http://google.com/new"
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("PUT", fmt.Sprintf("/api/feeds/%s", feed.ID.Hex()), feed),
+		ExpectedCode: http.StatusOK,
+	})
+
+	for _, u := range []string{"http://google.com/old", "http://google.com/new"} {
+		var out db.Feed
+		testEndpoint(t, endpointTestInfo{
+			App:          app,
+			User:         user,
+			Request:      newRequest("GET", "/api/feeds?url="+url.QueryEscape(u), nil),
+			ExpectedCode: http.StatusOK,
+			ResponseBody: &out,
+		})
+
+		if out.ID != feed.ID {
+			t.Errorf("ID mismatch for %s: %s != %s", u, out.ID, feed.ID)
+		}
+		if out.URL != "http://google.com/new" {
+			t.Errorf("URL mismatch: %s", out.URL)
+		}
+	}
+}
+
+func TestGetFeedByURL_Equivalent(t *testing.T) {
+	app := newTestApp()
+	user := createAdmin(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{URL: "https://feeds.feedburner.com/Show?format=xml"})
+
+	for _, u := range []string{
+		"https://feeds.feedburner.com/Show",
+		"http://feeds.feedburner.com/Show/",
+		"HTTPS://FEEDS.FEEDBURNER.COM/Show?format=rss",
+	} {
+		var out db.Feed
+		testEndpoint(t, endpointTestInfo{
+			App:          app,
+			User:         user,
+			Request:      newRequest("GET", "/api/feeds?url="+url.QueryEscape(u), nil),
+			ExpectedCode: http.StatusOK,
+			ResponseBody: &out,
+		})
+
+		if out.ID != feed.ID {
+			t.Errorf("ID mismatch for %s: %s != %s", u, out.ID, feed.ID)
+		}
+	}
+
+	// Equivalent URLs are duplicates
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("POST", "/api/feeds", &db.Feed{URL: "http://feeds.feedburner.com/Show"}),
+		ExpectedCode: http.StatusConflict,
+	})
+}
+
+func TestGetFeedsSubscribed(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{URL: "http://google.com"})
+	createFeed(t, app, &db.Feed{URL: "http://yahoo.com"})
+
+	user.FeedIDs = []db.ID{feed.ID}
+	if err := app.DB.Users.Update(user); err != nil {
+		t.Fatal("Could not update user:", err)
+	}
+
+	var out []db.Feed
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", "/api/feeds?subscribed=true", nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if len(out) != 1 {
+		t.Fatalf("Unexpected # of feeds: %d != 1", len(out))
+	}
+	if out[0].ID != feed.ID {
+		t.Errorf("ID mismatch: %s != %s", out[0].ID, feed.ID)
+	}
+}
+
+func TestGetFeedsDue(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+
+	now := utctime.Now()
+	dueFeed := createFeed(t, app, &db.Feed{URL: "http://google.com"})
+	dueFeed.NextCheckTime = now.Add(-time.Minute)
+	notDueFeed := createFeed(t, app, &db.Feed{URL: "http://yahoo.com"})
+	notDueFeed.NextCheckTime = now.Add(time.Hour)
+	for _, feed := range []*db.Feed{dueFeed, notDueFeed} {
+		if err := app.DB.Feeds.Update(feed); err != nil {
+			t.Fatal("Could not update feed:", err)
+		}
+	}
+	newFeed := createFeed(t, app, &db.Feed{URL: "http://bing.com"})
+	createFeed(t, app, &db.Feed{URL: "http://duckduckgo.com"})
+	disabledFeed := createFeed(t, app, &db.Feed{URL: "http://altavista.com", Disabled: true})
+
+	user.FeedIDs = []db.ID{dueFeed.ID, notDueFeed.ID, newFeed.ID, disabledFeed.ID}
+	if err := app.DB.Users.Update(user); err != nil {
+		t.Fatal("Could not update user:", err)
+	}
+
+	var out []db.Feed
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", "/api/feeds?due=true", nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	ids := make(map[db.ID]bool)
+	for _, feed := range out {
+		ids[feed.ID] = true
+	}
+	if len(ids) != 2 || !ids[dueFeed.ID] || !ids[newFeed.ID] {
+		t.Errorf("Unexpected feeds: %v", out)
+	}
+
+	// Feeds are due according to the app's clock
+	app.Clock = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", "/api/feeds?due=true", nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+	if len(out) != 3 {
+		t.Errorf("Unexpected feeds: %v", out)
+	}
+}
+
+func TestLeaseDueFeed(t *testing.T) {
+	app := newTestApp()
+	user := createAdmin(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{URL: "http://google.com"})
+	url := fmt.Sprintf("/api/feeds/%s/lease", feed.ID.Hex())
+
+	// Feeds may not be held back indefinitely
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("POST", url, gin.H{"until": time.Now().Add(48 * time.Hour)}),
+		ExpectedCode: http.StatusBadRequest,
+	})
+
+	// Updated after the feed was found to be due
+	feed.Title = "Google"
+	if err := app.DB.Feeds.Update(feed); err != nil {
+		t.Fatal("Could not update feed:", err)
+	}
+
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("POST", url, gin.H{"until": until}),
+		ExpectedCode: http.StatusOK,
+	})
+
+	out, err := app.DB.Feeds.FeedByID(feed.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Title != "Google" {
+		t.Errorf("Title was lost: %q", out.Title)
+	}
+	if got := out.NextCheckTime.Format(time.RFC3339); got != until.Format(time.RFC3339) {
+		t.Errorf("NextCheckTime mismatch: %s != %s", got, until.Format(time.RFC3339))
+	}
+
+	// The feed is no longer due
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("POST", url, gin.H{"until": until}),
+		ExpectedCode: http.StatusConflict,
+	})
+}
+
+func TestGetFeedsHealth(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+
+	healthy := createFeed(t, app, &db.Feed{URL: "http://google.com"})
+	failing := createFeed(t, app, &db.Feed{
+		URL:                 "http://yahoo.com",
+		ConsecutiveFailures: 3,
+		LastError:           "unexpected response: 500 Internal Server Error",
+		LastStatusCode:      500,
+	})
+	disabled := createFeed(t, app, &db.Feed{
+		URL:                 "http://bing.com",
+		ConsecutiveFailures: 1,
+		LastStatusCode:      410,
+		Disabled:            true,
+	})
+
+	cases := []struct {
+		Health   string
+		Expected []db.ID
+	}{
+		{"healthy", []db.ID{healthy.ID}},
+		{"failing", []db.ID{failing.ID, disabled.ID}},
+		{"disabled", []db.ID{disabled.ID}},
+	}
+
+	for _, c := range cases {
+		var out []db.Feed
+		testEndpoint(t, endpointTestInfo{
+			App:          app,
+			User:         user,
+			Request:      newRequest("GET", "/api/feeds?health="+c.Health, nil),
+			ExpectedCode: http.StatusOK,
+			ResponseBody: &out,
+		})
+
+		ids := make(map[db.ID]bool)
+		for _, feed := range out {
+			ids[feed.ID] = true
+		}
+		if len(ids) != len(c.Expected) {
+			t.Errorf("Unexpected %s feeds: %v", c.Health, out)
+			continue
+		}
+		for _, id := range c.Expected {
+			if !ids[id] {
+				t.Errorf("Feed %s is not %s", id, c.Health)
+			}
+		}
+	}
+
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", "/api/feeds?health=unknown", nil),
+		ExpectedCode: http.StatusBadRequest,
+	})
+}
+
+func TestGetFeedByITunesID(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{
+		URL:      "http://google.com",
+		ITunesID: 12345,
+	})
+
+	req := newRequest("GET", fmt.Sprintf("/api/feeds?itunes_id=%d", feed.ITunesID), nil)
+	var out db.Feed
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      req,
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if out.ID != feed.ID {
+		t.Errorf("ID mismatch: %s != %s", out.ID, feed.ID)
+	}
+
+	// Non-existant iTunes ID
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", "/api/feeds?itunes_id=123", nil),
+		ExpectedCode: http.StatusNotFound,
+	})
+
+	// Invalid parameter
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", "/api/feeds?itunes_id=notanum", nil),
+		ExpectedCode: http.StatusBadRequest,
+	})
+}
+
+func TestPutFeed(t *testing.T) {
+	app := newTestApp()
+	user := createAdmin(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{
+		URL:      "http://google.com",
+		ITunesID: 12345,
+	})
+	feed.Title = "Something"
+
+	url := fmt.Sprintf("/api/feeds/%s", feed.ID.Hex())
+	var out db.Feed
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("PUT", url, feed),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if out.Title != feed.Title {
+		t.Errorf("Unexpected title: %s != %s", out.Title, feed.Title)
+	}
+
+	// No body given
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("PUT", url, nil),
+		ExpectedCode: http.StatusBadRequest,
+	})
+}
+
+// Regression test to ensure items array is not modified
+func TestPutFeedWithExistingItems(t *testing.T) {
+	app := newTestApp()
+	user := createAdmin(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{
+		URL: "http://google.com",
+	})
+	createItem(t, app, &db.Item{
+		GUID:   "http://google.com/item",
+		FeedID: feed.ID,
+	})
+
+	url := fmt.Sprintf("/api/feeds/%s", feed.ID.Hex())
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("PUT", url, feed),
+		ExpectedCode: http.StatusOK,
+	})
+
+	n, err := app.DB.Items.ItemsWithFeedID(feed.ID).Count()
+	if err != nil {
+		t.Fatalf("ItemsWithFeedID failed: %s", err)
+	}
+
+	if n != 1 {
+		t.Errorf("num items mismatch: %d != 1", n)
+	}
+}
+
+func TestGetUserFeedItems(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{
+		URL: "http://google.com",
+	})
+	item := createItem(t, app, &db.Item{
+		GUID:   "http://google.com/item",
+		FeedID: feed.ID,
+	})
+
+	req := newRequest("GET", fmt.Sprintf("/api/feeds/%s/items", feed.ID.Hex()), nil)
+	var items []db.Item
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      req,
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &items,
+	})
+
+	if len(items) != 1 {
+		t.Errorf("items len mismatch: %d != %d", len(items), 1)
+	} else if items[0].ID != item.ID {
+		t.Errorf("item id mismatch: %s != %s", items[0].ID, item.ID)
+	}
+}
+
+func TestGetFeedItems_SortedByEpisode(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{
+		URL: "http://google.com",
+	})
+	for _, ep := range []float64{2, 1, 3} {
+		createItem(t, app, &db.Item{
+			GUID:    fmt.Sprintf("http://google.com/item/%v", ep),
+			FeedID:  feed.ID,
+			Season:  1,
+			Episode: ep,
+		})
+	}
+
+	url := fmt.Sprintf("/api/feeds/%s/items?sort_by=episode&sort_order=asc", feed.ID.Hex())
+	var items []db.Item
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", url, nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &items,
+	})
+
+	if len(items) != 3 {
+		t.Fatalf("items len mismatch: %d != %d", len(items), 3)
+	}
+	for i := range items {
+		if items[i].Episode != float64(i+1) {
+			t.Errorf("item order is incorrect: %v at %d", items[i].Episode, i)
+		}
+	}
+
+	url = fmt.Sprintf("/api/feeds/%s/items?sort_by=guid", feed.ID.Hex())
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", url, nil),
+		ExpectedCode: http.StatusBadRequest,
+	})
+}
+
+func TestGetUserFeedItemsWithModTime(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{
+		URL: "http://google.com",
+	})
+	item := createItem(t, app, &db.Item{
+		GUID:   "http://google.com/item",
+		FeedID: feed.ID,
+	})
+
+	modTime := item.ModificationTime.Add(1 * time.Second)
+	url := fmt.Sprintf("/api/feeds/%s/items?modified_since=%s", feed.ID.Hex(), modTime.Format(time.RFC3339))
+	req := newRequest("GET", url, nil)
+	var items []db.Item
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      req,
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &items,
+	})
+
+	if len(items) != 0 {
+		t.Errorf("items len mismatch: %d != %d", len(items), 0)
+	}
+
+	modTime = item.ModificationTime.Add(-2 * time.Second)
+	url = fmt.Sprintf("/api/feeds/%s/items?modified_since=%s", feed.ID.Hex(), modTime.Format(time.RFC3339))
+	req = newRequest("GET", url, nil)
+	items = []db.Item{}
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      req,
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &items,
+	})
+
+	if len(items) != 1 {
+		t.Errorf("items len mismatch: %d != %d", len(items), 1)
+	}
+}
+
+func TestGetFeedsUsers(t *testing.T) {
+	app := newTestApp()
+	feed := createFeed(t, app, &db.Feed{
+		URL:      "http://google.com",
+		ITunesID: 12345,
+	})
+	user := createAdmin(t, app, "chris", "whatever")
+
+	user.FeedIDs = append(user.FeedIDs, feed.ID)
+	if err := app.DB.Users.Update(user); err != nil {
+		t.Fatal("Failed to update user:", err)
+	}
+
+	req := newRequest("GET", fmt.Sprintf("/api/feeds/%s/users", feed.ID.Hex()), nil)
+	var out []db.User
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      req,
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if len(out) == 1 {
+		if out[0].ID != user.ID {
+			t.Errorf("User ID mismatch: %s != %s", out[0].ID, user.ID)
+		}
+	} else {
+		t.Errorf("Unexpected number of users: %d != %d", len(out), 1)
+	}
+}
+
+func TestCreateFeedItem(t *testing.T) {
+	app := newTestApp()
+	user := createAdmin(t, app, "chris", "hithere")
+	feedID := createFeed(t, app, &db.Feed{URL: "http://google.com"}).ID
+	item := db.Item{GUID: "http://google.com/items/1"}
+
+	req := newRequest("POST", fmt.Sprintf("/api/feeds/%s/items", feedID.Hex()), &item)
+	var out db.Item
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      req,
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if out.GUID != item.GUID {
+		t.Errorf("GUID mismatch: %s != %s", out.GUID, item.GUID)
+	}
+
+	var items []db.Item
+	if err := app.DB.Items.ItemsWithFeedID(feedID).All(&items); err != nil {
+		t.Fatalf("ItemsWithFeedID failed: %s", err)
+	}
+
+	if len(items) != 1 {
+		t.Errorf("items len mismatch: %d != %d", len(items), 1)
+	} else if items[0].ID != out.ID {
+		t.Errorf("id mismatch: %s != %s", items[0].ID, out.ID)
+	}
+}
+
+func TestGetFeedItem(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{
+		URL: "http://google.com",
+	})
+	item := createItem(t, app, &db.Item{
+		GUID:   "http://google.com/item",
+		FeedID: feed.ID,
+	})
+
+	url := fmt.Sprintf("/api/feeds/%s/items/%s", feed.ID.Hex(), item.ID.Hex())
+	req := newRequest("GET", url, item)
+	var out db.Item
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      req,
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if out.GUID != item.GUID {
+		t.Errorf("GUID mismatch: %s != %s", out.GUID, item.GUID)
+	}
+}
+
+func TestPutFeedItem(t *testing.T) {
+	app := newTestApp()
+	user := createAdmin(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{
+		URL: "http://google.com",
+	})
+	item := createItem(t, app, &db.Item{
+		GUID:   "http://google.com/item",
+		FeedID: feed.ID,
+	})
+
+	item.URL = "http://google.com/item.mp3"
+
+	url := fmt.Sprintf("/api/feeds/%s/items/%s", feed.ID.Hex(), item.ID.Hex())
+	req := newRequest("PUT", url, item)
+	var out db.Item
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      req,
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if out.URL != item.URL {
+		t.Errorf("URL mismatch: %s != %s", out.URL, item.URL)
+	}
+}
+
+func TestPutFeedItem_PodcastFields(t *testing.T) {
+	app := newTestApp()
+	user := createAdmin(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{
+		URL: "http://google.com",
+	})
+	item := createItem(t, app, &db.Item{
+		GUID:   "http://google.com/item",
+		FeedID: feed.ID,
+	})
+
+	item.Transcripts = []db.Transcript{{URL: "http://google.com/item.vtt", Type: "text/vtt"}}
+	item.Chapters = &db.Chapters{URL: "http://google.com/chapters.json", Type: "application/json+chapters"}
+	item.Persons = []db.Person{{Name: "Chris", Role: "host"}}
+
+	url := fmt.Sprintf("/api/feeds/%s/items/%s", feed.ID.Hex(), item.ID.Hex())
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("PUT", url, item),
+		ExpectedCode: http.StatusOK,
+	})
+
+	var out db.Item
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", url, nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if !reflect.DeepEqual(out.Transcripts, item.Transcripts) {
+		t.Errorf("Transcripts mismatch: %#v != %#v", out.Transcripts, item.Transcripts)
+	}
+	if !reflect.DeepEqual(out.Chapters, item.Chapters) {
+		t.Errorf("Chapters mismatch: %#v != %#v", out.Chapters, item.Chapters)
+	}
+	if !reflect.DeepEqual(out.Persons, item.Persons) {
+		t.Errorf("Persons mismatch: %#v != %#v", out.Persons, item.Persons)
+	}
+}
+
+func TestGetJob(t *testing.T) {
+	app := newTestApp()
+	user := createAdmin(t, app, "chris", "hithere")
+	job := createJob(t, app, db.Job{
+		KodaID: 1,
+	})
+
+	var out db.Job
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", fmt.Sprintf("/api/jobs/%s", job.ID.Hex()), nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if out.ID != job.ID {
+		t.Errorf("id mismatch %s != %s", out.ID, job.ID)
+	}
+}
+
+func TestCreateJob(t *testing.T) {
+	app := newTestApp()
+	user := createAdmin(t, app, "chris", "hithere")
+
+	in := db.Job{
+		Queue:    "queue",
+		Priority: 100,
+		Payload:  map[string]string{"data": "stuff"},
+	}
+
+	var out db.Job
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("POST", "/api/jobs", &in),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if out.ID == emptyID {
+		t.Error("id is invalid")
+	}
+
+	if out.KodaID == 0 {
+		t.Error("KodaID was not set")
+	}
+}
+
+func TestGetJobs(t *testing.T) {
+	app := newTestApp()
+	user := createAdmin(t, app, "chris", "hithere")
+	job := createJob(t, app, db.Job{
+		KodaID: 1,
+	})
+
+	var out []db.Job
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", "/api/jobs", nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if len(out) != 1 {
+		t.Fatalf("output len mismatch: %d != 1", len(out))
+		return
+	}
+
+	if out[0].ID != job.ID {
+		t.Error("unexpected job id")
+	}
+}
+
+func TestGetJobs_Filter(t *testing.T) {
+	app := newTestApp()
+	user := createAdmin(t, app, "chris", "hithere")
+	createJob(t, app, db.Job{
+		KodaID: 1,
+		Queue:  "some-queue",
+		State:  "done",
+	})
+
+	var out []db.Job
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", "/api/jobs?queue=some-queue", nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if len(out) != 1 {
+		t.Error("job not found")
+	}
+
+	out = make([]db.Job, 0)
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", "/api/jobs?queue=fake-queue", nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if len(out) != 0 {
+		t.Errorf("job count mismatch: %d != 0", len(out))
+	}
+
+	out = make([]db.Job, 0)
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", "/api/jobs?state=done", nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if len(out) != 1 {
+		t.Error("job not found")
+	}
+
+	out = make([]db.Job, 0)
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("GET", "/api/jobs?queue=working", nil),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	if len(out) != 0 {
+		t.Errorf("job count mismatch: %d != 0", len(out))
+	}
+}
+
+func TestAdminEndpoints_NonAdmin(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	job := createJob(t, app, db.Job{KodaID: 1})
+	feed := createFeed(t, app, &db.Feed{URL: "http://google.com"})
+	item := createItem(t, app, &db.Item{GUID: "http://google.com/item", FeedID: feed.ID})
+
+	requests := []*http.Request{
+		newRequest("GET", "/api/users", nil),
+		newRequest("GET", "/api/jobs", nil),
+		newRequest("GET", fmt.Sprintf("/api/jobs/%s", job.ID.Hex()), nil),
+		newRequest("POST", "/api/jobs", &db.Job{Queue: "queue"}),
+		newRequest("GET", "/api/logs", nil),
+		newRequest("GET", "/api/stats/queues?ts=60", nil),
+		newRequest("POST", "/api/feeds", &db.Feed{URL: "http://yahoo.com"}),
+		newRequest("PUT", fmt.Sprintf("/api/feeds/%s", feed.ID.Hex()), feed),
+		newRequest("POST", fmt.Sprintf("/api/feeds/%s/items", feed.ID.Hex()), &db.Item{GUID: "http://google.com/item2"}),
+		newRequest("PUT", fmt.Sprintf("/api/feeds/%s/items/%s", feed.ID.Hex(), item.ID.Hex()), item),
+		newRequest("POST", fmt.Sprintf("/api/feeds/%s/lease", feed.ID.Hex()), gin.H{"until": time.Now().Add(time.Hour)}),
+		newRequest("GET", fmt.Sprintf("/api/feeds/%s/users", feed.ID.Hex()), nil),
+	}
+
+	for _, req := range requests {
+		testEndpoint(t, endpointTestInfo{
+			App:          app,
+			User:         user,
+			Request:      req,
+			ExpectedCode: http.StatusForbidden,
+		})
+	}
+}
+
+func TestAPIKey_Scopes(t *testing.T) {
+	app := newTestApp()
+	feed := createFeed(t, app, &db.Feed{URL: "http://google.com"})
+	key := createAPIKey(t, app, db.ScopeFeeds)
+
+	cases := []struct {
+		Request      *http.Request
+		ExpectedCode int
+	}{
+		{newRequest("GET", fmt.Sprintf("/api/feeds/%s", feed.ID.Hex()), nil), http.StatusOK},
+		{newRequest("GET", fmt.Sprintf("/api/feeds/%s/items", feed.ID.Hex()), nil), http.StatusForbidden},
+		{newRequest("POST", "/api/jobs", &db.Job{Queue: "queue"}), http.StatusForbidden},
+		// Endpoints without API key support are denied regardless of scope
+		{newRequest("GET", "/api/users", nil), http.StatusForbidden},
+		{newRequest("GET", "/api/keys", nil), http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		c.Request.Header.Set("Authorization", "Bearer "+key)
+		testEndpoint(t, endpointTestInfo{
+			App:          app,
+			Request:      c.Request,
+			ExpectedCode: c.ExpectedCode,
+		})
+	}
+}
+
+func TestAPIKey_CreateJob(t *testing.T) {
+	app := newTestApp()
+	key := createAPIKey(t, app, db.ScopeJobs)
+
+	req := newRequest("POST", "/api/jobs", &db.Job{Queue: "queue"})
+	req.Header.Set("Authorization", "Bearer "+key)
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
 		Request:      req,
 		ExpectedCode: http.StatusOK,
-		ResponseBody: &items,
+	})
+}
+
+func TestAPIKey_UpdateUserItemState(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	item := createItem(t, app, &db.Item{
+		GUID:   "http://google.com/1",
+		FeedID: createFeed(t, app, &db.Feed{URL: "http://google.com"}).ID,
+	})
+
+	cases := []struct {
+		Scope        string
+		ExpectedCode int
+	}{
+		// Writing the states of any user requires its own scope
+		{db.ScopeItems, http.StatusForbidden},
+		{db.ScopeUserStates, http.StatusOK},
+	}
+
+	for _, c := range cases {
+		state := api.ItemState{ItemID: item.ID.Hex(), State: api.StateUnplayed}
+		req := newRequest("PUT", fmt.Sprintf("/api/users/%s/states/%s", user.ID.Hex(), item.ID.Hex()), &state)
+		req.Header.Set("Authorization", "Bearer "+createAPIKey(t, app, c.Scope))
+		testEndpoint(t, endpointTestInfo{
+			App:          app,
+			Request:      req,
+			ExpectedCode: c.ExpectedCode,
+		})
+	}
+}
+
+func TestAPIKey_Invalid(t *testing.T) {
+	req := newRequest("GET", "/api/feeds", nil)
+	req.Header.Set("Authorization", "Bearer "+db.APIKeyPrefix+"bogus")
+	testEndpoint(t, endpointTestInfo{
+		Request:      req,
+		ExpectedCode: http.StatusUnauthorized,
+	})
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	app := newTestApp()
+	admin := createAdmin(t, app, "chris", "hithere")
+
+	var out struct {
+		Key string `json:"key"`
+	}
+	testEndpoint(t, endpointTestInfo{
+		App:  app,
+		User: admin,
+		Request: newRequest("POST", "/api/keys", gin.H{
+			"name":   "worker",
+			"scopes": []string{db.ScopeFeeds},
+		}),
+		ExpectedCode: http.StatusOK,
+		ResponseBody: &out,
+	})
+
+	req := newRequest("GET", "/api/feeds", nil)
+	req.Header.Set("Authorization", "Bearer "+out.Key)
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		Request:      req,
+		ExpectedCode: http.StatusOK,
+	})
+
+	testEndpoint(t, endpointTestInfo{
+		App:  app,
+		User: admin,
+		Request: newRequest("POST", "/api/keys", gin.H{
+			"name":   "worker",
+			"scopes": []string{"bogus"},
+		}),
+		ExpectedCode: http.StatusBadRequest,
+	})
+}
+
+func TestDashboard_RequiresAdmin(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		Request:      newRequest("GET", "/dashboard/", nil),
+		ExpectedCode: http.StatusUnauthorized,
+	})
+
+	// Browsers authenticate with the session cookie set by /login
+	req := newRequest("GET", "/dashboard/", nil)
+	req.AddCookie(&http.Cookie{Name: "session", Value: createSession(t, app, user)})
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		Request:      req,
+		ExpectedCode: http.StatusForbidden,
+	})
+}
+
+func TestGetFeedItems_IncludeRemoved(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{
+		URL: "http://google.com",
+	})
+
+	since := time.Now().Add(-time.Minute).UTC()
+	removedTime := utctime.Now()
+	createItem(t, app, &db.Item{
+		GUID:   "http://google.com/item/1",
+		FeedID: feed.ID,
+	})
+	createItem(t, app, &db.Item{
+		GUID:        "http://google.com/item/2",
+		FeedID:      feed.ID,
+		RemovedTime: &removedTime,
+	})
+
+	cases := []struct {
+		Query    string
+		NumItems int
+	}{
+		{"", 1},
+		{"?include_removed=false", 1},
+		{"?include_removed=true", 2},
+		{"?modified_since=" + since.Format(time.RFC3339), 2},
+	}
+
+	for _, c := range cases {
+		url := fmt.Sprintf("/api/feeds/%s/items%s", feed.ID.Hex(), c.Query)
+		var items []db.Item
+		testEndpoint(t, endpointTestInfo{
+			App:          app,
+			User:         user,
+			Request:      newRequest("GET", url, nil),
+			ExpectedCode: http.StatusOK,
+			ResponseBody: &items,
+		})
+
+		if len(items) != c.NumItems {
+			t.Errorf("items len mismatch for %q: %d != %d", c.Query, len(items), c.NumItems)
+		}
+	}
+}
+
+func TestVerifyWebSubSubscription(t *testing.T) {
+	app := newTestApp()
+	app.WebSubKey = []byte("key")
+	now := utctime.Now()
+	feed := createFeed(t, app, &db.Feed{
+		URL:             "http://google.com",
+		HubURL:          "https://hub.example.com/",
+		HubTopic:        "http://google.com/feed",
+		HubPendingUntil: now.Add(time.Hour),
+	})
+
+	verify := func(mode, topic, leaseSeconds string, expectedCode int) *httptest.ResponseRecorder {
+		params := url.Values{
+			"hub.mode":          {mode},
+			"hub.topic":         {topic},
+			"hub.challenge":     {"challenge"},
+			"hub.lease_seconds": {leaseSeconds},
+		}
+		w := httptest.NewRecorder()
+		app.g.ServeHTTP(w, newRequest("GET", fmt.Sprintf("/api/websub/%s?%s", feed.ID.Hex(), params.Encode()), nil))
+		if w.Code != expectedCode {
+			t.Fatalf("Unexpected status code for %s of %s: %d != %d", mode, topic, w.Code, expectedCode)
+		}
+		return w
+	}
+
+	verify("subscribe", "http://google.com/other", "3600", http.StatusNotFound)
+	verify("unsubscribe", feed.HubTopic, "3600", http.StatusNotFound)
+
+	w := verify("subscribe", feed.HubTopic, "3600", http.StatusOK)
+	if w.Body.String() != "challenge" {
+		t.Errorf("Challenge mismatch: %s", w.Body.String())
+	}
+
+	var out db.Feed
+	if err := app.DB.Feeds.FindByID(feed.ID).One(&out); err != nil {
+		t.Fatal(err)
+	}
+	now = utctime.Now()
+	min, max := now.Add(59*time.Minute), now.Add(time.Hour)
+	if out.HubLeaseExpiry.Before(min) || max.Before(out.HubLeaseExpiry) {
+		t.Errorf("Unexpected lease expiry: %s", out.HubLeaseExpiry.Format(time.RFC3339))
+	}
+	if !out.HubPendingUntil.IsZero() {
+		t.Errorf("Pending subscription was not closed: %s", out.HubPendingUntil.Format(time.RFC3339))
+	}
+
+	// A verification is only accepted once per requested subscription
+	verify("subscribe", feed.HubTopic, "3600", http.StatusNotFound)
+
+	verify("denied", feed.HubTopic, "", http.StatusOK)
+	if err := app.DB.Feeds.FindByID(feed.ID).One(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.HubLeaseExpiry.IsZero() {
+		t.Errorf("Lease was not cleared: %s", out.HubLeaseExpiry.Format(time.RFC3339))
+	}
+}
+
+func TestVerifyWebSubSubscription_NotRequested(t *testing.T) {
+	app := newTestApp()
+	app.WebSubKey = []byte("key")
+	now := utctime.Now()
+	feeds := []*db.Feed{
+		{URL: "http://google.com/1", HubURL: "https://hub.example.com/", HubTopic: "http://google.com/1"},
+		{URL: "http://google.com/2", HubURL: "https://hub.example.com/", HubTopic: "http://google.com/2",
+			HubPendingUntil: now.Add(-time.Minute)},
+	}
+
+	for _, feed := range feeds {
+		feed = createFeed(t, app, feed)
+		params := url.Values{
+			"hub.mode":          {"subscribe"},
+			"hub.topic":         {feed.HubTopic},
+			"hub.challenge":     {"challenge"},
+			"hub.lease_seconds": {"3600"},
+		}
+		testEndpoint(t, endpointTestInfo{
+			App:          app,
+			Request:      newRequest("GET", fmt.Sprintf("/api/websub/%s?%s", feed.ID.Hex(), params.Encode()), nil),
+			ExpectedCode: http.StatusNotFound,
+		})
+	}
+}
+
+func TestVerifyWebSubSubscription_LongLease(t *testing.T) {
+	app := newTestApp()
+	app.WebSubKey = []byte("key")
+	now := utctime.Now()
+	feed := createFeed(t, app, &db.Feed{
+		URL:             "http://google.com",
+		HubURL:          "https://hub.example.com/",
+		HubTopic:        "http://google.com/feed",
+		HubPendingUntil: now.Add(time.Hour),
+	})
+
+	params := url.Values{
+		"hub.mode":          {"subscribe"},
+		"hub.topic":         {feed.HubTopic},
+		"hub.challenge":     {"challenge"},
+		"hub.lease_seconds": {"9223372036854775807"},
+	}
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		Request:      newRequest("GET", fmt.Sprintf("/api/websub/%s?%s", feed.ID.Hex(), params.Encode()), nil),
+		ExpectedCode: http.StatusOK,
+	})
+
+	var out db.Feed
+	if err := app.DB.Feeds.FindByID(feed.ID).One(&out); err != nil {
+		t.Fatal(err)
+	}
+	now = utctime.Now()
+	min, max := now.Add(30*24*time.Hour-time.Minute), now.Add(30*24*time.Hour)
+	if out.HubLeaseExpiry.Before(min) || max.Before(out.HubLeaseExpiry) {
+		t.Errorf("Lease was not capped: %s", out.HubLeaseExpiry.Format(time.RFC3339))
+	}
+}
+
+func TestReceiveWebSubContent(t *testing.T) {
+	app := newTestApp()
+	app.WebSubKey = []byte("key")
+	feed := createFeed(t, app, &db.Feed{
+		URL:      "http://google.com",
+		HubURL:   "https://hub.example.com/",
+		HubTopic: "http://google.com/feed",
 	})
 
-	if len(items) != 0 {
-		t.Errorf("items len mismatch: %d != %d", len(items), 0)
+	body := []byte("<rss></rss>")
+	secret := websub.Secret(app.WebSubKey, feed.ID.Hex())
+	callback := fmt.Sprintf("/api/websub/%s", feed.ID.Hex())
+
+	cases := []struct {
+		Signature string
+		NumJobs   int
+	}{
+		{"", 0},
+		{websub.Sign("wrong", body), 0},
+		{websub.Sign(secret, body), 1},
 	}
 
-	modTime = item.ModificationTime.Add(-2 * time.Second)
-	url = fmt.Sprintf("/api/feeds/%s/items?modified_since=%s", feed.ID.Hex(), modTime.Format(time.RFC3339))
-	req = newRequest("GET", url, nil)
-	items = []db.Item{}
+	for _, c := range cases {
+		req, _ := http.NewRequest("POST", callback, bytes.NewReader(body))
+		if c.Signature != "" {
+			req.Header.Set("X-Hub-Signature", c.Signature)
+		}
+		testEndpoint(t, endpointTestInfo{
+			App:          app,
+			Request:      req,
+			ExpectedCode: http.StatusAccepted,
+		})
+
+		var jobs []db.Job
+		if err := app.DB.Jobs.Find(&db.Query{
+			Filter: db.M{"queue": "update-feed", "payload.feed_id": feed.ID.Hex()},
+		}).All(&jobs); err != nil {
+			t.Fatal(err)
+		}
+		if len(jobs) != c.NumJobs {
+			t.Errorf("Job count mismatch for %q: %d != %d", c.Signature, len(jobs), c.NumJobs)
+		}
+	}
+}
+
+func TestReceiveWebSubContent_NotLogged(t *testing.T) {
+	app := newTestApp()
+	app.WebSubKey = []byte("key")
+	feed := createFeed(t, app, &db.Feed{
+		URL:      "http://google.com",
+		HubURL:   "https://hub.example.com/",
+		HubTopic: "http://google.com/feed",
+	})
+
+	body := bytes.Repeat([]byte("a"), 1<<20)
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/websub/%s", feed.ID.Hex()), bytes.NewReader(body))
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
 		Request:      req,
-		ExpectedCode: http.StatusOK,
-		ResponseBody: &items,
+		ExpectedCode: http.StatusAccepted,
 	})
 
-	if len(items) != 1 {
-		t.Errorf("items len mismatch: %d != %d", len(items), 1)
+	n, err := app.DB.Logs.Find(nil).Count()
+	if err != nil {
+		t.Fatal("Could not count logs:", err)
+	}
+	if n != 0 {
+		t.Errorf("num logs mismatch: %d != 0", n)
 	}
 }
 
-func TestGetFeedsUsers(t *testing.T) {
+func TestWebSub_Disabled(t *testing.T) {
 	app := newTestApp()
 	feed := createFeed(t, app, &db.Feed{
 		URL:      "http://google.com",
-		ITunesID: 12345,
+		HubURL:   "https://hub.example.com/",
+		HubTopic: "http://google.com/feed",
 	})
-	user := createUser(t, app, "chris", "whatever")
 
-	user.FeedIDs = append(user.FeedIDs, feed.ID)
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		Request:      newRequest("GET", fmt.Sprintf("/api/websub/%s?hub.mode=subscribe&hub.challenge=c", feed.ID.Hex()), nil),
+		ExpectedCode: http.StatusNotFound,
+	})
+}
+
+func TestImportUserOPML(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	subscribedFeed := createFeed(t, app, &db.Feed{URL: "http://google.com/feed"})
+	existingFeed := createFeed(t, app, &db.Feed{URL: "http://yahoo.com/feed"})
+
+	user.FeedIDs = []db.ID{subscribedFeed.ID}
 	if err := app.DB.Users.Update(user); err != nil {
-		t.Fatal("Failed to update user:", err)
+		t.Fatal("Could not update user:", err)
 	}
 
-	req := newRequest("GET", fmt.Sprintf("/api/feeds/%s/users", feed.ID.Hex()), nil)
-	var out []db.User
+	doc := `<?xml version="1.0"?>
+<opml version="2.0">
+  <body>
+    <outline text="Google" xmlUrl="http://google.com/feed"/>
+    <outline text="Podcasts">
+      <outline text="Yahoo" xmlUrl="itpc://yahoo.com/feed"/>
+      <outline text="Bing" xmlUrl="http://bing.com/feed"/>
+      <outline text="Bing Again" xmlUrl="http://bing.com/feed"/>
+    </outline>
+    <outline text="Broken" xmlUrl="ftp://example.com/feed"/>
+  </body>
+</opml>`
+
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/users/%s/opml", user.ID.Hex()), strings.NewReader(doc))
+	var out []endpoint.OPMLOutlineResult
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
+		User:         user,
 		Request:      req,
 		ExpectedCode: http.StatusOK,
 		ResponseBody: &out,
 	})
 
-	if len(out) == 1 {
-		if out[0].ID != user.ID {
-			t.Errorf("User ID mismatch: %s != %s", out[0].ID, user.ID)
+	expected := []string{
+		endpoint.OPMLAlreadySubscribed,
+		endpoint.OPMLSubscribed,
+		endpoint.OPMLCreated,
+		endpoint.OPMLAlreadySubscribed,
+		endpoint.OPMLInvalid,
+	}
+	if len(out) != len(expected) {
+		t.Fatalf("Unexpected # of results: %d != %d", len(out), len(expected))
+	}
+	for i, status := range expected {
+		if out[i].Status != status {
+			t.Errorf("Status mismatch for %s: %s != %s", out[i].URL, out[i].Status, status)
 		}
-	} else {
-		t.Errorf("Unexpected number of users: %d != %d", len(out), 1)
+	}
+	if out[1].FeedID != existingFeed.ID.Hex() {
+		t.Errorf("Feed ID mismatch: %s != %s", out[1].FeedID, existingFeed.ID.Hex())
+	}
+
+	var feed db.Feed
+	if err := app.DB.Feeds.Find(&db.Query{Filter: db.M{"url": "http://bing.com/feed"}}).One(&feed); err != nil {
+		t.Fatal("Feed was not created:", err)
+	}
+	if feed.Title != "Bing" {
+		t.Errorf("Title mismatch: %s != Bing", feed.Title)
+	}
+
+	var jobs []db.Job
+	if err := app.DB.Jobs.Find(&db.Query{Filter: db.M{"queue": "update-feed"}}).All(&jobs); err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 {
+		t.Errorf("Unexpected # of jobs: %d != 1", len(jobs))
+	} else if jobs[0].Priority != 0 {
+		t.Errorf("Imported feed was prioritized: %d", jobs[0].Priority)
+	}
+
+	var outUser db.User
+	if err := app.DB.Users.FindByID(user.ID).One(&outUser); err != nil {
+		t.Fatal(err)
+	}
+	expectedIDs := []db.ID{subscribedFeed.ID, existingFeed.ID, feed.ID}
+	if !reflect.DeepEqual(outUser.FeedIDs, expectedIDs) {
+		t.Errorf("FeedIDs mismatch: %v != %v", outUser.FeedIDs, expectedIDs)
 	}
 }
 
-func TestCreateFeedItem(t *testing.T) {
+func TestImportUserOPML_Invalid(t *testing.T) {
 	app := newTestApp()
-	feedID := createFeed(t, app, &db.Feed{URL: "http://google.com"}).ID
-	item := db.Item{GUID: "http://google.com/items/1"}
+	user := createUser(t, app, "chris", "hithere")
 
-	req := newRequest("POST", fmt.Sprintf("/api/feeds/%s/items", feedID.Hex()), &item)
-	var out db.Item
+	req, _ := http.NewRequest("POST", fmt.Sprintf("/api/users/%s/opml", user.ID.Hex()), strings.NewReader("<rss></rss>"))
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
+		User:         user,
 		Request:      req,
-		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
+		ExpectedCode: http.StatusBadRequest,
 	})
+}
 
-	if out.GUID != item.GUID {
-		t.Errorf("GUID mismatch: %s != %s", out.GUID, item.GUID)
+func TestExportUserOPML(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	feed1 := createFeed(t, app, &db.Feed{URL: "http://google.com/feed", Title: "Google", Link: "http://google.com"})
+	feed2 := createFeed(t, app, &db.Feed{URL: "http://yahoo.com/feed"})
+	createFeed(t, app, &db.Feed{URL: "http://bing.com/feed"})
+
+	user.FeedIDs = []db.ID{feed2.ID, feed1.ID}
+	if err := app.DB.Users.Update(user); err != nil {
+		t.Fatal("Could not update user:", err)
 	}
 
-	var items []db.Item
-	if err := app.DB.Items.ItemsWithFeedID(feedID).All(&items); err != nil {
-		t.Fatalf("ItemsWithFeedID failed: %s", err)
+	req := newRequest("GET", fmt.Sprintf("/api/users/%s/opml", user.ID.Hex()), nil)
+	req.Header.Set("Authorization", "Bearer "+createSession(t, app, user))
+	w := httptest.NewRecorder()
+	app.g.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code: %d != %d", w.Code, http.StatusOK)
 	}
 
-	if len(items) != 1 {
-		t.Errorf("items len mismatch: %d != %d", len(items), 1)
-	} else if items[0].ID != out.ID {
-		t.Errorf("id mismatch: %s != %s", items[0].ID, out.ID)
+	doc, err := opml.Parse(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []opml.Outline{
+		{Text: "http://yahoo.com/feed", Type: "rss", XMLURL: "http://yahoo.com/feed"},
+		{Text: "Google", Title: "Google", Type: "rss", XMLURL: "http://google.com/feed", HTMLURL: "http://google.com"},
+	}
+	if !reflect.DeepEqual(doc.Feeds(), expected) {
+		t.Errorf("Outlines mismatch: %#v != %#v", doc.Feeds(), expected)
 	}
 }
 
-func TestGetFeedItem(t *testing.T) {
+func TestCreateSubscription(t *testing.T) {
 	app := newTestApp()
-	feed := createFeed(t, app, &db.Feed{
-		URL: "http://google.com",
-	})
-	item := createItem(t, app, &db.Item{
-		GUID:   "http://google.com/item",
-		FeedID: feed.ID,
+	// The test server listens on a loopback address
+	app.Fetch.Client = http.DefaultClient
+	user := createUser(t, app, "chris", "hithere")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html><head><link rel="alternate" type="application/rss+xml" href="/feed.xml"></head></html>`)
+		case "/feed.xml":
+			w.Header().Set("Content-Type", "application/rss+xml")
+			fmt.Fprint(w, `<rss><channel></channel></rss>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	feedURL := srv.URL + "/feed.xml"
+
+	endpoint := fmt.Sprintf("/api/users/%s/subscriptions", user.ID.Hex())
+
+	var feed db.Feed
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("POST", endpoint, map[string]string{"url": srv.URL + "/#home"}),
+		ExpectedCode: http.StatusCreated,
+		ResponseBody: &feed,
 	})
+	if feed.URL != feedURL {
+		t.Errorf("URL mismatch: %s != %s", feed.URL, feedURL)
+	}
 
-	url := fmt.Sprintf("/api/feeds/%s/items/%s", feed.ID.Hex(), item.ID.Hex())
-	req := newRequest("GET", url, item)
-	var out db.Item
+	// Subscribing again by the feed's own URL finds the same feed
+	var again db.Feed
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      req,
+		User:         user,
+		Request:      newRequest("POST", endpoint, map[string]string{"url": feedURL}),
 		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
+		ResponseBody: &again,
 	})
+	if again.ID != feed.ID {
+		t.Errorf("ID mismatch: %s != %s", again.ID, feed.ID)
+	}
 
-	if out.GUID != item.GUID {
-		t.Errorf("GUID mismatch: %s != %s", out.GUID, item.GUID)
+	var outUser db.User
+	if err := app.DB.Users.FindByID(user.ID).One(&outUser); err != nil {
+		t.Fatal(err)
+	}
+	if len(outUser.FeedIDs) != 1 || outUser.FeedIDs[0] != feed.ID {
+		t.Errorf("Unexpected feed ids: %v", outUser.FeedIDs)
+	}
+
+	var jobs []db.Job
+	if err := app.DB.Jobs.Find(&db.Query{Filter: db.M{"queue": "update-feed"}}).All(&jobs); err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].Priority == 0 {
+		t.Errorf("Expected one high priority job: %v", jobs)
+	}
+
+	cases := []struct {
+		URL          string
+		ExpectedCode int
+	}{
+		{"ftp://example.com/feed", http.StatusBadRequest},
+		{"not a url", http.StatusBadRequest},
+		{srv.URL + "/missing", http.StatusBadGateway},
+	}
+	for _, c := range cases {
+		testEndpoint(t, endpointTestInfo{
+			App:          app,
+			User:         user,
+			Request:      newRequest("POST", endpoint, map[string]string{"url": c.URL}),
+			ExpectedCode: c.ExpectedCode,
+		})
 	}
 }
 
-func TestPutFeedItem(t *testing.T) {
+func TestCreateSubscription_NoFeed(t *testing.T) {
 	app := newTestApp()
-	feed := createFeed(t, app, &db.Feed{
-		URL: "http://google.com",
-	})
-	item := createItem(t, app, &db.Item{
-		GUID:   "http://google.com/item",
-		FeedID: feed.ID,
-	})
+	// The test server listens on a loopback address
+	app.Fetch.Client = http.DefaultClient
+	user := createUser(t, app, "chris", "hithere")
 
-	item.URL = "http://google.com/item.mp3"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><head><title>No feeds</title></head></html>`)
+	}))
+	defer srv.Close()
 
-	url := fmt.Sprintf("/api/feeds/%s/items/%s", feed.ID.Hex(), item.ID.Hex())
-	req := newRequest("PUT", url, item)
-	var out db.Item
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      req,
-		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
+		User:         user,
+		Request:      newRequest("POST", fmt.Sprintf("/api/users/%s/subscriptions", user.ID.Hex()), map[string]string{"url": srv.URL}),
+		ExpectedCode: http.StatusUnprocessableEntity,
 	})
-
-	if out.URL != item.URL {
-		t.Errorf("URL mismatch: %s != %s", out.URL, item.URL)
-	}
 }
 
-func TestGetJob(t *testing.T) {
+func TestCreateSubscription_PrivateAddress(t *testing.T) {
 	app := newTestApp()
-	job := createJob(t, app, db.Job{
-		KodaID: 1,
-	})
+	user := createUser(t, app, "chris", "hithere")
+
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer srv.Close()
 
-	var out db.Job
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("GET", fmt.Sprintf("/api/jobs/%s", job.ID.Hex()), nil),
-		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
+		User:         user,
+		Request:      newRequest("POST", fmt.Sprintf("/api/users/%s/subscriptions", user.ID.Hex()), map[string]string{"url": srv.URL}),
+		ExpectedCode: http.StatusBadGateway,
 	})
 
-	if out.ID != job.ID {
-		t.Errorf("id mismatch %s != %s", out.ID, job.ID)
+	if requests != 0 {
+		t.Errorf("Server received %d requests", requests)
 	}
 }
 
-func TestCreateJob(t *testing.T) {
+func TestDeleteSubscription(t *testing.T) {
 	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	feed1 := createFeed(t, app, &db.Feed{URL: "http://google.com"})
+	feed2 := createFeed(t, app, &db.Feed{URL: "http://yahoo.com"})
 
-	in := db.Job{
-		Queue:    "queue",
-		Priority: 100,
-		Payload:  map[string]string{"data": "stuff"},
+	user.FeedIDs = []db.ID{feed1.ID, feed2.ID}
+	if err := app.DB.Users.Update(user); err != nil {
+		t.Fatal("Could not update user:", err)
 	}
 
-	var out db.Job
+	endpoint := fmt.Sprintf("/api/users/%s/subscriptions/%s", user.ID.Hex(), feed1.ID.Hex())
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("POST", "/api/jobs", &in),
+		User:         user,
+		Request:      newRequest("DELETE", endpoint, nil),
 		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
+	})
+	testEndpoint(t, endpointTestInfo{
+		App:          app,
+		User:         user,
+		Request:      newRequest("DELETE", endpoint, nil),
+		ExpectedCode: http.StatusNotFound,
 	})
 
-	if out.ID == emptyID {
-		t.Error("id is invalid")
+	var outUser db.User
+	if err := app.DB.Users.FindByID(user.ID).One(&outUser); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(outUser.FeedIDs, []db.ID{feed2.ID}) {
+		t.Errorf("Unexpected feed ids: %v", outUser.FeedIDs)
 	}
+}
 
-	if out.KodaID == 0 {
-		t.Error("KodaID was not set")
+func TestPreviewFeed(t *testing.T) {
+	app := newTestApp()
+	// The test server listens on a loopback address
+	app.Fetch.Client = http.DefaultClient
+	user := createUser(t, app, "chris", "hithere")
+
+	var fetches int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/feed.xml":
+			fetches++
+			w.Header().Set("Content-Type", "application/rss+xml")
+			fmt.Fprint(w, `<rss><channel>
+<title>A Show</title>
+<description>About the show</description>
+<image><url>http://example.com/art.png</url></image>
+<item><guid>1</guid><title>First</title><pubDate>Mon, 11 Apr 2016 01:15:00 GMT</pubDate></item>
+<item><guid>2</guid><title>Second</title><pubDate>Mon, 18 Apr 2016 01:15:00 GMT</pubDate></item>
+</channel></rss>`)
+		case "/page":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, `<html></html>`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	feedURL := srv.URL + "/feed.xml"
+
+	for i := 0; i < 2; i++ {
+		var out endpoint.FeedPreview
+		testEndpoint(t, endpointTestInfo{
+			App:          app,
+			User:         user,
+			Request:      newRequest("GET", "/api/feeds/preview?url="+url.QueryEscape(feedURL), nil),
+			ExpectedCode: http.StatusOK,
+			ResponseBody: &out,
+		})
+
+		if out.Feed.URL != feedURL || out.Feed.Title != "A Show" || out.Feed.Description != "About the show" || out.Feed.ImageURL != "http://example.com/art.png" {
+			t.Errorf("Unexpected feed: %#v", out.Feed)
+		}
+		if len(out.Items) != 2 || out.Items[0].GUID != "2" || out.Items[1].GUID != "1" {
+			t.Errorf("Expected the latest item first: %#v", out.Items)
+		}
+	}
+
+	if fetches != 1 {
+		t.Errorf("Expected the preview to be cached, fetched %d times", fetches)
+	}
+
+	var feeds []db.Feed
+	if err := app.DB.Feeds.Find(nil).All(&feeds); err != nil {
+		t.Fatal(err)
+	}
+	if len(feeds) != 0 {
+		t.Errorf("Expected no feeds to be created: %v", feeds)
+	}
+
+	cases := []struct {
+		URL          string
+		ExpectedCode int
+	}{
+		{"ftp://example.com/feed", http.StatusBadRequest},
+		{srv.URL + "/missing", http.StatusBadGateway},
+		{srv.URL + "/page", http.StatusUnprocessableEntity},
+	}
+	for _, c := range cases {
+		testEndpoint(t, endpointTestInfo{
+			App:          app,
+			User:         user,
+			Request:      newRequest("GET", "/api/feeds/preview?url="+url.QueryEscape(c.URL), nil),
+			ExpectedCode: c.ExpectedCode,
+		})
 	}
 }
 
-func TestGetJobs(t *testing.T) {
+func TestPreviewFeed_PrivateAddress(t *testing.T) {
 	app := newTestApp()
-	job := createJob(t, app, db.Job{
-		KodaID: 1,
-	})
+	user := createUser(t, app, "chris", "hithere")
+
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer srv.Close()
 
-	var out []db.Job
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("GET", "/api/jobs", nil),
-		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
+		User:         user,
+		Request:      newRequest("GET", "/api/feeds/preview?url="+url.QueryEscape(srv.URL+"/feed.xml"), nil),
+		ExpectedCode: http.StatusBadGateway,
 	})
 
-	if len(out) != 1 {
-		t.Fatalf("output len mismatch: %d != 1", len(out))
-		return
-	}
-
-	if out[0].ID != job.ID {
-		t.Error("unexpected job id")
+	if requests != 0 {
+		t.Errorf("Server received %d requests", requests)
 	}
 }
 
-func TestGetJobs_Filter(t *testing.T) {
+func TestFindFeedItems(t *testing.T) {
 	app := newTestApp()
-	createJob(t, app, db.Job{
-		KodaID: 1,
-		Queue:  "some-queue",
-		State:  "done",
-	})
+	user := createUser(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{URL: "http://google.com"})
 
-	var out []db.Job
+	createItem(t, app, &db.Item{GUID: "a", FeedID: feed.ID})
+	createItem(t, app, &db.Item{GUID: "b", MediaURLKey: "google.com/b.mp3", FeedID: feed.ID})
+	createItem(t, app, &db.Item{GUID: "c", FeedID: feed.ID})
+
+	url := fmt.Sprintf("/api/feeds/%s/items/find", feed.ID.Hex())
+
+	var items []db.Item
 	testEndpoint(t, endpointTestInfo{
-		App:          app,
-		Request:      newRequest("GET", "/api/jobs?queue=some-queue", nil),
+		App:  app,
+		User: user,
+		Request: newRequest("POST", url, gin.H{
+			"guids":          []string{"a"},
+			"media_url_keys": []string{"google.com/b.mp3"},
+		}),
 		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
+		ResponseBody: &items,
 	})
 
-	if len(out) != 1 {
-		t.Error("job not found")
+	if len(items) != 2 {
+		t.Errorf("items len mismatch: %d != 2", len(items))
 	}
 
-	out = make([]db.Job, 0)
+	// More keys than can be looked up at once
+	guids := make([]string, 1001)
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("GET", "/api/jobs?queue=fake-queue", nil),
-		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
+		User:         user,
+		Request:      newRequest("POST", url, gin.H{"guids": guids}),
+		ExpectedCode: http.StatusBadRequest,
 	})
+}
 
-	if len(out) != 0 {
-		t.Errorf("job count mismatch: %d != 0", len(out))
-	}
+func TestMarkRemovedFeedItems(t *testing.T) {
+	app := newTestApp()
+	user := createUser(t, app, "chris", "hithere")
+	feed := createFeed(t, app, &db.Feed{URL: "http://google.com"})
+
+	kept := createItem(t, app, &db.Item{GUID: "a", FeedID: feed.ID})
+	removed := createItem(t, app, &db.Item{GUID: "b", FeedID: feed.ID})
+
+	url := fmt.Sprintf("/api/feeds/%s/items/mark_removed", feed.ID.Hex())
+	body := gin.H{"keep_ids": []db.ID{kept.ID}}
 
-	out = make([]db.Job, 0)
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("GET", "/api/jobs?state=done", nil),
-		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
+		User:         user,
+		Request:      newRequest("POST", url, body),
+		ExpectedCode: http.StatusForbidden,
 	})
 
-	if len(out) != 1 {
-		t.Error("job not found")
-	}
+	req := newRequest("POST", url, body)
+	req.Header.Set("Authorization", "Bearer "+createAPIKey(t, app, db.ScopeItems))
 
-	out = make([]db.Job, 0)
+	var resp struct {
+		Removed int `json:"removed"`
+	}
 	testEndpoint(t, endpointTestInfo{
 		App:          app,
-		Request:      newRequest("GET", "/api/jobs?queue=working", nil),
+		Request:      req,
 		ExpectedCode: http.StatusOK,
-		ResponseBody: &out,
+		ResponseBody: &resp,
 	})
 
-	if len(out) != 0 {
-		t.Errorf("job count mismatch: %d != 0", len(out))
+	if resp.Removed != 1 {
+		t.Errorf("removed mismatch: %d != 1", resp.Removed)
+	}
+
+	for _, item := range []*db.Item{kept, removed} {
+		var out db.Item
+		if err := app.DB.Items.FindByID(item.ID).One(&out); err != nil {
+			t.Fatal(err)
+		}
+		if (out.RemovedTime != nil) != (item == removed) {
+			t.Errorf("item %q: unexpected RemovedTime %v", item.GUID, out.RemovedTime)
+		}
 	}
 }
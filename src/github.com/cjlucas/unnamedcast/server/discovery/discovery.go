@@ -0,0 +1,167 @@
+// Package discovery finds the feed behind a URL given by a user, which may
+// be the feed itself or a web page that advertises it.
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// MaxPageSize is the most that is read of a web page while looking for
+// feed links.
+const MaxPageSize = 1 << 20
+
+// ErrNoFeed is returned if the URL is neither a feed nor a page that
+// links to one.
+var ErrNoFeed = errors.New("no feed found")
+
+// feedTypes are the link types that identify a feed, in order of
+// preference.
+var feedTypes = []string{
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/feed+json",
+	"application/json",
+}
+
+// Find returns the URL of the feed at rawurl. If rawurl is a feed it is
+// returned as is, otherwise the first feed advertised by the page is.
+func Find(client *http.Client, rawurl string) (string, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Get(rawurl)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+
+	body := bufio.NewReaderSize(resp.Body, 1024)
+	prefix, _ := body.Peek(1024)
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+
+	switch {
+	case looksLikeFeed(prefix):
+		return rawurl, nil
+	case isHTML(contentType, prefix):
+		links := FeedLinks(io.LimitReader(body, MaxPageSize), resp.Request.URL)
+		if len(links) == 0 {
+			return "", ErrNoFeed
+		}
+		return links[0], nil
+	case strings.HasSuffix(contentType, "xml") || strings.HasSuffix(contentType, "json"):
+		return rawurl, nil
+	default:
+		return "", ErrNoFeed
+	}
+}
+
+// looksLikeFeed reports whether a document beginning with prefix is an RSS,
+// RDF or Atom feed. Feeds are often served with the wrong content type.
+func looksLikeFeed(prefix []byte) bool {
+	for _, tag := range []string{"<rss", "<rdf:RDF", "<feed"} {
+		if bytes.Contains(prefix, []byte(tag)) {
+			return true
+		}
+	}
+	return false
+}
+
+func isHTML(contentType string, prefix []byte) bool {
+	if contentType == "text/html" || contentType == "application/xhtml+xml" {
+		return true
+	}
+
+	s := strings.ToLower(string(bytes.TrimSpace(prefix)))
+	return strings.HasPrefix(s, "<!doctype html") || strings.HasPrefix(s, "<html")
+}
+
+// FeedLinks returns the URLs of the feeds advertised by an HTML document
+// through <link rel="alternate"> elements, in order of preference.
+// Relative URLs are resolved against base, or the document's <base>.
+func FeedLinks(r io.Reader, base *url.URL) []string {
+	found := make(map[string][]string)
+	z := html.NewTokenizer(r)
+
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			continue
+		}
+
+		name, hasAttr := z.TagName()
+		if !hasAttr {
+			continue
+		}
+
+		attrs := make(map[string]string)
+		for more := true; more; {
+			var k, v []byte
+			k, v, more = z.TagAttr()
+			attrs[string(k)] = string(v)
+		}
+
+		switch atom.Lookup(name) {
+		case atom.Base:
+			if u, err := resolve(base, attrs["href"]); err == nil {
+				base = u
+			}
+		case atom.Link:
+			if !hasRel(attrs["rel"], "alternate") {
+				continue
+			}
+
+			typ, _, _ := mime.ParseMediaType(attrs["type"])
+			if u, err := resolve(base, attrs["href"]); err == nil && attrs["href"] != "" {
+				found[typ] = append(found[typ], u.String())
+			}
+		}
+	}
+
+	var links []string
+	for _, typ := range feedTypes {
+		links = append(links, found[typ]...)
+	}
+	return links
+}
+
+func hasRel(rels, rel string) bool {
+	for _, r := range strings.Fields(rels) {
+		if strings.EqualFold(r, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+func resolve(base *url.URL, href string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return nil, err
+	}
+	if base != nil {
+		u = base.ResolveReference(u)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("not an http or https url")
+	}
+	return u, nil
+}
@@ -0,0 +1,97 @@
+package discovery_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cjlucas/unnamedcast/server/discovery"
+)
+
+func TestFeedLinks(t *testing.T) {
+	doc := `<!DOCTYPE html>
+<html>
+<head>
+  <link rel="stylesheet" href="/style.css">
+  <link rel="alternate" type="application/atom+xml" href="/atom.xml">
+  <link rel="alternate" type="application/rss+xml; charset=utf-8" href="feed.xml">
+  <link rel="alternate" type="text/html" href="/other">
+  <link rel="alternate" type="application/rss+xml" href="javascript:alert(1)">
+  <link rel="ALTERNATE home" type="application/rss+xml" href="https://feeds.example.com/show">
+</head>
+<body></body>
+</html>`
+
+	base, _ := url.Parse("http://example.com/podcast/")
+	out := discovery.FeedLinks(strings.NewReader(doc), base)
+
+	expected := []string{
+		"http://example.com/podcast/feed.xml",
+		"https://feeds.example.com/show",
+		"http://example.com/atom.xml",
+	}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("FeedLinks = %v, expected %v", out, expected)
+	}
+}
+
+func TestFeedLinks_Base(t *testing.T) {
+	doc := `<html><head><base href="http://cdn.example.com/site/">
+<link rel="alternate" type="application/rss+xml" href="feed.xml"></head></html>`
+
+	base, _ := url.Parse("http://example.com/")
+	out := discovery.FeedLinks(strings.NewReader(doc), base)
+
+	expected := []string{"http://cdn.example.com/site/feed.xml"}
+	if !reflect.DeepEqual(out, expected) {
+		t.Errorf("FeedLinks = %v, expected %v", out, expected)
+	}
+}
+
+func TestFind(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed", func(w http.ResponseWriter, r *http.Request) {
+		// Served with the wrong content type
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<?xml version="1.0"?><rss version="2.0"><channel></channel></rss>`))
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(`<html><head><link rel="alternate" type="application/rss+xml" href="/feed"></head></html>`))
+	})
+	mux.HandleFunc("/nofeed", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><head><title>Nothing here</title></head></html>`))
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("\x89PNG"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cases := []struct {
+		Path     string
+		Expected string
+		Err      error
+	}{
+		{"/feed", srv.URL + "/feed", nil},
+		{"/page", srv.URL + "/feed", nil},
+		{"/nofeed", "", discovery.ErrNoFeed},
+		{"/image", "", discovery.ErrNoFeed},
+	}
+
+	for _, c := range cases {
+		out, err := discovery.Find(nil, srv.URL+c.Path)
+		if out != c.Expected || err != c.Err {
+			t.Errorf("Find(%s) = (%q, %v), expected (%q, %v)", c.Path, out, err, c.Expected, c.Err)
+		}
+	}
+
+	if _, err := discovery.Find(nil, srv.URL+"/missing"); err == nil {
+		t.Error("Expected an error for a missing page")
+	}
+}
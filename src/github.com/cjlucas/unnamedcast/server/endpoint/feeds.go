@@ -2,6 +2,7 @@ package endpoint
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
@@ -17,17 +18,27 @@ type Interface interface {
 
 type GetFeeds struct {
 	DB     *db.DB
+	APIKey *db.APIKey
+	Now    func() time.Time
 	Query  db.Query
 	Params struct {
 		sortParams
 		limitParams
-		ITunesID int    `param:"itunes_id"`
-		URL      string `param:"url"`
+		ITunesID   int    `param:"itunes_id"`
+		URL        string `param:"url"`
+		Subscribed bool   `param:"subscribed"`
+
+		// Due feeds are subscribed feeds that are due to be updated
+		Due bool `param:"due"`
+
+		// Health is one of healthy, failing or disabled
+		Health string `param:"health"`
 	}
 }
 
 func (e *GetFeeds) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
+		middleware.RequireScope(e.APIKey, db.ScopeFeeds),
 		middleware.AddQuerySortInfo(e.DB.Feeds.ModelInfo, &e.Query, &e.Params, "modification_time"),
 		middleware.AddQueryLimitInfo(&e.Query, &e.Params),
 	}
@@ -35,12 +46,43 @@ func (e *GetFeeds) Bind() []gin.HandlerFunc {
 
 func (e *GetFeeds) Handle(c *gin.Context) {
 	if e.Params.URL != "" {
-		e.Query.Filter = db.M{"url": e.Params.URL}
+		e.Query.Filter = e.DB.Feeds.URLFilter(e.Params.URL)
 	} else if e.Params.ITunesID != 0 {
 		e.Query.Filter = db.M{"itunes_id": e.Params.ITunesID}
 	}
 
-	if e.Query.Filter == nil {
+	var filters []db.M
+	if e.Params.Subscribed || e.Params.Due {
+		// Only feeds with at least one subscriber
+		var ids []db.ID
+		if err := e.DB.Users.Find(nil).Distinct("feed_ids", &ids); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		filters = append(filters, db.M{"_id": db.M{"$in": ids}})
+	}
+
+	if e.Params.Due {
+		filters = append(filters, e.DB.Feeds.DueFilter(e.Now()))
+	}
+
+	if e.Params.Health != "" {
+		filter, err := e.DB.Feeds.HealthFilter(e.Params.Health)
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+		filters = append(filters, filter)
+	}
+
+	list := len(filters) > 0
+	if len(filters) == 1 {
+		e.Query.Filter = filters[0]
+	} else if list {
+		e.Query.Filter = db.M{"$and": filters}
+	}
+
+	if e.Query.Filter == nil || list {
 		var feeds []db.Feed
 		if err := e.DB.Feeds.Find(&e.Query).All(&feeds); err != nil {
 			c.AbortWithError(http.StatusInternalServerError, err)
@@ -65,12 +107,16 @@ func (e *GetFeeds) Handle(c *gin.Context) {
 }
 
 type CreateFeed struct {
-	DB   *db.DB
-	Feed db.Feed
+	DB          *db.DB
+	APIKey      *db.APIKey
+	CurrentUser *db.User
+	Feed        db.Feed
 }
 
 func (e *CreateFeed) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
+		middleware.RequireScope(e.APIKey, db.ScopeFeeds),
+		middleware.UnlessAPIKey(e.APIKey, middleware.RequireRole(e.CurrentUser, db.RoleAdmin)),
 		middleware.UnmarshalBody(&e.Feed),
 	}
 }
@@ -94,33 +140,51 @@ func (e *CreateFeed) Handle(c *gin.Context) {
 	c.JSON(http.StatusOK, out)
 }
 
+// GetFeed returns the feed with the given ID. The IDs of feeds that were
+// merged into another resolve to the feed they were merged into.
 type GetFeed struct {
-	DB   *db.DB
-	Feed db.Feed
+	DB     *db.DB
+	APIKey *db.APIKey
 }
 
 func (e *GetFeed) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
-		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
-			Collection: e.DB.Feeds,
-			BoundName:  "id",
-			Result:     &e.Feed,
-		}),
+		middleware.RequireScope(e.APIKey, db.ScopeFeeds),
 	}
 }
 
 func (e *GetFeed) Handle(c *gin.Context) {
-	c.JSON(http.StatusOK, &e.Feed)
+	id, err := db.IDFromString(c.Param("id"))
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	feed, err := e.DB.Feeds.ResolveFeed(id)
+	switch {
+	case err == db.ErrNotFound:
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	case err != nil:
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, feed)
 }
 
 type UpdateFeed struct {
 	DB           *db.DB
+	APIKey       *db.APIKey
+	CurrentUser  *db.User
 	Feed         db.Feed
 	ExistingFeed db.Feed
 }
 
 func (e *UpdateFeed) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
+		middleware.RequireScope(e.APIKey, db.ScopeFeeds),
+		middleware.UnlessAPIKey(e.APIKey, middleware.RequireRole(e.CurrentUser, db.RoleAdmin)),
 		middleware.UnmarshalBody(&e.Feed),
 		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
 			Collection: e.DB.Feeds,
@@ -141,27 +205,87 @@ func (e *UpdateFeed) Handle(c *gin.Context) {
 	c.JSON(http.StatusOK, &e.Feed)
 }
 
+// maxFeedLease is the longest a feed may be held back by LeaseDueFeed.
+const maxFeedLease = 24 * time.Hour
+
+// LeaseDueFeed holds back a feed that is due to be updated until the given
+// time, so that it is not enqueued again while its update is pending. It
+// responds with 409 if the feed is no longer due.
+type LeaseDueFeed struct {
+	DB          *db.DB
+	APIKey      *db.APIKey
+	CurrentUser *db.User
+	Now         func() time.Time
+	FeedID      db.ID
+	Body        struct {
+		Until time.Time `json:"until"`
+	}
+}
+
+func (e *LeaseDueFeed) Bind() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.RequireScope(e.APIKey, db.ScopeFeeds),
+		middleware.UnlessAPIKey(e.APIKey, middleware.RequireRole(e.CurrentUser, db.RoleAdmin)),
+		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
+			Collection: e.DB.Feeds,
+			BoundName:  "id",
+			ID:         &e.FeedID,
+		}),
+		middleware.UnmarshalBody(&e.Body),
+	}
+}
+
+func (e *LeaseDueFeed) Handle(c *gin.Context) {
+	if e.Body.Until.IsZero() {
+		c.JSON(http.StatusBadRequest, gin.H{"reason": "until is required"})
+		c.Abort()
+		return
+	}
+
+	now := e.Now()
+	if e.Body.Until.Sub(now) > maxFeedLease {
+		c.JSON(http.StatusBadRequest, gin.H{"reason": "until is too far in the future"})
+		c.Abort()
+		return
+	}
+
+	leased, err := e.DB.Feeds.LeaseDue(e.FeedID, now, e.Body.Until)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if !leased {
+		c.JSON(http.StatusConflict, gin.H{"reason": "feed is not due"})
+		c.Abort()
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// GetFeedItems returns the items of the feed with the given ID, which may
+// be the ID of a feed that was merged into another.
 type GetFeedItems struct {
 	DB     *db.DB
+	APIKey *db.APIKey
 	FeedID db.ID
 	Query  db.Query
 
 	Params struct {
 		sortParams
 		limitParams
-		ModifiedSince time.Time `param:"modified_since"`
+		ModifiedSince  time.Time `param:"modified_since"`
+		IncludeRemoved bool      `param:"include_removed"`
 	}
 }
 
 func (e *GetFeedItems) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
-		middleware.AddQuerySortInfo(e.DB.Items.ModelInfo, &e.Query, &e.Params, "modification_time"),
+		middleware.RequireScope(e.APIKey, db.ScopeItems),
+		middleware.AddQuerySortInfo(e.DB.Items.ModelInfo, &e.Query, &e.Params,
+			"modification_time", "season", "episode", "episode_type", "explicit"),
 		middleware.AddQueryLimitInfo(&e.Query, &e.Params),
-		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
-			Collection: e.DB.Feeds,
-			BoundName:  "id",
-			ID:         &e.FeedID,
-		}),
+		middleware.ResolveFeed(e.DB.Feeds, "id", &e.FeedID),
 	}
 }
 
@@ -170,8 +294,13 @@ func (e *GetFeedItems) Handle(c *gin.Context) {
 		"feed_id": e.FeedID,
 	}
 
+	// Removed items are included when syncing so clients learn of the removal
 	if !e.Params.ModifiedSince.IsZero() {
 		e.Query.Filter["modification_time"] = db.M{"$gt": e.Params.ModifiedSince}
+	} else if !e.Params.IncludeRemoved {
+		for k, v := range e.DB.Items.NotRemovedFilter() {
+			e.Query.Filter[k] = v
+		}
 	}
 
 	var items []db.Item
@@ -183,13 +312,101 @@ func (e *GetFeedItems) Handle(c *gin.Context) {
 	c.JSON(http.StatusOK, &items)
 }
 
-type GetFeedUsers struct {
+// maxFindItemKeys bounds the number of keys looked up by FindFeedItems.
+const maxFindItemKeys = 1000
+
+// FindFeedItems returns the items of a feed, including removed items, that
+// have any of the given GUIDs, media URL keys or content keys. The worker
+// uses it to match the items it decodes to those already stored.
+type FindFeedItems struct {
 	DB     *db.DB
+	APIKey *db.APIKey
 	FeedID db.ID
+	Body   struct {
+		GUIDs        []string `json:"guids"`
+		MediaURLKeys []string `json:"media_url_keys"`
+		ContentKeys  []string `json:"content_keys"`
+	}
+}
+
+func (e *FindFeedItems) Bind() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.RequireScope(e.APIKey, db.ScopeItems),
+		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
+			Collection: e.DB.Feeds,
+			BoundName:  "id",
+			ID:         &e.FeedID,
+		}),
+		middleware.UnmarshalBody(&e.Body),
+	}
+}
+
+func (e *FindFeedItems) Handle(c *gin.Context) {
+	if len(e.Body.GUIDs)+len(e.Body.MediaURLKeys)+len(e.Body.ContentKeys) > maxFindItemKeys {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"reason": fmt.Sprintf("no more than %d keys may be given", maxFindItemKeys),
+		})
+		c.Abort()
+		return
+	}
+
+	items, err := e.DB.Items.MatchingItems(e.FeedID, e.Body.GUIDs, e.Body.MediaURLKeys, e.Body.ContentKeys)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, &items)
+}
+
+// MarkRemovedFeedItems marks every item of a feed that is not listed as
+// removed from the feed. The worker calls it once a feed has been fully
+// decoded, with the items that were found in it.
+type MarkRemovedFeedItems struct {
+	DB          *db.DB
+	APIKey      *db.APIKey
+	CurrentUser *db.User
+	Now         func() time.Time
+	FeedID      db.ID
+	Body        struct {
+		KeepIDs []db.ID `json:"keep_ids"`
+	}
+}
+
+func (e *MarkRemovedFeedItems) Bind() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.RequireScope(e.APIKey, db.ScopeItems),
+		middleware.UnlessAPIKey(e.APIKey, middleware.RequireRole(e.CurrentUser, db.RoleAdmin)),
+		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
+			Collection: e.DB.Feeds,
+			BoundName:  "id",
+			ID:         &e.FeedID,
+		}),
+		middleware.UnmarshalBody(&e.Body),
+	}
+}
+
+func (e *MarkRemovedFeedItems) Handle(c *gin.Context) {
+	n, err := e.DB.Items.MarkRemoved(e.FeedID, e.Body.KeepIDs, e.Now())
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"removed": n})
+}
+
+type GetFeedUsers struct {
+	DB          *db.DB
+	APIKey      *db.APIKey
+	CurrentUser *db.User
+	FeedID      db.ID
 }
 
 func (e *GetFeedUsers) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
+		middleware.RequireScope(e.APIKey, db.ScopeFeeds),
+		middleware.UnlessAPIKey(e.APIKey, middleware.RequireRole(e.CurrentUser, db.RoleAdmin)),
 		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
 			Collection: e.DB.Feeds,
 			BoundName:  "id",
@@ -217,13 +434,17 @@ func (e *GetFeedUsers) Handle(c *gin.Context) {
 }
 
 type CreateFeedItem struct {
-	DB     *db.DB
-	Item   db.Item
-	FeedID db.ID
+	DB          *db.DB
+	APIKey      *db.APIKey
+	CurrentUser *db.User
+	Item        db.Item
+	FeedID      db.ID
 }
 
 func (e *CreateFeedItem) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
+		middleware.RequireScope(e.APIKey, db.ScopeItems),
+		middleware.UnlessAPIKey(e.APIKey, middleware.RequireRole(e.CurrentUser, db.RoleAdmin)),
 		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
 			Collection: e.DB.Feeds,
 			BoundName:  "id",
@@ -252,12 +473,14 @@ func (e *CreateFeedItem) Handle(c *gin.Context) {
 
 type GetFeedItem struct {
 	DB     *db.DB
+	APIKey *db.APIKey
 	FeedID db.ID
 	ItemID db.ID
 }
 
 func (e *GetFeedItem) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
+		middleware.RequireScope(e.APIKey, db.ScopeItems),
 		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
 			Collection: e.DB.Feeds,
 			BoundName:  "id",
@@ -292,14 +515,18 @@ func (e *GetFeedItem) Handle(c *gin.Context) {
 }
 
 type UpdateFeedItem struct {
-	DB     *db.DB
-	FeedID db.ID
-	ItemID db.ID
-	Item   db.Item
+	DB          *db.DB
+	APIKey      *db.APIKey
+	CurrentUser *db.User
+	FeedID      db.ID
+	ItemID      db.ID
+	Item        db.Item
 }
 
 func (e *UpdateFeedItem) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
+		middleware.RequireScope(e.APIKey, db.ScopeItems),
+		middleware.UnlessAPIKey(e.APIKey, middleware.RequireRole(e.CurrentUser, db.RoleAdmin)),
 		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
 			Collection: e.DB.Feeds,
 			BoundName:  "id",
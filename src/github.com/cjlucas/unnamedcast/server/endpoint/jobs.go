@@ -12,9 +12,10 @@ import (
 )
 
 type GetJobs struct {
-	DB     *db.DB
-	Query  db.Query
-	Params struct {
+	DB          *db.DB
+	CurrentUser *db.User
+	Query       db.Query
+	Params      struct {
 		sortParams
 		limitParams
 		Queue string `param:"queue"`
@@ -24,6 +25,7 @@ type GetJobs struct {
 
 func (e *GetJobs) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
+		middleware.RequireRole(e.CurrentUser, db.RoleAdmin),
 		middleware.AddQuerySortInfo(e.DB.Jobs.ModelInfo, &e.Query, &e.Params, "modification_time"),
 		middleware.AddQueryLimitInfo(&e.Query, &e.Params),
 	}
@@ -48,12 +50,14 @@ func (e *GetJobs) Handle(c *gin.Context) {
 }
 
 type GetJob struct {
-	DB  *db.DB
-	Job db.Job
+	DB          *db.DB
+	CurrentUser *db.User
+	Job         db.Job
 }
 
 func (e *GetJob) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
+		middleware.RequireRole(e.CurrentUser, db.RoleAdmin),
 		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
 			Collection: e.DB.Jobs,
 			BoundName:  "id",
@@ -67,13 +71,17 @@ func (e *GetJob) Handle(c *gin.Context) {
 }
 
 type CreateJob struct {
-	DB   *db.DB
-	Koda *koda.Client
-	Job  db.Job
+	DB          *db.DB
+	APIKey      *db.APIKey
+	CurrentUser *db.User
+	Koda        *koda.Client
+	Job         db.Job
 }
 
 func (e *CreateJob) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
+		middleware.RequireScope(e.APIKey, db.ScopeJobs),
+		middleware.UnlessAPIKey(e.APIKey, middleware.RequireRole(e.CurrentUser, db.RoleAdmin)),
 		middleware.UnmarshalBody(&e.Job),
 	}
 }
@@ -0,0 +1,98 @@
+package endpoint
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/cjlucas/unnamedcast/db"
+	"github.com/cjlucas/unnamedcast/server/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+type GetAPIKeys struct {
+	DB          *db.DB
+	CurrentUser *db.User
+}
+
+func (e *GetAPIKeys) Bind() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.RequireRole(e.CurrentUser, db.RoleAdmin),
+	}
+}
+
+func (e *GetAPIKeys) Handle(c *gin.Context) {
+	var keys []db.APIKey
+	if err := e.DB.APIKeys.Find(nil).All(&keys); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if keys == nil {
+		keys = make([]db.APIKey, 0)
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+type CreateAPIKey struct {
+	DB          *db.DB
+	CurrentUser *db.User
+	Body        struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+}
+
+func (e *CreateAPIKey) Bind() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.RequireRole(e.CurrentUser, db.RoleAdmin),
+		middleware.UnmarshalBody(&e.Body),
+	}
+}
+
+func (e *CreateAPIKey) Handle(c *gin.Context) {
+	for _, s := range e.Body.Scopes {
+		if !db.ValidScope(s) {
+			c.AbortWithError(http.StatusBadRequest, fmt.Errorf("unknown scope: %s", s))
+			return
+		}
+	}
+
+	apiKey, key, err := e.DB.APIKeys.Create(e.Body.Name, e.Body.Scopes)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// The key itself is never retrievable again
+	c.JSON(http.StatusOK, gin.H{
+		"key":     key,
+		"api_key": apiKey,
+	})
+}
+
+type DeleteAPIKey struct {
+	DB          *db.DB
+	CurrentUser *db.User
+	KeyID       db.ID
+}
+
+func (e *DeleteAPIKey) Bind() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.RequireRole(e.CurrentUser, db.RoleAdmin),
+		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
+			Collection: e.DB.APIKeys,
+			BoundName:  "id",
+			ID:         &e.KeyID,
+		}),
+	}
+}
+
+func (e *DeleteAPIKey) Handle(c *gin.Context) {
+	if err := e.DB.APIKeys.Revoke(e.KeyID); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
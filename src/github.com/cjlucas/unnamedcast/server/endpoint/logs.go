@@ -18,13 +18,15 @@ type getLogsParams struct {
 }
 
 type GetLogs struct {
-	DB     *db.DB
-	Query  db.Query
-	Params getLogsParams
+	DB          *db.DB
+	CurrentUser *db.User
+	Query       db.Query
+	Params      getLogsParams
 }
 
 func (e *GetLogs) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
+		middleware.RequireRole(e.CurrentUser, db.RoleAdmin),
 		middleware.AddQuerySortInfo(e.DB.Jobs.ModelInfo, &e.Query, &e.Params, "creation_time", "execution_time"),
 		middleware.AddQueryLimitInfo(&e.Query, &e.Params),
 		e.buildQuery,
@@ -1,11 +1,14 @@
 package endpoint
 
 import (
+	"errors"
 	"net/http"
-
-	"golang.org/x/crypto/bcrypt"
+	"net/url"
+	"time"
 
 	"github.com/cjlucas/unnamedcast/db"
+	"github.com/cjlucas/unnamedcast/server/middleware"
+	"github.com/cjlucas/unnamedcast/server/totp"
 	"github.com/gin-gonic/gin"
 )
 
@@ -29,7 +32,8 @@ func (e *SearchFeeds) Handle(c *gin.Context) {
 
 	query := db.Query{
 		Filter: db.M{
-			"$text": db.M{"$search": e.Params.Query},
+			"$text":   db.M{"$search": e.Params.Query},
+			"blocked": db.M{"$ne": true},
 		},
 		SortField: "$textScore:score",
 		SortDesc:  true,
@@ -51,6 +55,7 @@ func (e *SearchFeeds) Handle(c *gin.Context) {
 
 type Login struct {
 	DB       *db.DB
+	Cookies  CookieOptions
 	Username string `param:",require"`
 	Password string `param:",require"`
 }
@@ -71,10 +76,143 @@ func (e *Login) Handle(c *gin.Context) {
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(e.Password)); err != nil {
+	if !user.CheckPassword(e.Password) {
 		c.AbortWithStatus(http.StatusUnauthorized)
 		return
 	}
 
-	c.JSON(http.StatusOK, &user)
+	if user.TOTPEnabled {
+		// The session is not started until the one-time code is verified
+		session, challenge, err := e.DB.Sessions.CreatePending(user.ID)
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"totp_required":   true,
+			"challenge":       challenge,
+			"expiration_time": &session.ExpirationTime,
+		})
+		return
+	}
+
+	startSession(c, e.DB, e.Cookies, &user)
+}
+
+// CookieOptions holds the settings of the session cookie.
+type CookieOptions struct {
+	// Insecure allows the cookie to be sent over plain HTTP. It should only
+	// be set in development.
+	Insecure bool
+}
+
+// setSessionCookie sets the session cookie. A negative maxAge deletes it.
+func setSessionCookie(c *gin.Context, opts CookieOptions, token string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     middleware.SessionCookie,
+		Value:    url.QueryEscape(token),
+		MaxAge:   maxAge,
+		Path:     "/",
+		Secure:   !opts.Insecure,
+		HttpOnly: true,
+
+		// The cookie authenticates GET requests, which must not be made on
+		// behalf of other sites
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// startSession creates a session for user and responds with its token.
+func startSession(c *gin.Context, dbConn *db.DB, cookies CookieOptions, user *db.User) {
+	session, token, err := dbConn.Sessions.Create(user.ID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	setSessionCookie(c, cookies, token, int(db.SessionLifetime/time.Second))
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":           token,
+		"expiration_time": &session.ExpirationTime,
+		"user":            user,
+	})
+}
+
+// VerifyLogin completes a two-step login given the challenge returned by
+// Login and either a TOTP code or a recovery code.
+type VerifyLogin struct {
+	DB        *db.DB
+	Now       func() time.Time
+	Cookies   CookieOptions
+	Challenge string `param:"challenge,require"`
+	Code      string `param:"code,require"`
+}
+
+func (e *VerifyLogin) Bind() []gin.HandlerFunc {
+	return nil
+}
+
+func (e *VerifyLogin) Handle(c *gin.Context) {
+	session, err := e.DB.Sessions.PendingSessionByToken(e.Challenge)
+	switch {
+	case err == db.ErrNotFound:
+		c.AbortWithError(http.StatusUnauthorized, errors.New("invalid or expired challenge"))
+		return
+	case err != nil:
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	var user db.User
+	if err := e.DB.Users.FindByID(session.UserID).One(&user); err != nil {
+		c.AbortWithError(http.StatusUnauthorized, errors.New("session user no longer exists"))
+		return
+	}
+
+	if err := e.verifyCode(&user); err != nil {
+		if err := e.DB.Sessions.RecordFailedAttempt(session); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+		c.AbortWithError(http.StatusUnauthorized, err)
+		return
+	}
+
+	if err := e.DB.Sessions.Revoke(session.ID); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	startSession(c, e.DB, e.Cookies, &user)
+}
+
+func (e *VerifyLogin) verifyCode(user *db.User) error {
+	if step, ok := totp.Validate(user.TOTPSecret, e.Code, e.Now()); ok {
+		return e.DB.Users.UseTOTPStep(user.ID, step)
+	}
+
+	return e.DB.Users.UseRecoveryCode(user.ID, e.Code)
+}
+
+type Logout struct {
+	DB      *db.DB
+	Cookies CookieOptions
+	Session *db.Session
+}
+
+func (e *Logout) Bind() []gin.HandlerFunc {
+	return nil
+}
+
+func (e *Logout) Handle(c *gin.Context) {
+	if err := e.DB.Sessions.Revoke(e.Session.ID); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	setSessionCookie(c, e.Cookies, "", -1)
+
+	c.Status(http.StatusOK)
 }
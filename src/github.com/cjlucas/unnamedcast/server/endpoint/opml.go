@@ -0,0 +1,215 @@
+package endpoint
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/cjlucas/koda-go"
+	"github.com/cjlucas/unnamedcast/db"
+	"github.com/cjlucas/unnamedcast/server/middleware"
+	"github.com/cjlucas/unnamedcast/server/opml"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxOPMLSize     = 5 << 20
+	maxOPMLOutlines = 2000
+)
+
+// Outcomes of importing an outline
+const (
+	OPMLSubscribed        = "subscribed"
+	OPMLCreated           = "created"
+	OPMLAlreadySubscribed = "already_subscribed"
+	OPMLInvalid           = "invalid"
+	OPMLFailed            = "failed"
+)
+
+// OPMLOutlineResult reports the outcome of importing a single outline.
+type OPMLOutlineResult struct {
+	Title  string `json:"title"`
+	URL    string `json:"url"`
+	FeedID string `json:"feed_id,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ImportUserOPML subscribes the user to every feed in an OPML document,
+// creating the feeds that do not exist yet. The subscriptions are merged
+// into the user's existing ones.
+type ImportUserOPML struct {
+	DB          *db.DB
+	Koda        *koda.Client
+	CurrentUser *db.User
+	User        db.User
+}
+
+func (e *ImportUserOPML) Bind() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.RequireOwnership(&middleware.RequireOwnershipOpts{
+			User:      e.CurrentUser,
+			BoundName: "id",
+		}),
+		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
+			Collection: e.DB.Users,
+			BoundName:  "id",
+			Result:     &e.User,
+		}),
+	}
+}
+
+func (e *ImportUserOPML) Handle(c *gin.Context) {
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.LimitReader(c.Request.Body, maxOPMLSize+1)); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	if buf.Len() > maxOPMLSize {
+		c.AbortWithError(http.StatusRequestEntityTooLarge, errors.New("opml document is too large"))
+		return
+	}
+
+	doc, err := opml.Parse(&buf)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid opml: %s", err))
+		return
+	}
+
+	outlines := doc.Feeds()
+	if len(outlines) > maxOPMLOutlines {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("opml has more than %d feeds", maxOPMLOutlines))
+		return
+	}
+
+	subscribed := make(map[db.ID]bool)
+	for _, id := range e.User.FeedIDs {
+		subscribed[id] = true
+	}
+
+	results := make([]OPMLOutlineResult, 0, len(outlines))
+	for _, outline := range outlines {
+		res := OPMLOutlineResult{
+			Title: outline.Name(),
+			URL:   outline.XMLURL,
+		}
+
+		feedURL, err := parseFeedURL(outline.XMLURL)
+		if err != nil {
+			res.Status = OPMLInvalid
+			res.Error = err.Error()
+			results = append(results, res)
+			continue
+		}
+
+		feed, created, err := findOrCreateFeed(e.DB, feedURL, outline.Name())
+		if err != nil {
+			res.Status = OPMLFailed
+			res.Error = err.Error()
+			results = append(results, res)
+			continue
+		}
+		res.FeedID = feed.ID.Hex()
+
+		switch {
+		case subscribed[feed.ID]:
+			res.Status = OPMLAlreadySubscribed
+		case created:
+			res.Status = OPMLCreated
+		default:
+			res.Status = OPMLSubscribed
+		}
+
+		// Imports may create many feeds at once, so their first scrapes
+		// don't jump ahead of feeds subscribed to individually. The
+		// scheduler will get to the feed if the update can't be enqueued,
+		// so the import carries on.
+		if created {
+			if err := enqueueFeedUpdate(e.DB, e.Koda, feed.ID, defaultUpdatePriority); err != nil {
+				c.Error(err)
+			}
+		}
+
+		if !subscribed[feed.ID] {
+			subscribed[feed.ID] = true
+			if _, err := e.DB.Users.Subscribe(e.User.ID, feed.ID); err != nil {
+				res.Status = OPMLFailed
+				res.Error = err.Error()
+			}
+		}
+		results = append(results, res)
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+// ExportUserOPML returns the user's subscriptions as an OPML document.
+type ExportUserOPML struct {
+	DB          *db.DB
+	CurrentUser *db.User
+	User        db.User
+}
+
+func (e *ExportUserOPML) Bind() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.RequireOwnership(&middleware.RequireOwnershipOpts{
+			User:      e.CurrentUser,
+			BoundName: "id",
+		}),
+		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
+			Collection: e.DB.Users,
+			BoundName:  "id",
+			Result:     &e.User,
+		}),
+	}
+}
+
+func (e *ExportUserOPML) Handle(c *gin.Context) {
+	var feeds []db.Feed
+	query := db.Query{Filter: db.M{"_id": db.M{"$in": e.User.FeedIDs}}}
+	if err := e.DB.Feeds.Find(&query).All(&feeds); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	byID := make(map[db.ID]*db.Feed)
+	for i := range feeds {
+		byID[feeds[i].ID] = &feeds[i]
+	}
+
+	doc := opml.Document{
+		Version: "2.0",
+		Head:    opml.Head{Title: e.User.Username + "'s subscriptions"},
+	}
+
+	// Outlines follow the order of the user's subscriptions
+	for _, id := range e.User.FeedIDs {
+		feed, ok := byID[id]
+		if !ok {
+			continue
+		}
+
+		text := feed.Title
+		if text == "" {
+			text = feed.URL
+		}
+		doc.Body.Outlines = append(doc.Body.Outlines, opml.Outline{
+			Text:    text,
+			Title:   feed.Title,
+			Type:    "rss",
+			XMLURL:  feed.URL,
+			HTMLURL: feed.Link,
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := doc.Write(&buf); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="subscriptions.opml"`)
+	c.Data(http.StatusOK, "text/x-opml; charset=utf-8", buf.Bytes())
+}
@@ -0,0 +1,173 @@
+package endpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cjlucas/unnamedcast/api"
+	"github.com/cjlucas/unnamedcast/db"
+	"github.com/cjlucas/unnamedcast/server/middleware"
+	"github.com/cjlucas/unnamedcast/worker/podcast"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Number of the latest items included in a preview
+	maxPreviewItems = 50
+
+	previewTTL        = 5 * time.Minute
+	maxPreviewEntries = 1000
+)
+
+// previewLimits bound the feeds fetched for a preview, which is done while
+// the client waits.
+var previewLimits = podcast.Limits{
+	MaxBytes: 10 << 20,
+	MaxItems: 5000,
+}
+
+// FeedPreview is a feed as it would be stored if it were created.
+type FeedPreview struct {
+	Feed  db.Feed   `json:"feed"`
+	Items []db.Item `json:"items"`
+}
+
+type previewEntry struct {
+	Preview *FeedPreview
+	Expires time.Time
+}
+
+// previewCache holds recent previews by feed URL, so that a client
+// showing a preview more than once does not fetch the feed every time.
+type previewCache struct {
+	mu      sync.Mutex
+	entries map[string]previewEntry
+}
+
+var previews = previewCache{entries: make(map[string]previewEntry)}
+
+func (c *previewCache) get(url string, now time.Time) (*FeedPreview, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[url]
+	if !ok || !now.Before(entry.Expires) {
+		return nil, false
+	}
+	return entry.Preview, true
+}
+
+func (c *previewCache) put(url string, preview *FeedPreview, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= maxPreviewEntries {
+		for k, entry := range c.entries {
+			if !now.Before(entry.Expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	if len(c.entries) >= maxPreviewEntries {
+		return
+	}
+
+	c.entries[url] = previewEntry{Preview: preview, Expires: now.Add(previewTTL)}
+}
+
+// PreviewFeed fetches and parses the feed at a URL without storing it, so
+// that a client can show the feed before subscribing to it.
+type PreviewFeed struct {
+	APIKey *db.APIKey
+	Now    func() time.Time
+	Fetch  FetchClient
+	Params struct {
+		URL string `param:"url,require"`
+	}
+}
+
+func (e *PreviewFeed) Bind() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.RequireScope(e.APIKey, db.ScopeFeeds),
+	}
+}
+
+func (e *PreviewFeed) Handle(c *gin.Context) {
+	feedURL, err := parseFeedURL(e.Params.URL)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid url: %s", err))
+		return
+	}
+
+	if preview, ok := previews.get(feedURL, e.Now()); ok {
+		c.JSON(http.StatusOK, preview)
+		return
+	}
+
+	resp, err := e.Fetch.Get(feedURL)
+	if err != nil {
+		c.AbortWithError(http.StatusBadGateway, fmt.Errorf("could not fetch feed: %s", err))
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		c.AbortWithError(http.StatusBadGateway, fmt.Errorf("could not fetch feed: %s", resp.Status))
+		return
+	}
+
+	items := make([]api.Item, 0)
+	feed, _, err := podcast.Decode(resp.Header.Get("Content-Type"), resp.Body, previewLimits, func(item *api.Item, _ []string) error {
+		items = append(items, *item)
+		return nil
+	})
+	switch {
+	case err == podcast.ErrTooLarge:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"reason": "feed is too large"})
+		c.Abort()
+		return
+	case err != nil:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"reason": "url is not a feed"})
+		c.Abort()
+		return
+	}
+
+	// Feeds list their items in either order
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].PublicationTime.After(items[j].PublicationTime)
+	})
+	if len(items) > maxPreviewItems {
+		items = items[:maxPreviewItems]
+	}
+
+	// The feed would be created with the URL it was found at
+	feed.URL = feedURL
+
+	var preview FeedPreview
+	if err := convertModel(feed, &preview.Feed); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+	if err := convertModel(items, &preview.Items); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	previews.put(feedURL, &preview, e.Now())
+	c.JSON(http.StatusOK, &preview)
+}
+
+// convertModel converts a model from its api form to its db form. The
+// forms share a JSON encoding, which is how the worker sends models to the
+// API.
+func convertModel(in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
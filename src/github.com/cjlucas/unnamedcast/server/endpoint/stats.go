@@ -8,13 +8,15 @@ import (
 	"time"
 
 	"github.com/cjlucas/unnamedcast/db"
+	"github.com/cjlucas/unnamedcast/server/middleware"
 	"github.com/gin-gonic/gin"
 )
 
 type GetQueueStats struct {
-	DB         *db.DB
-	Times      string `param:"ts,require"`
-	TimeSeries []time.Duration
+	DB          *db.DB
+	CurrentUser *db.User
+	Times       string `param:"ts,require"`
+	TimeSeries  []time.Duration
 }
 
 func (e *GetQueueStats) parseTimesParam(c *gin.Context) {
@@ -33,6 +35,7 @@ func (e *GetQueueStats) parseTimesParam(c *gin.Context) {
 
 func (e *GetQueueStats) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
+		middleware.RequireRole(e.CurrentUser, db.RoleAdmin),
 		e.parseTimesParam,
 	}
 }
@@ -0,0 +1,249 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cjlucas/koda-go"
+	"github.com/cjlucas/unnamedcast/db"
+	"github.com/cjlucas/unnamedcast/server/discovery"
+	"github.com/cjlucas/unnamedcast/server/middleware"
+	"github.com/gin-gonic/gin"
+)
+
+// feedURLSchemes maps the schemes podcast apps use to link to feeds onto
+// the scheme the feed is fetched with.
+var feedURLSchemes = map[string]string{
+	"http":  "http",
+	"https": "https",
+	"feed":  "http",
+	"itpc":  "http",
+	"pcast": "http",
+}
+
+// parseFeedURL validates and normalizes a feed URL given by a user.
+func parseFeedURL(rawurl string) (string, error) {
+	rawurl = strings.TrimSpace(rawurl)
+
+	// feed:https://example.com/feed
+	if strings.HasPrefix(strings.ToLower(rawurl), "feed:") && !strings.HasPrefix(rawurl[5:], "//") {
+		rawurl = rawurl[5:]
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", err
+	}
+
+	scheme, ok := feedURLSchemes[strings.ToLower(u.Scheme)]
+	if !ok || u.Host == "" {
+		return "", errors.New("not an http or https url")
+	}
+	u.Scheme = scheme
+
+	return db.CanonicalURL(u.String()), nil
+}
+
+// findFeed returns the feed with the given URL, or the feed that has
+// moved from it.
+func findFeed(database *db.DB, feedURL string) (*db.Feed, error) {
+	var feed db.Feed
+	if err := database.Feeds.Find(&db.Query{Filter: database.Feeds.URLFilter(feedURL)}).One(&feed); err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+// findOrCreateFeed returns the feed with the given URL, creating it if
+// necessary. created reports whether the feed was created.
+func findOrCreateFeed(database *db.DB, feedURL, title string) (feed *db.Feed, created bool, err error) {
+	switch feed, err := findFeed(database, feedURL); err {
+	case nil:
+		return feed, false, nil
+	case db.ErrNotFound:
+	default:
+		return nil, false, err
+	}
+
+	feed = &db.Feed{URL: feedURL, Title: title}
+	switch err := database.Feeds.Create(feed); {
+	case db.IsDup(err):
+		// Created by a concurrent request
+		feed, err = findFeed(database, feedURL)
+		return feed, false, err
+	case err != nil:
+		return nil, false, err
+	}
+
+	return feed, true, nil
+}
+
+// Priorities of update-feed jobs. Jobs with a higher priority are worked
+// first.
+const (
+	defaultUpdatePriority = 0
+
+	// A user is waiting for the first scrape of a feed they subscribed to
+	firstScrapePriority = 100
+)
+
+// enqueueFeedUpdate enqueues an update of the feed, so that it is scraped
+// without waiting for it to be scheduled.
+func enqueueFeedUpdate(database *db.DB, kc *koda.Client, feedID db.ID, priority int) error {
+	ep := CreateJob{
+		DB:   database,
+		Koda: kc,
+		Job: db.Job{
+			Queue:    "update-feed",
+			Priority: priority,
+			Payload:  db.M{"feed_id": feedID.Hex()},
+		},
+	}
+	_, err := ep.Create()
+	return err
+}
+
+// FetchClient fetches the URLs given by users. It should only connect to
+// public addresses, see publicnet.NewClient.
+type FetchClient struct {
+	*http.Client
+}
+
+// CreateSubscription subscribes the user to the feed at a URL, which may
+// be a web page that advertises the feed. The feed is created if it does
+// not exist yet.
+type CreateSubscription struct {
+	DB          *db.DB
+	Koda        *koda.Client
+	CurrentUser *db.User
+	Fetch       FetchClient
+	User        db.User
+	Body        struct {
+		URL string `json:"url"`
+	}
+}
+
+func (e *CreateSubscription) Bind() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.RequireOwnership(&middleware.RequireOwnershipOpts{
+			User:      e.CurrentUser,
+			BoundName: "id",
+		}),
+		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
+			Collection: e.DB.Users,
+			BoundName:  "id",
+			Result:     &e.User,
+		}),
+		middleware.UnmarshalBody(&e.Body),
+	}
+}
+
+func (e *CreateSubscription) Handle(c *gin.Context) {
+	feedURL, err := parseFeedURL(e.Body.URL)
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("invalid url: %s", err))
+		return
+	}
+
+	// Known URLs are subscribed to without fetching them
+	feed, err := findFeed(e.DB, feedURL)
+	if err == db.ErrNotFound {
+		// Unknown URLs may be web pages that advertise the feed
+		found, findErr := discovery.Find(e.Fetch.Client, feedURL)
+		switch {
+		case findErr == discovery.ErrNoFeed:
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"reason": "no feed found at url"})
+			c.Abort()
+			return
+		case findErr != nil:
+			c.AbortWithError(http.StatusBadGateway, fmt.Errorf("could not fetch url: %s", findErr))
+			return
+		}
+
+		feed, err = e.createFeed(c, found)
+	}
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	added, err := e.DB.Users.Subscribe(e.User.ID, feed.ID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	status := http.StatusOK
+	if added {
+		status = http.StatusCreated
+	}
+	c.JSON(status, feed)
+}
+
+// createFeed returns the feed at the discovered URL, creating it and
+// enqueuing its first scrape if necessary.
+func (e *CreateSubscription) createFeed(c *gin.Context, rawurl string) (*db.Feed, error) {
+	feedURL, err := parseFeedURL(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	feed, created, err := findOrCreateFeed(e.DB, feedURL, "")
+	if err != nil {
+		return nil, err
+	}
+
+	// The scheduler will get to the feed if the update can't be enqueued
+	if created {
+		if err := enqueueFeedUpdate(e.DB, e.Koda, feed.ID, firstScrapePriority); err != nil {
+			c.Error(err)
+		}
+	}
+
+	return feed, nil
+}
+
+// DeleteSubscription unsubscribes the user from the feed.
+type DeleteSubscription struct {
+	DB          *db.DB
+	CurrentUser *db.User
+	UserID      db.ID
+	FeedID      db.ID
+}
+
+func (e *DeleteSubscription) Bind() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.RequireOwnership(&middleware.RequireOwnershipOpts{
+			User:      e.CurrentUser,
+			BoundName: "id",
+		}),
+		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
+			Collection: e.DB.Users,
+			BoundName:  "id",
+			ID:         &e.UserID,
+		}),
+		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
+			Collection: e.DB.Feeds,
+			BoundName:  "feedID",
+			ID:         &e.FeedID,
+		}),
+	}
+}
+
+func (e *DeleteSubscription) Handle(c *gin.Context) {
+	removed, err := e.DB.Users.Unsubscribe(e.UserID, e.FeedID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if !removed {
+		c.AbortWithError(http.StatusNotFound, errors.New("not subscribed to feed"))
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
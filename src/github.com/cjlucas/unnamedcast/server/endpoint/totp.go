@@ -0,0 +1,143 @@
+package endpoint
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cjlucas/unnamedcast/db"
+	"github.com/cjlucas/unnamedcast/server/middleware"
+	"github.com/cjlucas/unnamedcast/server/totp"
+	"github.com/gin-gonic/gin"
+)
+
+// totpIssuer is shown alongside the account name in authenticator apps
+const totpIssuer = "unnamedcast"
+
+// EnrollTOTP generates a new TOTP secret for the user. TOTP is not required
+// at login until the secret is confirmed with EnableTOTP.
+type EnrollTOTP struct {
+	DB          *db.DB
+	CurrentUser *db.User
+}
+
+func (e *EnrollTOTP) Bind() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.RequireOwnership(&middleware.RequireOwnershipOpts{
+			User:      e.CurrentUser,
+			BoundName: "id",
+		}),
+	}
+}
+
+func (e *EnrollTOTP) Handle(c *gin.Context) {
+	if e.CurrentUser.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"reason": "totp is already enabled"})
+		c.Abort()
+		return
+	}
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := e.DB.Users.SetTOTPSecret(e.CurrentUser.ID, secret); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret": secret,
+		"uri":    totp.URI(secret, totpIssuer, e.CurrentUser.Username),
+	})
+}
+
+// EnableTOTP confirms enrollment with a code generated from the secret
+// returned by EnrollTOTP. The response contains the user's recovery codes,
+// which are not retrievable again.
+type EnableTOTP struct {
+	DB          *db.DB
+	CurrentUser *db.User
+	Now         func() time.Time
+	Body        struct {
+		Code string `json:"code"`
+	}
+}
+
+func (e *EnableTOTP) Bind() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.RequireOwnership(&middleware.RequireOwnershipOpts{
+			User:      e.CurrentUser,
+			BoundName: "id",
+		}),
+		middleware.UnmarshalBody(&e.Body),
+	}
+}
+
+func (e *EnableTOTP) Handle(c *gin.Context) {
+	switch {
+	case e.CurrentUser.TOTPEnabled:
+		c.JSON(http.StatusConflict, gin.H{"reason": "totp is already enabled"})
+		c.Abort()
+		return
+	case e.CurrentUser.TOTPSecret == "":
+		c.JSON(http.StatusConflict, gin.H{"reason": "totp enrollment has not been started"})
+		c.Abort()
+		return
+	}
+
+	step, ok := totp.Validate(e.CurrentUser.TOTPSecret, e.Body.Code, e.Now())
+	if !ok {
+		c.AbortWithError(http.StatusBadRequest, errors.New("invalid code"))
+		return
+	}
+
+	if err := e.DB.Users.UseTOTPStep(e.CurrentUser.ID, step); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	codes, err := e.DB.Users.EnableTOTP(e.CurrentUser.ID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DisableTOTP turns off two-factor authentication after confirming the
+// user's password.
+type DisableTOTP struct {
+	DB          *db.DB
+	CurrentUser *db.User
+	Body        struct {
+		Password string `json:"password"`
+	}
+}
+
+func (e *DisableTOTP) Bind() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.RequireOwnership(&middleware.RequireOwnershipOpts{
+			User:      e.CurrentUser,
+			BoundName: "id",
+		}),
+		middleware.UnmarshalBody(&e.Body),
+	}
+}
+
+func (e *DisableTOTP) Handle(c *gin.Context) {
+	if !e.CurrentUser.CheckPassword(e.Body.Password) {
+		c.AbortWithError(http.StatusForbidden, errors.New("password is incorrect"))
+		return
+	}
+
+	if err := e.DB.Users.DisableTOTP(e.CurrentUser.ID); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
@@ -1,6 +1,7 @@
 package endpoint
 
 import (
+	"errors"
 	"net/http"
 	"strings"
 	"time"
@@ -11,9 +12,10 @@ import (
 )
 
 type GetUsers struct {
-	DB     *db.DB
-	Query  db.Query
-	Params struct {
+	DB          *db.DB
+	CurrentUser *db.User
+	Query       db.Query
+	Params      struct {
 		sortParams
 		limitParams
 		ModifiedSince time.Time `param:"modified_since"`
@@ -22,6 +24,7 @@ type GetUsers struct {
 
 func (e *GetUsers) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
+		middleware.RequireRole(e.CurrentUser, db.RoleAdmin),
 		middleware.AddQuerySortInfo(e.DB.Users.ModelInfo, &e.Query, &e.Params, "modification_time"),
 		middleware.AddQueryLimitInfo(&e.Query, &e.Params),
 	}
@@ -37,23 +40,32 @@ func (e *GetUsers) Handle(c *gin.Context) {
 }
 
 type CreateUser struct {
-	DB     *db.DB
-	Params struct {
-		Username string `param:",require"`
-		Password string `param:",require"`
+	DB   *db.DB
+	Body struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
 	}
 }
 
 func (e *CreateUser) Bind() []gin.HandlerFunc {
-	return nil
+	return []gin.HandlerFunc{
+		middleware.UnmarshalBody(&e.Body),
+	}
 }
 
 func (e *CreateUser) Handle(c *gin.Context) {
-	for _, s := range []*string{&e.Params.Username, &e.Params.Password} {
-		*s = strings.TrimSpace(*s)
+	e.Body.Username = strings.TrimSpace(e.Body.Username)
+
+	if err := db.ValidateUsername(e.Body.Username); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+	if err := db.ValidatePassword(e.Body.Password); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
 	}
 
-	switch user, err := e.DB.Users.Create(e.Params.Username, e.Params.Password); {
+	switch user, err := e.DB.Users.Create(e.Body.Username, e.Body.Password); {
 	case err == nil:
 		c.JSON(http.StatusOK, user)
 	case db.IsDup(err):
@@ -66,13 +78,136 @@ func (e *CreateUser) Handle(c *gin.Context) {
 	}
 }
 
-type GetUser struct {
+type ChangePassword struct {
+	DB          *db.DB
+	CurrentUser *db.User
+	Session     *db.Session
+	Body        struct {
+		OldPassword string `json:"old_password"`
+		NewPassword string `json:"new_password"`
+	}
+}
+
+func (e *ChangePassword) Bind() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.RequireOwnership(&middleware.RequireOwnershipOpts{
+			User:      e.CurrentUser,
+			BoundName: "id",
+		}),
+		middleware.UnmarshalBody(&e.Body),
+	}
+}
+
+func (e *ChangePassword) Handle(c *gin.Context) {
+	if !e.CurrentUser.CheckPassword(e.Body.OldPassword) {
+		c.AbortWithError(http.StatusForbidden, errors.New("old password is incorrect"))
+		return
+	}
+
+	if err := db.ValidatePassword(e.Body.NewPassword); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if err := e.DB.Users.SetPassword(e.CurrentUser.ID, e.Body.NewPassword); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	// Sign out everywhere else in case the old password was compromised
+	if err := e.DB.Sessions.RevokeOthers(e.CurrentUser.ID, e.Session.ID); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+type CreateResetToken struct {
+	DB          *db.DB
+	CurrentUser *db.User
+	UserID      db.ID
+}
+
+func (e *CreateResetToken) Bind() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.RequireRole(e.CurrentUser, db.RoleAdmin),
+		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
+			Collection: e.DB.Users,
+			BoundName:  "id",
+			ID:         &e.UserID,
+		}),
+	}
+}
+
+func (e *CreateResetToken) Handle(c *gin.Context) {
+	token, expiration, err := e.DB.Users.CreateResetToken(e.UserID)
+	if err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":           token,
+		"expiration_time": &expiration,
+	})
+}
+
+type ResetPassword struct {
 	DB   *db.DB
-	User db.User
+	Body struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+}
+
+func (e *ResetPassword) Bind() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.UnmarshalBody(&e.Body),
+	}
+}
+
+func (e *ResetPassword) Handle(c *gin.Context) {
+	userID, err := db.IDFromString(c.Param("id"))
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	if err := db.ValidatePassword(e.Body.NewPassword); err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	switch err := e.DB.Users.ResetPassword(userID, e.Body.Token, e.Body.NewPassword); {
+	case err == db.ErrInvalidResetToken:
+		c.AbortWithError(http.StatusForbidden, err)
+		return
+	case err != nil:
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	if err := e.DB.Sessions.RevokeAll(userID); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+type GetUser struct {
+	DB          *db.DB
+	CurrentUser *db.User
+	User        db.User
 }
 
 func (e *GetUser) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
+		middleware.RequireOwnership(&middleware.RequireOwnershipOpts{
+			User:      e.CurrentUser,
+			BoundName: "id",
+		}),
 		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
 			Collection: e.DB.Users,
 			BoundName:  "id",
@@ -86,12 +221,17 @@ func (e *GetUser) Handle(c *gin.Context) {
 }
 
 type GetUserFeeds struct {
-	DB   *db.DB
-	User db.User
+	DB          *db.DB
+	CurrentUser *db.User
+	User        db.User
 }
 
 func (e *GetUserFeeds) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
+		middleware.RequireOwnership(&middleware.RequireOwnershipOpts{
+			User:      e.CurrentUser,
+			BoundName: "id",
+		}),
 		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
 			Collection: e.DB.Users,
 			BoundName:  "id",
@@ -105,13 +245,18 @@ func (e *GetUserFeeds) Handle(c *gin.Context) {
 }
 
 type UpdateUserFeeds struct {
-	DB      *db.DB
-	User    db.User
-	FeedIDs []db.ID
+	DB          *db.DB
+	CurrentUser *db.User
+	User        db.User
+	FeedIDs     []db.ID
 }
 
 func (e *UpdateUserFeeds) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
+		middleware.RequireOwnership(&middleware.RequireOwnershipOpts{
+			User:      e.CurrentUser,
+			BoundName: "id",
+		}),
 		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
 			Collection: e.DB.Users,
 			BoundName:  "id",
@@ -133,15 +278,20 @@ func (e *UpdateUserFeeds) Handle(c *gin.Context) {
 }
 
 type GetUserItemStates struct {
-	DB     *db.DB
-	UserID db.ID
-	Params struct {
+	DB          *db.DB
+	CurrentUser *db.User
+	UserID      db.ID
+	Params      struct {
 		ModifiedSince time.Time `param:"modified_since"`
 	}
 }
 
 func (e *GetUserItemStates) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
+		middleware.RequireOwnership(&middleware.RequireOwnershipOpts{
+			User:      e.CurrentUser,
+			BoundName: "id",
+		}),
 		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
 			Collection: e.DB.Users,
 			BoundName:  "id",
@@ -168,14 +318,23 @@ func (e *GetUserItemStates) Handle(c *gin.Context) {
 }
 
 type UpdateUserItemState struct {
-	DB        *db.DB
-	ItemState db.ItemState
-	UserID    db.ID
-	ItemID    db.ID
+	DB          *db.DB
+	APIKey      *db.APIKey
+	CurrentUser *db.User
+	ItemState   db.ItemState
+	UserID      db.ID
+	ItemID      db.ID
 }
 
 func (e *UpdateUserItemState) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
+		// API keys are not bound to a user, the scope grants access to the
+		// states of every user
+		middleware.RequireScope(e.APIKey, db.ScopeUserStates),
+		middleware.UnlessAPIKey(e.APIKey, middleware.RequireOwnership(&middleware.RequireOwnershipOpts{
+			User:      e.CurrentUser,
+			BoundName: "id",
+		})),
 		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
 			Collection: e.DB.Users,
 			BoundName:  "id",
@@ -205,13 +364,18 @@ func (e *UpdateUserItemState) Handle(c *gin.Context) {
 }
 
 type DeleteUserItemState struct {
-	DB     *db.DB
-	UserID db.ID
-	ItemID db.ID
+	DB          *db.DB
+	CurrentUser *db.User
+	UserID      db.ID
+	ItemID      db.ID
 }
 
 func (e *DeleteUserItemState) Bind() []gin.HandlerFunc {
 	return []gin.HandlerFunc{
+		middleware.RequireOwnership(&middleware.RequireOwnershipOpts{
+			User:      e.CurrentUser,
+			BoundName: "id",
+		}),
 		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
 			Collection: e.DB.Users,
 			BoundName:  "id",
@@ -0,0 +1,163 @@
+package endpoint
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/cjlucas/koda-go"
+	"github.com/cjlucas/unnamedcast/db"
+	"github.com/cjlucas/unnamedcast/db/utctime"
+	"github.com/cjlucas/unnamedcast/server/middleware"
+	"github.com/cjlucas/unnamedcast/websub"
+	"github.com/gin-gonic/gin"
+)
+
+// WebSubKey is the key from which the secrets of WebSub subscriptions are
+// derived. WebSub callbacks are disabled if it is empty.
+type WebSubKey []byte
+
+const (
+	// maxWebSubContentSize is the largest pushed body that will be verified.
+	maxWebSubContentSize = 32 << 20
+
+	// maxWebSubLease is the longest lease that will be recorded, regardless
+	// of what the hub grants.
+	maxWebSubLease = 30 * 24 * time.Hour
+)
+
+func requireWebSubKey(key WebSubKey) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(key) == 0 {
+			c.AbortWithError(http.StatusNotFound, errors.New("websub is not enabled"))
+		}
+	}
+}
+
+// VerifyWebSubSubscription answers a hub's request to verify that a
+// subscription to the feed, or its removal, was requested.
+type VerifyWebSubSubscription struct {
+	DB     *db.DB
+	Key    WebSubKey
+	Clock  func() time.Time
+	Feed   db.Feed
+	Params struct {
+		Mode         string `param:"hub.mode"`
+		Topic        string `param:"hub.topic"`
+		Challenge    string `param:"hub.challenge"`
+		LeaseSeconds int    `param:"hub.lease_seconds"`
+	}
+}
+
+func (e *VerifyWebSubSubscription) Bind() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		requireWebSubKey(e.Key),
+		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
+			Collection: e.DB.Feeds,
+			BoundName:  "id",
+			Result:     &e.Feed,
+		}),
+	}
+}
+
+func (e *VerifyWebSubSubscription) Handle(c *gin.Context) {
+	// The feed is only subscribed to its current hub and topic
+	wanted := e.Feed.HubURL != "" && e.Params.Topic == e.Feed.HubTopic
+
+	switch e.Params.Mode {
+	case "subscribe", "unsubscribe":
+		if e.Params.Challenge == "" {
+			c.AbortWithError(http.StatusBadRequest, errors.New("hub.challenge not specified"))
+			return
+		}
+	case "denied":
+		if wanted {
+			if err := e.DB.Feeds.SetHubLeaseExpiry(e.Feed.ID, time.Time{}); err != nil {
+				c.AbortWithError(http.StatusInternalServerError, err)
+				return
+			}
+		}
+		c.Status(http.StatusOK)
+		return
+	default:
+		c.AbortWithError(http.StatusBadRequest, fmt.Errorf("unknown hub.mode: %q", e.Params.Mode))
+		return
+	}
+
+	// Subscriptions are only verified while the worker is waiting on them
+	now := e.Clock()
+	nowUTC := utctime.New(now)
+	requested := wanted && nowUTC.Before(e.Feed.HubPendingUntil)
+	if (e.Params.Mode == "subscribe" && !requested) || (e.Params.Mode == "unsubscribe" && wanted) {
+		c.AbortWithError(http.StatusNotFound, errors.New("subscription was not requested"))
+		return
+	}
+
+	if e.Params.Mode == "subscribe" {
+		if e.Params.LeaseSeconds <= 0 {
+			c.AbortWithError(http.StatusBadRequest, errors.New("hub.lease_seconds not specified"))
+			return
+		}
+
+		lease := maxWebSubLease
+		if e.Params.LeaseSeconds < int(maxWebSubLease/time.Second) {
+			lease = time.Duration(e.Params.LeaseSeconds) * time.Second
+		}
+
+		expiry := now.Add(lease)
+		if err := e.DB.Feeds.SetHubLeaseExpiry(e.Feed.ID, expiry); err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	c.String(http.StatusOK, e.Params.Challenge)
+}
+
+// ReceiveWebSubContent is notified by a hub when the feed has changed. An
+// update of the feed is enqueued if the notification is signed with the
+// subscription's secret.
+type ReceiveWebSubContent struct {
+	DB   *db.DB
+	Koda *koda.Client
+	Key  WebSubKey
+	Feed db.Feed
+}
+
+func (e *ReceiveWebSubContent) Bind() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		requireWebSubKey(e.Key),
+		middleware.RequireExistingModel(&middleware.RequireExistingModelOpts{
+			Collection: e.DB.Feeds,
+			BoundName:  "id",
+			Result:     &e.Feed,
+		}),
+	}
+}
+
+func (e *ReceiveWebSubContent) Handle(c *gin.Context) {
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, maxWebSubContentSize+1))
+	if err != nil {
+		c.AbortWithError(http.StatusBadRequest, err)
+		return
+	}
+
+	// Hubs must be told the notification was received even if it is
+	// ignored, so that a forged one reveals nothing
+	secret := websub.Secret(e.Key, e.Feed.ID.Hex())
+	sig := c.Request.Header.Get("X-Hub-Signature")
+	if len(body) > maxWebSubContentSize || !websub.VerifySignature(secret, body, sig) {
+		c.Status(http.StatusAccepted)
+		return
+	}
+
+	if err := enqueueFeedUpdate(e.DB, e.Koda, e.Feed.ID, defaultUpdatePriority); err != nil {
+		c.AbortWithError(http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
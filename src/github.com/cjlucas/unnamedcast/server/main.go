@@ -1,46 +1,112 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/cjlucas/koda-go"
 	"github.com/cjlucas/unnamedcast/db"
+	"github.com/cjlucas/unnamedcast/publicnet"
 	"github.com/cjlucas/unnamedcast/server/endpoint"
 	"github.com/cjlucas/unnamedcast/server/middleware"
 	"github.com/cjlucas/unnamedcast/server/queryparser"
 	"github.com/gin-gonic/gin"
 	"github.com/robfig/cron"
+	"gopkg.in/redis.v3"
 )
 
 const (
 	endpointCtxKey = "endpoint"
+	userCtxKey     = "user"
+	sessionCtxKey  = "session"
+	apiKeyCtxKey   = "api_key"
 )
 
+// defaultRateLimits are applied per client to the endpoints with the given name.
+var defaultRateLimits = map[string]middleware.Rate{
+	"SearchFeeds":         {Requests: 30, Per: time.Minute, Burst: 10},
+	"PreviewFeed":         {Requests: 30, Per: time.Minute, Burst: 10},
+	"UpdateUserItemState": {Requests: 120, Per: time.Minute, Burst: 60},
+	"Login":               {Requests: 10, Per: time.Minute},
+	"VerifyLogin":         {Requests: 10, Per: time.Minute},
+}
+
 type App struct {
-	DB   *db.DB
-	Koda *koda.Client
-	g    *gin.Engine
+	DB    *db.DB
+	Koda  *koda.Client
+	Clock func() time.Time
+
+	// RateLimits by endpoint name. Changes take effect immediately.
+	RateLimits *middleware.RateLimits
+
+	WebSubKey endpoint.WebSubKey
+	Cookies   endpoint.CookieOptions
+
+	// Fetch is used to fetch URLs given by users
+	Fetch endpoint.FetchClient
+
+	g         *gin.Engine
+	rateLimit gin.HandlerFunc
 }
 
 type Config struct {
 	DB   *db.DB
 	Koda *koda.Client
+
+	// Clock returns the current time. Defaults to time.Now.
+	Clock func() time.Time
+
+	// RateLimitStore defaults to an in-memory store
+	RateLimitStore middleware.RateLimitStore
+
+	// WebSubKey must match the key given to the workers. WebSub callbacks
+	// are disabled if it is not set.
+	WebSubKey []byte
+
+	// InsecureCookies allows the session cookie to be sent over plain
+	// HTTP. It should only be set in development.
+	InsecureCookies bool
 }
 
 // TODO: Make default App usable and remove Config.
 // Perform setupRoutes in Run()
 func NewApp(cfg Config) *App {
 	app := App{
-		DB:   cfg.DB,
-		Koda: cfg.Koda,
+		DB:        cfg.DB,
+		Koda:      cfg.Koda,
+		Clock:     cfg.Clock,
+		WebSubKey: cfg.WebSubKey,
+		Cookies:   endpoint.CookieOptions{Insecure: cfg.InsecureCookies},
+		Fetch:     endpoint.FetchClient{Client: publicnet.NewClient(10 * time.Second)},
+	}
+	if app.Clock == nil {
+		app.Clock = time.Now
 	}
 
+	app.RateLimits = middleware.NewRateLimits(defaultRateLimits)
+
+	store := cfg.RateLimitStore
+	if store == nil {
+		store = middleware.NewMemoryRateLimitStore()
+	}
+
+	app.rateLimit = middleware.RateLimit(&middleware.RateLimitOpts{
+		Store:          store,
+		Limits:         app.RateLimits,
+		Now:            func() time.Time { return app.Clock() },
+		EndpointCtxKey: endpointCtxKey,
+		UserCtxKey:     userCtxKey,
+		APIKeyCtxKey:   apiKeyCtxKey,
+	})
+
 	app.setupRoutes()
 	return &app
 }
@@ -49,9 +115,33 @@ func (app *App) RegisterEndpoint(e endpoint.Interface) gin.HandlerFunc {
 	queryParamInfo := queryparser.NewQueryParamInfo(e)
 	endpointType := reflect.TypeOf(e).Elem()
 
+	// API keys are denied by default. An endpoint opts in by declaring a
+	// *db.APIKey field, and is then responsible for checking its scope.
+	var acceptsAPIKey bool
+	apiKeyType := reflect.TypeOf((*db.APIKey)(nil))
+	for i := 0; i < endpointType.NumField(); i++ {
+		if endpointType.Field(i).Type == apiKeyType {
+			acceptsAPIKey = true
+		}
+	}
+
+	webSubKeyType := reflect.TypeOf(endpoint.WebSubKey(nil))
+	cookieOptionsType := reflect.TypeOf(endpoint.CookieOptions{})
+	fetchClientType := reflect.TypeOf(endpoint.FetchClient{})
+
 	return func(c *gin.Context) {
 		c.Set(endpointCtxKey, endpointType.Name())
 
+		if _, ok := c.Get(apiKeyCtxKey); ok && !acceptsAPIKey {
+			c.AbortWithError(http.StatusForbidden, errors.New("endpoint cannot be called with an api key"))
+			return
+		}
+
+		app.rateLimit(c)
+		if c.IsAborted() {
+			return
+		}
+
 		// Create type
 		v := reflect.New(endpointType)
 		endpoint := v.Interface().(endpoint.Interface)
@@ -64,6 +154,29 @@ func (app *App) RegisterEndpoint(e endpoint.Interface) gin.HandlerFunc {
 				f.Set(reflect.ValueOf(app.DB))
 			case *koda.Client:
 				f.Set(reflect.ValueOf(app.Koda))
+			case func() time.Time:
+				f.Set(reflect.ValueOf(app.Clock))
+			case *db.User:
+				if user, ok := c.Get(userCtxKey); ok {
+					f.Set(reflect.ValueOf(user))
+				}
+			case *db.Session:
+				if session, ok := c.Get(sessionCtxKey); ok {
+					f.Set(reflect.ValueOf(session))
+				}
+			case *db.APIKey:
+				if apiKey, ok := c.Get(apiKeyCtxKey); ok {
+					f.Set(reflect.ValueOf(apiKey))
+				}
+			}
+
+			switch f.Type() {
+			case webSubKeyType:
+				f.Set(reflect.ValueOf(app.WebSubKey))
+			case cookieOptionsType:
+				f.Set(reflect.ValueOf(app.Cookies))
+			case fetchClientType:
+				f.Set(reflect.ValueOf(app.Fetch))
 			}
 		}
 
@@ -83,19 +196,59 @@ func (app *App) RegisterEndpoint(e endpoint.Interface) gin.HandlerFunc {
 func (app *App) setupRoutes() {
 	app.g = gin.Default()
 
+	authenticate := middleware.Authenticate(&middleware.AuthenticateOpts{
+		Sessions:      app.DB.Sessions,
+		Users:         app.DB.Users,
+		APIKeys:       app.DB.APIKeys,
+		UserCtxKey:    userCtxKey,
+		SessionCtxKey: sessionCtxKey,
+		APIKeyCtxKey:  apiKeyCtxKey,
+	})
+
 	cwd, _ := filepath.Abs(filepath.Dir(os.Args[0]))
-	app.g.Static("/dashboard", filepath.Join(cwd, "dashboard", "dist"))
+	dashboard := app.g.Group("/dashboard", authenticate, func(c *gin.Context) {
+		user, _ := c.Get(userCtxKey)
+		u, _ := user.(*db.User)
+		middleware.RequireRole(u, db.RoleAdmin)(c)
+	})
+	dashboard.Static("", filepath.Join(cwd, "dashboard", "dist"))
 
 	app.g.GET("/search_feeds", app.RegisterEndpoint(&endpoint.SearchFeeds{}))
 	app.g.GET("/login", app.RegisterEndpoint(&endpoint.Login{}))
+	app.g.GET("/login/verify", app.RegisterEndpoint(&endpoint.VerifyLogin{}))
+
+	// WebSub hubs call these to verify subscriptions and deliver updates.
+	// They are not logged, as anyone may post content of any size to them.
+	app.g.GET("/api/websub/:id", app.RegisterEndpoint(&endpoint.VerifyWebSubSubscription{}))
+	app.g.POST("/api/websub/:id", app.RegisterEndpoint(&endpoint.ReceiveWebSubContent{}))
 
-	api := app.g.Group("/api", middleware.LogRequest(app.DB.Logs, endpointCtxKey))
+	public := app.g.Group("/api", middleware.LogRequest(app.DB.Logs, endpointCtxKey))
+
+	// Endpoints whose body contains credentials. The body is redacted
+	// before authentication and rate limiting, which may abort the request.
+	publicRedacted := public.Group("", middleware.RedactBody())
+	publicRedacted.POST("/users", app.RegisterEndpoint(&endpoint.CreateUser{}))
+	publicRedacted.POST("/users/:id/reset_password", app.RegisterEndpoint(&endpoint.ResetPassword{}))
+
+	// All other endpoints require a session or an API key
+	api := public.Group("", authenticate)
+	apiRedacted := public.Group("", middleware.RedactBody(), authenticate)
+
+	api.DELETE("/session", app.RegisterEndpoint(&endpoint.Logout{}))
 
 	api.GET("/users", app.RegisterEndpoint(&endpoint.GetUsers{}))
-	api.POST("/users", app.RegisterEndpoint(&endpoint.CreateUser{}))
 	api.GET("/users/:id", app.RegisterEndpoint(&endpoint.GetUser{}))
+	apiRedacted.PUT("/users/:id/password", app.RegisterEndpoint(&endpoint.ChangePassword{}))
+	api.POST("/users/:id/totp", app.RegisterEndpoint(&endpoint.EnrollTOTP{}))
+	api.PUT("/users/:id/totp", app.RegisterEndpoint(&endpoint.EnableTOTP{}))
+	apiRedacted.DELETE("/users/:id/totp", app.RegisterEndpoint(&endpoint.DisableTOTP{}))
+	api.POST("/users/:id/reset_token", app.RegisterEndpoint(&endpoint.CreateResetToken{}))
 	api.GET("/users/:id/feeds", app.RegisterEndpoint(&endpoint.GetUserFeeds{}))
 	api.PUT("/users/:id/feeds", app.RegisterEndpoint(&endpoint.UpdateUserFeeds{}))
+	api.GET("/users/:id/opml", app.RegisterEndpoint(&endpoint.ExportUserOPML{}))
+	api.POST("/users/:id/opml", app.RegisterEndpoint(&endpoint.ImportUserOPML{}))
+	api.POST("/users/:id/subscriptions", app.RegisterEndpoint(&endpoint.CreateSubscription{}))
+	api.DELETE("/users/:id/subscriptions/:feedID", app.RegisterEndpoint(&endpoint.DeleteSubscription{}))
 	api.GET("/users/:id/states", app.RegisterEndpoint(&endpoint.GetUserItemStates{}))
 	api.PUT("/users/:id/states/:itemID", app.RegisterEndpoint(&endpoint.UpdateUserItemState{}))
 	api.DELETE("/users/:id/states/:itemID", app.RegisterEndpoint(&endpoint.DeleteUserItemState{}))
@@ -103,15 +256,28 @@ func (app *App) setupRoutes() {
 	// GET /api/feeds
 	// GET /api/feeds?url=http://url.com
 	// GET /api/feeds?itunes_id=43912431
+	// GET /api/feeds/preview?url=http://url.com
 	//
 	// TODO: modify the ?url and ?itunes_id variants to return a list for consistency
 	api.GET("/feeds", app.RegisterEndpoint(&endpoint.GetFeeds{}))
 	api.POST("/feeds", app.RegisterEndpoint(&endpoint.CreateFeed{}))
-	api.GET("/feeds/:id", app.RegisterEndpoint(&endpoint.GetFeed{}))
+	getFeed := app.RegisterEndpoint(&endpoint.GetFeed{})
+	previewFeed := app.RegisterEndpoint(&endpoint.PreviewFeed{})
+	api.GET("/feeds/:id", func(c *gin.Context) {
+		// The router can't match a static segment alongside :id
+		if c.Param("id") == "preview" {
+			previewFeed(c)
+		} else {
+			getFeed(c)
+		}
+	})
 	api.PUT("/feeds/:id", app.RegisterEndpoint(&endpoint.UpdateFeed{}))
+	api.POST("/feeds/:id/lease", app.RegisterEndpoint(&endpoint.LeaseDueFeed{}))
 	api.GET("/feeds/:id/items", app.RegisterEndpoint(&endpoint.GetFeedItems{}))
 	api.GET("/feeds/:id/users", app.RegisterEndpoint(&endpoint.GetFeedUsers{}))
 	api.POST("/feeds/:id/items", app.RegisterEndpoint(&endpoint.CreateFeedItem{}))
+	api.POST("/feeds/:id/items/find", app.RegisterEndpoint(&endpoint.FindFeedItems{}))
+	api.POST("/feeds/:id/items/mark_removed", app.RegisterEndpoint(&endpoint.MarkRemovedFeedItems{}))
 	api.GET("/feeds/:id/items/:itemID", app.RegisterEndpoint(&endpoint.GetFeedItem{}))
 	api.PUT("/feeds/:id/items/:itemID", app.RegisterEndpoint(&endpoint.UpdateFeedItem{}))
 
@@ -119,6 +285,10 @@ func (app *App) setupRoutes() {
 	api.GET("/jobs/:id", app.RegisterEndpoint(&endpoint.GetJob{}))
 	api.POST("/jobs", app.RegisterEndpoint(&endpoint.CreateJob{}))
 
+	api.GET("/keys", app.RegisterEndpoint(&endpoint.GetAPIKeys{}))
+	api.POST("/keys", app.RegisterEndpoint(&endpoint.CreateAPIKey{}))
+	api.DELETE("/keys/:id", app.RegisterEndpoint(&endpoint.DeleteAPIKey{}))
+
 	api.GET("/logs", app.RegisterEndpoint(&endpoint.GetLogs{}))
 
 	api.GET("/stats/queues", app.RegisterEndpoint(&endpoint.GetQueueStats{}))
@@ -136,6 +306,21 @@ func getenv(key, fallback string) string {
 	return val
 }
 
+// newRedisClient connects to the redis instance at rawurl, which is given
+// in the same format koda accepts: redis://host[:port][/database]
+func newRedisClient(rawurl string) *redis.Client {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		panic(fmt.Errorf("Invalid redis url: %s", err))
+	}
+	database, _ := strconv.Atoi(strings.TrimPrefix(u.Path, "/"))
+
+	return redis.NewClient(&redis.Options{
+		Addr: u.Host,
+		DB:   int64(database),
+	})
+}
+
 func main() {
 	c := cron.New()
 
@@ -161,10 +346,23 @@ func main() {
 		panic(fmt.Errorf("Failed to connect to DB: %s", err))
 	}
 
-	app := NewApp(Config{
-		DB:   dbConn,
-		Koda: kodaClient,
-	})
+	cfg := Config{
+		DB:        dbConn,
+		Koda:      kodaClient,
+		WebSubKey: []byte(os.Getenv("WEBSUB_KEY")),
+
+		InsecureCookies: os.Getenv("INSECURE_COOKIES") != "",
+	}
+
+	// Share rate limits between replicas of the server
+	if getenv("RATE_LIMIT_STORE", "memory") == "redis" {
+		cfg.RateLimitStore = &middleware.RedisRateLimitStore{
+			Client: newRedisClient(getenv("REDIS_URL", "redis://localhost:6379")),
+			Prefix: "ratelimit:",
+		}
+	}
+
+	app := NewApp(cfg)
 
 	c.AddFunc("0 */10 * * * *", func() {
 		fmt.Println("Updating user feeds")
@@ -0,0 +1,174 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cjlucas/unnamedcast/db"
+	"github.com/gin-gonic/gin"
+)
+
+// SessionCookie is the name of the cookie holding the session token for
+// browser based clients such as the dashboard.
+const SessionCookie = "session"
+
+// sessionToken returns the session token given by the request. The session
+// cookie is only honored for read-only requests so it cannot be used to
+// perform cross-site writes on behalf of the user.
+func sessionToken(c *gin.Context) string {
+	if token := bearerToken(c); token != "" {
+		return token
+	}
+
+	if c.Request.Method == "GET" || c.Request.Method == "HEAD" {
+		if token, err := c.Cookie(SessionCookie); err == nil {
+			return token
+		}
+	}
+
+	return ""
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer <token>" header.
+func bearerToken(c *gin.Context) string {
+	const prefix = "Bearer "
+
+	h := c.Request.Header.Get("Authorization")
+	if len(h) <= len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return ""
+	}
+
+	return strings.TrimSpace(h[len(prefix):])
+}
+
+func abortUnauthorized(c *gin.Context, err error) {
+	c.Header("WWW-Authenticate", "Bearer")
+	c.AbortWithError(http.StatusUnauthorized, err)
+}
+
+type AuthenticateOpts struct {
+	Sessions db.SessionCollection
+	Users    db.UserCollection
+	APIKeys  db.APIKeyCollection
+
+	// Context keys the authenticated principal is stored under
+	UserCtxKey    string
+	SessionCtxKey string
+	APIKeyCtxKey  string
+}
+
+// Authenticate resolves the bearer token of the request to a principal.
+// Session tokens resolve to a session and its user, which are stored under
+// SessionCtxKey and UserCtxKey respectively. API keys are stored under
+// APIKeyCtxKey. Requests without valid credentials are aborted.
+func Authenticate(opts *AuthenticateOpts) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key := bearerToken(c); strings.HasPrefix(key, db.APIKeyPrefix) {
+			switch apiKey, err := opts.APIKeys.APIKeyByKey(key); {
+			case err == db.ErrNotFound:
+				abortUnauthorized(c, errors.New("invalid api key"))
+			case err != nil:
+				c.AbortWithError(http.StatusInternalServerError, err)
+			default:
+				c.Set(opts.APIKeyCtxKey, apiKey)
+			}
+			return
+		}
+
+		token := sessionToken(c)
+		if token == "" {
+			abortUnauthorized(c, errors.New("no session token given"))
+			return
+		}
+
+		session, err := opts.Sessions.SessionByToken(token)
+		switch {
+		case err == db.ErrNotFound:
+			abortUnauthorized(c, errors.New("invalid or expired session"))
+			return
+		case err != nil:
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		var user db.User
+		switch err := opts.Users.FindByID(session.UserID).One(&user); {
+		case err == db.ErrNotFound:
+			abortUnauthorized(c, errors.New("session user no longer exists"))
+			return
+		case err != nil:
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		c.Set(opts.SessionCtxKey, session)
+		c.Set(opts.UserCtxKey, &user)
+	}
+}
+
+type RequireOwnershipOpts struct {
+	// User is the authenticated user making the request
+	User      *db.User
+	BoundName string
+}
+
+// RequireOwnership ensures the user ID bound to BoundName belongs to the
+// authenticated user. The request is aborted with 403 for any other ID,
+// regardless of whether a user with that ID exists.
+func RequireOwnership(opts *RequireOwnershipOpts) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if opts.User == nil {
+			abortUnauthorized(c, errors.New("no authenticated user"))
+			return
+		}
+
+		id, err := db.IDFromString(c.Param(opts.BoundName))
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		if id != opts.User.ID {
+			c.AbortWithError(http.StatusForbidden, errors.New("resource belongs to another user"))
+			return
+		}
+	}
+}
+
+// RequireRole ensures the authenticated user has been granted the given role.
+func RequireRole(user *db.User, role string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if user == nil {
+			abortUnauthorized(c, errors.New("no authenticated user"))
+			return
+		}
+
+		if user.Role != role {
+			c.AbortWithError(http.StatusForbidden, fmt.Errorf("%s role required", role))
+			return
+		}
+	}
+}
+
+// RequireScope ensures requests made with an API key have been granted the
+// given scope. Requests made by users are unaffected.
+func RequireScope(key *db.APIKey, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if key != nil && !key.HasScope(scope) {
+			c.AbortWithError(http.StatusForbidden, fmt.Errorf("%s scope required", scope))
+		}
+	}
+}
+
+// UnlessAPIKey runs h only if the request was not made with an API key.
+// It allows user requirements, such as RequireRole, to be declared on
+// endpoints that API keys may also call. Access for API keys is
+// governed by RequireScope instead.
+func UnlessAPIKey(key *db.APIKey, h gin.HandlerFunc) gin.HandlerFunc {
+	if key != nil {
+		return func(c *gin.Context) {}
+	}
+	return h
+}
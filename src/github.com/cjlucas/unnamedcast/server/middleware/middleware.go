@@ -64,6 +64,31 @@ func RequireExistingModel(opts *RequireExistingModelOpts) gin.HandlerFunc {
 	}
 }
 
+// ResolveFeed binds the ID of the feed given by the parameter boundName.
+// The parameter may be the ID of a feed that was merged into another, in
+// which case the ID of the feed it was merged into is bound.
+func ResolveFeed(feeds db.FeedCollection, boundName string, id *db.ID) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		feedID, err := db.IDFromString(c.Param(boundName))
+		if err != nil {
+			c.AbortWithError(http.StatusBadRequest, err)
+			return
+		}
+
+		feed, err := feeds.ResolveFeed(feedID)
+		switch {
+		case err == db.ErrNotFound:
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		case err != nil:
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		*id = feed.ID
+	}
+}
+
 func ParseQueryParams(info *queryparser.QueryParamInfo, params interface{}) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		if err := info.Parse(params, c.Request.URL.Query()); err != nil {
@@ -74,6 +99,18 @@ func ParseQueryParams(info *queryparser.QueryParamInfo, params interface{}) gin.
 	}
 }
 
+const redactBodyCtxKey = "redact_body"
+
+// RedactBody prevents LogRequest from persisting the request body.
+// It must be bound to the route ahead of authentication, rate limiting
+// and any other middleware that may abort the request, as the body is
+// logged either way.
+func RedactBody() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(redactBodyCtxKey, true)
+	}
+}
+
 func LogRequest(logs db.LogCollection, endpointCtxKey string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		body, err := ioutil.ReadAll(c.Request.Body)
@@ -95,11 +132,32 @@ func LogRequest(logs db.LogCollection, endpointCtxKey string) gin.HandlerFunc {
 			params[param.Key] = param.Value
 		}
 
+		// Never persist credentials
+		header := make(map[string][]string)
+		for k, v := range c.Request.Header {
+			header[k] = v
+		}
+		for _, k := range []string{"Authorization", "Cookie"} {
+			if _, ok := header[k]; ok {
+				header[k] = []string{"[redacted]"}
+			}
+		}
+
+		reqBody := string(body)
+		if _, ok := c.Get(redactBodyCtxKey); ok {
+			reqBody = "[redacted]"
+		}
+
+		// Endpoint will not be set if the request was aborted before
+		// reaching the endpoint (authentication failure, for example)
+		endpoint, _ := c.Get(endpointCtxKey)
+		endpointName, _ := endpoint.(string)
+
 		logs.Create(&db.Log{
 			Method:        c.Request.Method,
-			RequestHeader: c.Request.Header,
-			RequestBody:   string(body),
-			Endpoint:      c.MustGet(endpointCtxKey).(string),
+			RequestHeader: header,
+			RequestBody:   reqBody,
+			Endpoint:      endpointName,
 			Params:        params,
 			Query:         c.Request.URL.RawQuery,
 			StatusCode:    c.Writer.Status(),
@@ -0,0 +1,273 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cjlucas/unnamedcast/db"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/redis.v3"
+)
+
+// Rate describes a token bucket. The bucket holds up to Burst tokens and is
+// refilled at a rate of Requests per Per. Each request takes one token.
+type Rate struct {
+	Requests int
+	Per      time.Duration
+
+	// Burst defaults to Requests if zero
+	Burst int
+}
+
+func (r Rate) capacity() float64 {
+	if r.Burst > 0 {
+		return float64(r.Burst)
+	}
+	return float64(r.Requests)
+}
+
+// refill returns the number of tokens added to the bucket over d.
+func (r Rate) refill(d time.Duration) float64 {
+	return float64(d) * float64(r.Requests) / float64(r.Per)
+}
+
+// wait returns the time until the bucket holds one token.
+func (r Rate) wait(tokens float64) time.Duration {
+	return time.Duration(math.Ceil((1 - tokens) * float64(r.Per) / float64(r.Requests)))
+}
+
+// RateLimitStore persists token buckets.
+type RateLimitStore interface {
+	// Take removes a token from the bucket identified by key. If the bucket
+	// is empty, false is returned along with the time until a token is
+	// available.
+	Take(key string, rate Rate, now time.Time) (bool, time.Duration, error)
+}
+
+type bucket struct {
+	Rate   Rate
+	Tokens float64
+	Last   time.Time
+}
+
+// full reports whether the bucket will have refilled completely by now,
+// in which case it is indistinguishable from a new bucket.
+func (b *bucket) full(now time.Time) bool {
+	return b.Tokens+b.Rate.refill(now.Sub(b.Last)) >= b.Rate.capacity()
+}
+
+// sweepInterval is the number of calls to Take between removals of
+// full buckets from a MemoryRateLimitStore.
+const sweepInterval = 1000
+
+// MemoryRateLimitStore keeps token buckets in process. Limits are not
+// shared between replicas of the server.
+type MemoryRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	takes   int
+}
+
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*bucket)}
+}
+
+func (s *MemoryRateLimitStore) Take(key string, rate Rate, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.takes++; s.takes%sweepInterval == 0 {
+		for k, b := range s.buckets {
+			if b.full(now) {
+				delete(s.buckets, k)
+			}
+		}
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{Tokens: rate.capacity(), Last: now}
+		s.buckets[key] = b
+	}
+	b.Rate = rate
+
+	if elapsed := now.Sub(b.Last); elapsed > 0 {
+		b.Tokens = math.Min(rate.capacity(), b.Tokens+rate.refill(elapsed))
+		b.Last = now
+	}
+
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return true, 0, nil
+	}
+
+	return false, rate.wait(b.Tokens), nil
+}
+
+// takeScript is the redis equivalent of MemoryRateLimitStore.Take. It runs
+// atomically, so buckets can be shared between replicas of the server.
+var takeScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local requests = tonumber(ARGV[2])
+local per = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+
+local b = redis.call("HMGET", KEYS[1], "tokens", "last")
+local tokens = tonumber(b[1]) or capacity
+local last = tonumber(b[2]) or now
+
+if now > last then
+	tokens = math.min(capacity, tokens + (now - last) * requests / per)
+	last = now
+end
+
+local allowed = 0
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) * per / requests)
+end
+
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "last", last)
+redis.call("PEXPIRE", KEYS[1], math.ceil(capacity * per / requests))
+
+return {allowed, wait}
+`)
+
+// RedisRateLimitStore keeps token buckets in redis.
+type RedisRateLimitStore struct {
+	Client *redis.Client
+
+	// Prefix for redis keys
+	Prefix string
+}
+
+func (s *RedisRateLimitStore) Take(key string, rate Rate, now time.Time) (bool, time.Duration, error) {
+	nowMs := now.UnixNano() / int64(time.Millisecond)
+	args := []string{
+		strconv.FormatFloat(rate.capacity(), 'f', -1, 64),
+		strconv.Itoa(rate.Requests),
+		strconv.FormatInt(int64(rate.Per/time.Millisecond), 10),
+		strconv.FormatInt(nowMs, 10),
+	}
+
+	res, err := takeScript.Run(s.Client, []string{s.Prefix + key}, args).Result()
+	if err != nil {
+		return false, 0, err
+	}
+
+	vals, ok := res.([]interface{})
+	if !ok || len(vals) != 2 {
+		return false, 0, fmt.Errorf("unexpected response from redis: %v", res)
+	}
+	allowed, _ := vals[0].(int64)
+	wait, _ := vals[1].(int64)
+
+	return allowed == 1, time.Duration(wait) * time.Millisecond, nil
+}
+
+// RateLimits holds rates by endpoint name. It is safe for concurrent use,
+// so limits can be changed while requests are served.
+type RateLimits struct {
+	mu    sync.RWMutex
+	rates map[string]Rate
+}
+
+// NewRateLimits returns RateLimits holding a copy of rates.
+func NewRateLimits(rates map[string]Rate) *RateLimits {
+	l := &RateLimits{rates: make(map[string]Rate, len(rates))}
+	for name, rate := range rates {
+		l.rates[name] = rate
+	}
+	return l
+}
+
+// Get returns the rate of the endpoint with the given name.
+func (l *RateLimits) Get(name string) (Rate, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	rate, ok := l.rates[name]
+	return rate, ok
+}
+
+// Set limits the endpoint with the given name to rate.
+func (l *RateLimits) Set(name string, rate Rate) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rates[name] = rate
+}
+
+type RateLimitOpts struct {
+	Store RateLimitStore
+
+	// Limits by endpoint name. Endpoints without a limit are not limited.
+	Limits *RateLimits
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+
+	EndpointCtxKey string
+	UserCtxKey     string
+	APIKeyCtxKey   string
+}
+
+// rateLimitKey identifies the client making the request. Authenticated
+// clients are identified by their principal, all others by IP address.
+func (opts *RateLimitOpts) rateLimitKey(c *gin.Context) string {
+	if v, ok := c.Get(opts.UserCtxKey); ok {
+		if user, ok := v.(*db.User); ok {
+			return "user:" + user.ID.Hex()
+		}
+	}
+
+	if v, ok := c.Get(opts.APIKeyCtxKey); ok {
+		if key, ok := v.(*db.APIKey); ok {
+			return "api_key:" + key.ID.Hex()
+		}
+	}
+
+	return "ip:" + c.ClientIP()
+}
+
+// RateLimit limits the rate at which each client can call an endpoint.
+// It must run after the endpoint name has been stored under EndpointCtxKey.
+// Limited requests are aborted with 429 and a Retry-After header.
+func RateLimit(opts *RateLimitOpts) gin.HandlerFunc {
+	now := opts.Now
+	if now == nil {
+		now = time.Now
+	}
+
+	return func(c *gin.Context) {
+		v, _ := c.Get(opts.EndpointCtxKey)
+		endpoint, _ := v.(string)
+
+		rate, ok := opts.Limits.Get(endpoint)
+		if !ok {
+			return
+		}
+
+		key := endpoint + ":" + opts.rateLimitKey(c)
+		allowed, wait, err := opts.Store.Take(key, rate, now())
+		if err != nil {
+			c.AbortWithError(http.StatusInternalServerError, err)
+			return
+		}
+
+		if !allowed {
+			secs := int(math.Ceil(wait.Seconds()))
+			if secs < 1 {
+				secs = 1
+			}
+			c.Header("Retry-After", strconv.Itoa(secs))
+			c.AbortWithError(http.StatusTooManyRequests, errors.New("rate limit exceeded"))
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/redis.v3"
+)
+
+// testRateLimitStore checks the behavior shared by all stores.
+func testRateLimitStore(t *testing.T, store RateLimitStore, key string) {
+	now := time.Date(2017, time.March, 1, 12, 0, 0, 0, time.UTC)
+	rate := Rate{Requests: 2, Per: time.Minute, Burst: 3}
+
+	take := func(now time.Time) (bool, time.Duration) {
+		allowed, wait, err := store.Take(key, rate, now)
+		if err != nil {
+			t.Fatal("Take failed:", err)
+		}
+		return allowed, wait
+	}
+
+	for i := 0; i < 3; i++ {
+		if allowed, _ := take(now); !allowed {
+			t.Fatalf("take %d was not allowed", i)
+		}
+	}
+
+	allowed, wait := take(now)
+	if allowed {
+		t.Fatal("take from an empty bucket was allowed")
+	}
+	if wait != 30*time.Second {
+		t.Errorf("wait = %s, expected %s", wait, 30*time.Second)
+	}
+
+	// One token is refilled every 30 seconds
+	now = now.Add(30 * time.Second)
+	if allowed, _ := take(now); !allowed {
+		t.Error("take after refill was not allowed")
+	}
+	if allowed, _ := take(now); allowed {
+		t.Error("second take after refill was allowed")
+	}
+
+	// The bucket holds no more than Burst tokens
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		if allowed, _ := take(now); !allowed {
+			t.Fatalf("take %d after full refill was not allowed", i)
+		}
+	}
+	if allowed, _ := take(now); allowed {
+		t.Error("take beyond burst was allowed")
+	}
+}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	testRateLimitStore(t, NewMemoryRateLimitStore(), "test")
+}
+
+func TestRedisRateLimitStore(t *testing.T) {
+	rawurl := os.Getenv("REDIS_URL")
+	if rawurl == "" {
+		rawurl = "redis://localhost:6379"
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		t.Fatal("Invalid REDIS_URL:", err)
+	}
+	database, _ := strconv.Atoi(strings.TrimPrefix(u.Path, "/"))
+
+	client := redis.NewClient(&redis.Options{
+		Addr: u.Host,
+		DB:   int64(database),
+	})
+	defer client.Close()
+
+	if err := client.Ping().Err(); err != nil {
+		t.Skip("redis is unavailable:", err)
+	}
+
+	store := &RedisRateLimitStore{Client: client, Prefix: "ratelimit_test:"}
+	key := strconv.FormatInt(time.Now().UnixNano(), 10)
+	defer client.Del(store.Prefix + key)
+
+	testRateLimitStore(t, store, key)
+}
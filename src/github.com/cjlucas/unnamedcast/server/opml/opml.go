@@ -0,0 +1,123 @@
+// Package opml reads and writes OPML subscription lists as exported and
+// imported by podcast apps. Versions 1.0 and 2.0 are supported.
+package opml
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/cjlucas/unnamedcast/worker/rss"
+)
+
+type Document struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    Head     `xml:"head"`
+	Body    Body     `xml:"body"`
+}
+
+type Head struct {
+	Title       string `xml:"title,omitempty"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+}
+
+type Body struct {
+	Outlines []Outline `xml:"outline"`
+}
+
+// Outline is either a subscription, when XMLURL is set, or a folder of
+// further outlines.
+type Outline struct {
+	Text     string    `xml:"text,attr"`
+	Title    string    `xml:"title,attr,omitempty"`
+	Type     string    `xml:"type,attr,omitempty"`
+	XMLURL   string    `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string    `xml:"htmlUrl,attr,omitempty"`
+	Outlines []Outline `xml:"outline"`
+}
+
+// UnmarshalXML decodes an outline, matching attribute names without regard
+// to case as exporters disagree on them (xmlUrl, xmlURL, xmlurl).
+func (o *Outline) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for _, attr := range start.Attr {
+		switch strings.ToLower(attr.Name.Local) {
+		case "text":
+			o.Text = attr.Value
+		case "title":
+			o.Title = attr.Value
+		case "type":
+			o.Type = attr.Value
+		case "xmlurl":
+			o.XMLURL = strings.TrimSpace(attr.Value)
+		case "htmlurl":
+			o.HTMLURL = strings.TrimSpace(attr.Value)
+		}
+	}
+
+	var children struct {
+		Outlines []Outline `xml:"outline"`
+	}
+	if err := d.DecodeElement(&children, &start); err != nil {
+		return err
+	}
+	o.Outlines = children.Outlines
+	return nil
+}
+
+// Name returns the title of the outline, falling back to its text.
+func (o *Outline) Name() string {
+	if o.Title != "" {
+		return o.Title
+	}
+	return o.Text
+}
+
+// Parse decodes an OPML document.
+func Parse(r io.Reader) (*Document, error) {
+	dec := xml.NewDecoder(r)
+	dec.CharsetReader = rss.CharsetReader
+	dec.Strict = false
+
+	var doc Document
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if doc.XMLName.Local != "opml" {
+		return nil, errors.New("not an opml document")
+	}
+	return &doc, nil
+}
+
+// Feeds returns every outline in the document that has a feed URL, in
+// document order. Folders are flattened.
+func (d *Document) Feeds() []Outline {
+	var feeds []Outline
+	var walk func(outlines []Outline)
+	walk = func(outlines []Outline) {
+		for _, o := range outlines {
+			if o.XMLURL != "" {
+				feeds = append(feeds, o)
+			}
+			walk(o.Outlines)
+		}
+	}
+	walk(d.Body.Outlines)
+	return feeds
+}
+
+// Write encodes the document, preceded by an XML declaration.
+func (d *Document) Write(w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(d); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
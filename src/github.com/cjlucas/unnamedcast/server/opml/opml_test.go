@@ -0,0 +1,78 @@
+package opml_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cjlucas/unnamedcast/server/opml"
+)
+
+func TestParse(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="ISO-8859-1"?>
+<opml version="1.0">
+  <head><title>Podcasts</title></head>
+  <body>
+    <outline text="Caf` + "\xe9" + ` Talk" type="rss" xmlUrl=" http://example.com/cafe.xml "/>
+    <outline text="Folder">
+      <outline text="Nested" title="Nested Show" xmlURL="http://example.com/nested.xml" htmlUrl="http://example.com"/>
+      <outline text="Not a feed"/>
+    </outline>
+  </body>
+</opml>`
+
+	out, err := opml.Parse(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out.Version != "1.0" || out.Head.Title != "Podcasts" {
+		t.Errorf("Unexpected head: %s %#v", out.Version, out.Head)
+	}
+
+	feeds := out.Feeds()
+	if len(feeds) != 2 {
+		t.Fatalf("Unexpected # of feeds: %d != 2", len(feeds))
+	}
+	if feeds[0].Name() != "Café Talk" || feeds[0].XMLURL != "http://example.com/cafe.xml" {
+		t.Errorf("Unexpected feed: %#v", feeds[0])
+	}
+	if feeds[1].Name() != "Nested Show" || feeds[1].XMLURL != "http://example.com/nested.xml" || feeds[1].HTMLURL != "http://example.com" {
+		t.Errorf("Unexpected feed: %#v", feeds[1])
+	}
+}
+
+func TestParse_NotOPML(t *testing.T) {
+	for _, doc := range []string{"", "<rss></rss>", "not xml"} {
+		if _, err := opml.Parse(strings.NewReader(doc)); err == nil {
+			t.Errorf("Expected an error for %q", doc)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	in := opml.Document{
+		Version: "2.0",
+		Head:    opml.Head{Title: "Subscriptions"},
+		Body: opml.Body{Outlines: []opml.Outline{
+			{Text: "Show & Tell", Title: "Show & Tell", Type: "rss", XMLURL: "http://example.com/feed?a=1&b=2"},
+		}},
+	}
+
+	var buf bytes.Buffer
+	if err := in.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "<?xml") {
+		t.Error("XML declaration is missing")
+	}
+
+	out, err := opml.Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out.Feeds(), in.Body.Outlines) {
+		t.Errorf("Outlines mismatch: %#v != %#v", out.Feeds(), in.Body.Outlines)
+	}
+}
@@ -115,6 +115,12 @@ func (info *QueryParamInfo) Parse(instance interface{}, vals url.Values) error {
 				return err
 			}
 			f.V.SetUint(n)
+		case bool:
+			b, err := strconv.ParseBool(val)
+			if err != nil {
+				return err
+			}
+			f.V.SetBool(b)
 		case time.Time:
 			t, err := time.Parse(time.RFC3339Nano, val)
 			if err != nil {
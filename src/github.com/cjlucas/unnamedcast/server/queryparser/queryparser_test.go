@@ -117,6 +117,20 @@ func TestParseQueryParams(t *testing.T) {
 				X int
 			}{},
 		},
+		{
+			Query: "a=true&b=0",
+			Expected: struct {
+				A bool
+				B bool
+			}{A: true, B: false},
+		},
+		{
+			Query:       "x=notabool",
+			ShouldError: true,
+			Expected: struct {
+				X bool
+			}{},
+		},
 		{
 			Query:       "a=something",
 			ShouldError: true,
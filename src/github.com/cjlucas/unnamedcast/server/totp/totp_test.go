@@ -0,0 +1,81 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B test vectors, truncated to 6 digits
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	cases := []struct {
+		Unix int64
+		Code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, c := range cases {
+		code, err := Code(rfcSecret, time.Unix(c.Unix, 0))
+		if err != nil {
+			t.Fatal("Code failed:", err)
+		}
+		if code != c.Code {
+			t.Errorf("code mismatch at %d: %s != %s", c.Unix, code, c.Code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	cases := []struct {
+		Time  time.Time
+		Valid bool
+	}{
+		{now, true},
+		{now.Add(-Period), true},
+		{now.Add(Period), true},
+		{now.Add(-2 * Period), false},
+		{now.Add(2 * Period), false},
+	}
+
+	for _, c := range cases {
+		step, ok := Validate(rfcSecret, "050471", c.Time)
+		if ok != c.Valid {
+			t.Errorf("unexpected result at %s: %t != %t", c.Time, ok, c.Valid)
+		}
+		if ok && step != Step(now) {
+			t.Errorf("step mismatch: %d != %d", step, Step(now))
+		}
+	}
+
+	if _, ok := Validate(rfcSecret, "12345", now); ok {
+		t.Error("short code was accepted")
+	}
+	if _, ok := Validate("not base32!", "050471", now); ok {
+		t.Error("invalid secret was accepted")
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal("NewSecret failed:", err)
+	}
+
+	code, err := Code(secret, time.Now())
+	if err != nil {
+		t.Fatal("Code failed:", err)
+	}
+	if _, ok := Validate(secret, code, time.Now()); !ok {
+		t.Error("generated code did not validate")
+	}
+}
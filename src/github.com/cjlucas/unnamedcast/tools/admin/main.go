@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cjlucas/unnamedcast/db"
+)
+
+func usage() {
+	fmt.Println("Usage: admin <command> [args]")
+	fmt.Println()
+	fmt.Println("Commands:")
+	fmt.Println("  set-role <username> <user|admin>")
+	fmt.Printf("  create-api-key <name> <%s>...\n", strings.Join(db.Scopes, "|"))
+	fmt.Println("  find-duplicate-feeds")
+	fmt.Println("  merge-feeds <feed-id> <duplicate-feed-id>...")
+	os.Exit(1)
+}
+
+func setRole(dbConn *db.DB, args []string) error {
+	if len(args) != 2 {
+		usage()
+	}
+
+	username, role := args[0], args[1]
+	if role != db.RoleUser && role != db.RoleAdmin {
+		return fmt.Errorf("unknown role: %s", role)
+	}
+
+	var user db.User
+	cur := dbConn.Users.Find(&db.Query{
+		Filter: db.M{"username": username},
+		Limit:  1,
+	})
+	if err := cur.One(&user); err != nil {
+		return fmt.Errorf("could not find user %s: %s", username, err)
+	}
+
+	if err := dbConn.Users.SetRole(user.ID, role); err != nil {
+		return err
+	}
+
+	fmt.Printf("Set role of %s to %s\n", username, role)
+	return nil
+}
+
+func createAPIKey(dbConn *db.DB, args []string) error {
+	if len(args) < 2 {
+		usage()
+	}
+
+	_, key, err := dbConn.APIKeys.Create(args[0], args[1:])
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(key)
+	return nil
+}
+
+func findDuplicateFeeds(dbConn *db.DB, args []string) error {
+	if len(args) != 0 {
+		usage()
+	}
+
+	groups, err := dbConn.Feeds.DuplicateFeeds()
+	if err != nil {
+		return err
+	}
+
+	for _, feeds := range groups {
+		fmt.Println(feeds[0].Title)
+		for _, feed := range feeds {
+			fmt.Printf("  %s %s (created %s)\n", feed.ID.Hex(), feed.URL, feed.CreationTime.Format(time.RFC3339))
+		}
+	}
+
+	fmt.Printf("Found %d groups of duplicate feeds\n", len(groups))
+	return nil
+}
+
+func mergeFeeds(dbConn *db.DB, args []string) error {
+	if len(args) < 2 {
+		usage()
+	}
+
+	var ids []db.ID
+	for _, arg := range args {
+		id, err := db.IDFromString(arg)
+		if err != nil {
+			return fmt.Errorf("invalid feed id %s: %s", arg, err)
+		}
+		ids = append(ids, id)
+	}
+
+	for _, dupID := range ids[1:] {
+		if err := dbConn.MergeFeeds(ids[0], dupID); err != nil {
+			return fmt.Errorf("could not merge %s: %s", dupID.Hex(), err)
+		}
+		fmt.Printf("Merged %s into %s\n", dupID.Hex(), ids[0].Hex())
+	}
+
+	return nil
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	dbURL := os.Getenv("DB_URL")
+	if dbURL == "" {
+		panic("DB_URL not specified")
+	}
+	dbConn, err := db.New(db.Config{URL: dbURL})
+	if err != nil {
+		panic(fmt.Errorf("Could not connect to db: %s", err))
+	}
+
+	commands := map[string]func(*db.DB, []string) error{
+		"set-role":             setRole,
+		"create-api-key":       createAPIKey,
+		"find-duplicate-feeds": findDuplicateFeeds,
+		"merge-feeds":          mergeFeeds,
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+	}
+
+	if err := cmd(dbConn, os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -15,7 +15,12 @@ func main() {
 	apiTransport := api.API{Host: *apiHost}
 
 	fmt.Println("Creating user")
-	user, err := apiTransport.CreateUser("chris", "blah")
+	user, err := apiTransport.CreateUser("chris", "blahblah")
+	if err != nil {
+		panic(err)
+	}
+
+	apiTransport.Token, err = apiTransport.Login("chris", "blahblah")
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,193 @@
+// Package websub implements the subscriber side of WebSub
+// (https://www.w3.org/TR/websub/), formerly known as PubSubHubbub.
+package websub
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Secret returns the secret of the subscription to the feed with the given
+// ID. Secrets are derived from key so that the subscriber and the callback
+// can agree on them without storing them.
+func Secret(key []byte, feedID string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(feedID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+var signatureHashes = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// Sign returns the X-Hub-Signature header a hub would send with body.
+func Sign(secret string, body []byte) string {
+	return "sha256=" + hex.EncodeToString(signature(sha256.New, secret, body))
+}
+
+func signature(h func() hash.Hash, secret string, body []byte) []byte {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// VerifySignature reports whether the X-Hub-Signature header sig is valid
+// for body.
+func VerifySignature(secret string, body []byte, sig string) bool {
+	split := strings.SplitN(strings.TrimSpace(sig), "=", 2)
+	if len(split) != 2 {
+		return false
+	}
+
+	h, ok := signatureHashes[strings.ToLower(split[0])]
+	if !ok {
+		return false
+	}
+
+	expected, err := hex.DecodeString(split[1])
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(expected, signature(h, secret, body))
+}
+
+// Subscription is a request for a hub to deliver updates of Topic to
+// Callback.
+type Subscription struct {
+	Hub      string
+	Topic    string
+	Callback string
+	Secret   string
+
+	// LeaseSeconds is the requested length of the subscription. The hub
+	// chooses the length if it is zero.
+	LeaseSeconds int
+}
+
+// Subscribe asks the hub to create or renew the subscription. The hub
+// verifies the subscription asynchronously by calling the callback.
+func Subscribe(client *http.Client, s *Subscription) error {
+	return request(client, "subscribe", s)
+}
+
+// Unsubscribe asks the hub to end the subscription.
+func Unsubscribe(client *http.Client, s *Subscription) error {
+	return request(client, "unsubscribe", s)
+}
+
+func request(client *http.Client, mode string, s *Subscription) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	form := url.Values{
+		"hub.mode":     {mode},
+		"hub.topic":    {s.Topic},
+		"hub.callback": {s.Callback},
+	}
+	if s.Secret != "" {
+		form.Set("hub.secret", s.Secret)
+	}
+	if s.LeaseSeconds > 0 {
+		form.Set("hub.lease_seconds", strconv.Itoa(s.LeaseSeconds))
+	}
+
+	resp, err := client.PostForm(s.Hub, form)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("hub responded with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// DiscoverLinks returns the hub and self URLs given in the Link headers of
+// a response, if any.
+func DiscoverLinks(header http.Header) (hub, self string) {
+	for _, value := range header["Link"] {
+		for _, link := range splitLinks(value) {
+			target, rels := parseLink(link)
+			for _, rel := range rels {
+				switch {
+				case rel == "hub" && hub == "":
+					hub = target
+				case rel == "self" && self == "":
+					self = target
+				}
+			}
+		}
+	}
+	return hub, self
+}
+
+// splitLinks splits a Link header into its links. Commas within the
+// angle-bracketed target or within quoted parameters do not split.
+func splitLinks(value string) []string {
+	var links []string
+	inTarget, inQuote := false, false
+	start := 0
+
+	for i, r := range value {
+		switch {
+		case r == '<' && !inQuote:
+			inTarget = true
+		case r == '>' && !inQuote:
+			inTarget = false
+		case r == '"' && !inTarget:
+			inQuote = !inQuote
+		case r == ',' && !inTarget && !inQuote:
+			links = append(links, value[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(links, value[start:])
+}
+
+// parseLink parses a single link of a Link header (RFC 8288), returning
+// its target and relation types.
+func parseLink(link string) (target string, rels []string) {
+	link = strings.TrimSpace(link)
+	if !strings.HasPrefix(link, "<") {
+		return "", nil
+	}
+
+	end := strings.Index(link, ">")
+	if end == -1 {
+		return "", nil
+	}
+	target = strings.TrimSpace(link[1:end])
+
+	for _, param := range strings.Split(link[end+1:], ";") {
+		split := strings.SplitN(param, "=", 2)
+		if len(split) != 2 || !strings.EqualFold(strings.TrimSpace(split[0]), "rel") {
+			continue
+		}
+
+		value := strings.Trim(strings.TrimSpace(split[1]), `"`)
+		for _, rel := range strings.Fields(value) {
+			rels = append(rels, strings.ToLower(rel))
+		}
+	}
+
+	return target, rels
+}
@@ -0,0 +1,135 @@
+package websub_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/cjlucas/unnamedcast/websub"
+)
+
+func TestSecret(t *testing.T) {
+	key := []byte("key")
+
+	s1 := websub.Secret(key, "feed1")
+	s2 := websub.Secret(key, "feed2")
+	if s1 == s2 {
+		t.Error("Secrets of different feeds are equal")
+	}
+	if s1 != websub.Secret(key, "feed1") {
+		t.Error("Secret is not deterministic")
+	}
+	if s1 == websub.Secret([]byte("other"), "feed1") {
+		t.Error("Secrets derived from different keys are equal")
+	}
+	if len(s1) >= 200 {
+		t.Errorf("Secret is too long: %d bytes", len(s1))
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte("<rss></rss>")
+
+	cases := []struct {
+		Signature string
+		Expected  bool
+	}{
+		{websub.Sign("secret", body), true},
+		// sha1 is what most hubs still send
+		{"sha1=726615dee00060708f7ee0f32bb119b70cea7bbe", true},
+		{"SHA1=726615dee00060708f7ee0f32bb119b70cea7bbe", true},
+		{"sha1=026615dee00060708f7ee0f32bb119b70cea7bbe", false},
+		{websub.Sign("other", body), false},
+		{"md5=0123", false},
+		{"sha1=zz", false},
+		{"sha1", false},
+		{"", false},
+	}
+
+	for _, c := range cases {
+		if out := websub.VerifySignature("secret", body, c.Signature); out != c.Expected {
+			t.Errorf("VerifySignature(%q) = %t, expected %t", c.Signature, out, c.Expected)
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	var form url.Values
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		form = r.PostForm
+
+		if form.Get("hub.topic") == "http://example.com/unknown" {
+			http.Error(w, "unknown topic", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer hub.Close()
+
+	sub := websub.Subscription{
+		Hub:          hub.URL,
+		Topic:        "http://example.com/feed",
+		Callback:     "http://cast.example.com/websub/1",
+		Secret:       "secret",
+		LeaseSeconds: 3600,
+	}
+	if err := websub.Subscribe(nil, &sub); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"hub.mode":          "subscribe",
+		"hub.topic":         sub.Topic,
+		"hub.callback":      sub.Callback,
+		"hub.secret":        sub.Secret,
+		"hub.lease_seconds": "3600",
+	}
+	for k, v := range expected {
+		if form.Get(k) != v {
+			t.Errorf("%s mismatch: %q != %q", k, form.Get(k), v)
+		}
+	}
+
+	sub.Topic = "http://example.com/unknown"
+	if err := websub.Unsubscribe(nil, &sub); err == nil {
+		t.Error("Expected an error")
+	}
+	if form.Get("hub.mode") != "unsubscribe" {
+		t.Errorf("hub.mode mismatch: %s", form.Get("hub.mode"))
+	}
+}
+
+func TestDiscoverLinks(t *testing.T) {
+	cases := []struct {
+		Links []string
+		Hub   string
+		Self  string
+	}{
+		{nil, "", ""},
+		{
+			[]string{`<https://hub.example.com/>; rel="hub", <http://example.com/feed>; rel="self"`},
+			"https://hub.example.com/", "http://example.com/feed",
+		},
+		{
+			[]string{`<https://hub.example.com/>; rel=hub`, `<http://example.com/feed?a=1,2>; title="a, b"; rel="self alternate"`},
+			"https://hub.example.com/", "http://example.com/feed?a=1,2",
+		},
+		{
+			[]string{`<https://first.example.com/>; rel="HUB", <https://second.example.com/>; rel="hub"`},
+			"https://first.example.com/", "",
+		},
+		{[]string{`https://hub.example.com/; rel="hub"`}, "", ""},
+	}
+
+	for _, c := range cases {
+		header := http.Header{"Link": c.Links}
+		hub, self := websub.DiscoverLinks(header)
+		if hub != c.Hub || self != c.Self {
+			t.Errorf("DiscoverLinks(%q) = (%q, %q), expected (%q, %q)", c.Links, hub, self, c.Hub, c.Self)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"github.com/cjlucas/unnamedcast/api"
+	"github.com/cjlucas/unnamedcast/worker/podcast"
+)
+
+// itemIndex matches decoded items to the items already stored for a feed.
+// Items are matched by GUID. Items with a GUID that has not been seen
+// before are matched by media URL and then by title and publication date,
+// so that an item is not duplicated when a feed changes its GUIDs.
+type itemIndex struct {
+	byGUID     map[string]string
+	byMediaURL map[string]string
+	byContent  map[string]string
+
+	// IDs of items matched during this update. Each stored item is matched
+	// by its media URL or content at most once.
+	matched map[string]bool
+}
+
+func newItemIndex(items []api.Item) *itemIndex {
+	idx := itemIndex{
+		byGUID:     make(map[string]string),
+		byMediaURL: make(map[string]string),
+		byContent:  make(map[string]string),
+		matched:    make(map[string]bool),
+	}
+
+	idx.Load(items)
+	return &idx
+}
+
+// Load adds stored items to the index.
+func (idx *itemIndex) Load(items []api.Item) {
+	for i := range items {
+		idx.add(&items[i])
+	}
+}
+
+// add adds item to the index. Existing entries are kept, so the first
+// item added for a media URL or content key wins.
+func (idx *itemIndex) add(item *api.Item) {
+	idx.byGUID[item.GUID] = item.ID
+
+	if key := podcast.MediaURLKey(item.URL); key != "" {
+		if _, ok := idx.byMediaURL[key]; !ok {
+			idx.byMediaURL[key] = item.ID
+		}
+	}
+	if key := podcast.ContentKey(item); key != "" {
+		if _, ok := idx.byContent[key]; !ok {
+			idx.byContent[key] = item.ID
+		}
+	}
+}
+
+// Match returns the ID of the stored item that item refers to. reGUIDed
+// is true if the item was matched by something other than its GUID. An
+// empty ID is returned if item is new.
+func (idx *itemIndex) Match(item *api.Item) (id string, reGUIDed bool) {
+	if id, ok := idx.byGUID[item.GUID]; ok {
+		idx.matched[id] = true
+		return id, false
+	}
+
+	keys := []struct {
+		m   map[string]string
+		key string
+	}{
+		{idx.byMediaURL, podcast.MediaURLKey(item.URL)},
+		{idx.byContent, podcast.ContentKey(item)},
+	}
+
+	for _, k := range keys {
+		if k.key == "" {
+			continue
+		}
+		if id, ok := k.m[k.key]; ok && !idx.matched[id] {
+			idx.matched[id] = true
+			idx.byGUID[item.GUID] = id
+			return id, true
+		}
+	}
+
+	return "", false
+}
+
+// Matched reports whether the stored item with the given ID has been
+// matched to a decoded item.
+func (idx *itemIndex) Matched(id string) bool {
+	return idx.matched[id]
+}
+
+// Add adds a newly created item to the index.
+func (idx *itemIndex) Add(item *api.Item) {
+	idx.add(item)
+	idx.matched[item.ID] = true
+}
+
+// itemKeys returns the keys that the stored items matching items are
+// looked up by.
+func itemKeys(items []api.Item) *api.ItemKeys {
+	var keys api.ItemKeys
+	for i := range items {
+		item := &items[i]
+		keys.GUIDs = append(keys.GUIDs, item.GUID)
+		if key := podcast.MediaURLKey(item.URL); key != "" {
+			keys.MediaURLKeys = append(keys.MediaURLKeys, key)
+		}
+		if key := podcast.ContentKey(item); key != "" {
+			keys.ContentKeys = append(keys.ContentKeys, key)
+		}
+	}
+	return &keys
+}
@@ -0,0 +1,155 @@
+package jsonfeed
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Decoder reads a JSON Feed document, passing each item to a callback as
+// soon as it is decoded. As long as the version precedes the items, as it
+// does in practice, only one item is held in memory at a time.
+type Decoder struct {
+	// MaxItems is the maximum number of items passed to the callback.
+	// Any further items are skipped. Zero means there is no limit.
+	MaxItems int
+
+	// Skipped is the number of items skipped due to MaxItems.
+	Skipped int
+
+	dec         *json.Decoder
+	feed        Feed
+	versionSeen bool
+	buffered    []Item
+	count       int
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{dec: json.NewDecoder(r)}
+}
+
+func (d *Decoder) expectDelim(delim json.Delim) error {
+	tok, err := d.dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("expected %s but found %v", delim, tok)
+	}
+	return nil
+}
+
+func checkVersion(version string) error {
+	if !strings.HasPrefix(version, VersionPrefix) {
+		return fmt.Errorf("unsupported json feed version: %q", version)
+	}
+	return nil
+}
+
+// field decodes a top level field into the feed.
+func (d *Decoder) field(key string) error {
+	var raw json.RawMessage
+	if err := d.dec.Decode(&raw); err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(map[string]json.RawMessage{key: raw})
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(buf, &d.feed); err != nil {
+		return err
+	}
+
+	if key == "version" {
+		d.versionSeen = true
+		return checkVersion(d.feed.Version)
+	}
+	return nil
+}
+
+// emit passes an item to fn. Per the spec, items without authors inherit
+// the feed's authors.
+func (d *Decoder) emit(item *Item, fn func(*Item) error) error {
+	if item.Author == nil && len(item.Authors) == 0 {
+		item.Author = d.feed.Author
+		item.Authors = d.feed.Authors
+	}
+	return fn(item)
+}
+
+// items decodes the items array. Until the version has been seen, items
+// are buffered rather than passed to fn.
+func (d *Decoder) items(fn func(*Item) error) error {
+	if err := d.expectDelim('['); err != nil {
+		return err
+	}
+
+	for d.dec.More() {
+		if d.MaxItems > 0 && d.count >= d.MaxItems {
+			var skip json.RawMessage
+			if err := d.dec.Decode(&skip); err != nil {
+				return err
+			}
+			d.Skipped++
+			continue
+		}
+
+		var item Item
+		if err := d.dec.Decode(&item); err != nil {
+			return err
+		}
+		d.count++
+
+		if !d.versionSeen {
+			d.buffered = append(d.buffered, item)
+		} else if err := d.emit(&item, fn); err != nil {
+			return err
+		}
+	}
+
+	return d.expectDelim(']')
+}
+
+// Decode reads the document, calling fn with each item in document order.
+// Decoding stops at the first error returned by fn. The returned Feed does
+// not hold any items.
+func (d *Decoder) Decode(fn func(*Item) error) (*Feed, error) {
+	if err := d.expectDelim('{'); err != nil {
+		return nil, err
+	}
+
+	for d.dec.More() {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := tok.(string)
+
+		if key == "items" {
+			err = d.items(fn)
+		} else {
+			err = d.field(key)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := d.expectDelim('}'); err != nil {
+		return nil, err
+	}
+	if err := checkVersion(d.feed.Version); err != nil {
+		return nil, err
+	}
+
+	for i := range d.buffered {
+		if err := d.emit(&d.buffered[i], fn); err != nil {
+			return nil, err
+		}
+	}
+	d.buffered = nil
+
+	return &d.feed, nil
+}
@@ -0,0 +1,170 @@
+// Package jsonfeed parses JSON Feed documents (https://jsonfeed.org),
+// versions 1.0 and 1.1.
+package jsonfeed
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// VersionPrefix prefixes the version URL of every JSON Feed document.
+const VersionPrefix = "https://jsonfeed.org/version/"
+
+// id is a string that may be given as a JSON number, which the spec
+// requires readers to accept.
+type id string
+
+func (i *id) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*i = id(s)
+		return nil
+	}
+
+	var n json.Number
+	if err := json.Unmarshal(data, &n); err != nil {
+		return fmt.Errorf("invalid id: %s", data)
+	}
+	*i = id(n.String())
+	return nil
+}
+
+type Author struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Avatar string `json:"avatar"`
+}
+
+type Attachment struct {
+	URL               string  `json:"url"`
+	MimeType          string  `json:"mime_type"`
+	Title             string  `json:"title"`
+	SizeInBytes       int     `json:"size_in_bytes"`
+	DurationInSeconds float64 `json:"duration_in_seconds"`
+}
+
+type Item struct {
+	ID            id           `json:"id"`
+	URL           string       `json:"url"`
+	ExternalURL   string       `json:"external_url"`
+	Title         string       `json:"title"`
+	ContentHTML   string       `json:"content_html"`
+	ContentText   string       `json:"content_text"`
+	Summary       string       `json:"summary"`
+	Image         string       `json:"image"`
+	DatePublished string       `json:"date_published"`
+	DateModified  string       `json:"date_modified"`
+	Attachments   []Attachment `json:"attachments"`
+
+	// Author is deprecated in 1.1 in favor of Authors
+	Author  *Author  `json:"author"`
+	Authors []Author `json:"authors"`
+}
+
+// GUID returns the unique ID of the item.
+func (i *Item) GUID() string {
+	return string(i.ID)
+}
+
+// Media returns the attachment holding the episode's media. Audio and video
+// attachments are preferred over any other kind. Nil is returned if the
+// item has no attachments.
+func (i *Item) Media() *Attachment {
+	for k := range i.Attachments {
+		mime := i.Attachments[k].MimeType
+		if strings.HasPrefix(mime, "audio/") || strings.HasPrefix(mime, "video/") {
+			return &i.Attachments[k]
+		}
+	}
+
+	if len(i.Attachments) > 0 {
+		return &i.Attachments[0]
+	}
+	return nil
+}
+
+// AuthorNames returns the names of the item's authors.
+func (i *Item) AuthorNames() []string {
+	return authorNames(i.Author, i.Authors)
+}
+
+// Hub is an endpoint that can be used to subscribe to real-time
+// notifications of changes to the feed.
+type Hub struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+type Feed struct {
+	Version     string `json:"version"`
+	Title       string `json:"title"`
+	HomePageURL string `json:"home_page_url"`
+	FeedURL     string `json:"feed_url"`
+	Description string `json:"description"`
+	Icon        string `json:"icon"`
+	Favicon     string `json:"favicon"`
+	Language    string `json:"language"`
+	Hubs        []Hub  `json:"hubs"`
+	Items       []Item `json:"items"`
+
+	// Expired is set once the feed will no longer be updated
+	Expired bool `json:"expired"`
+
+	// Author is deprecated in 1.1 in favor of Authors
+	Author  *Author  `json:"author"`
+	Authors []Author `json:"authors"`
+}
+
+// WebSubHub returns the URL of the feed's WebSub hub, if it has one.
+func (f *Feed) WebSubHub() string {
+	for _, h := range f.Hubs {
+		if strings.EqualFold(h.Type, "websub") || strings.EqualFold(h.Type, "pubsubhubbub") {
+			return h.URL
+		}
+	}
+	return ""
+}
+
+// AuthorNames returns the names of the feed's authors.
+func (f *Feed) AuthorNames() []string {
+	return authorNames(f.Author, f.Authors)
+}
+
+func authorNames(author *Author, authors []Author) []string {
+	if len(authors) == 0 && author != nil {
+		authors = []Author{*author}
+	}
+
+	var names []string
+	for _, a := range authors {
+		if a.Name != "" {
+			names = append(names, a.Name)
+		}
+	}
+	return names
+}
+
+// Parse decodes a JSON Feed document from r. The entire document is held
+// in memory, use a Decoder to process large feeds.
+func Parse(r io.Reader) (*Feed, error) {
+	var items []Item
+	feed, err := NewDecoder(r).Decode(func(item *Item) error {
+		items = append(items, *item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	feed.Items = items
+	return feed, nil
+}
+
+// ParseDate parses an RFC 3339 date as used by date_published and
+// date_modified.
+func ParseDate(date string) (time.Time, error) {
+	return time.Parse(time.RFC3339, strings.TrimSpace(date))
+}
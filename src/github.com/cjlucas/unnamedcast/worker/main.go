@@ -12,6 +12,7 @@ import (
 	"github.com/cjlucas/koda-go"
 	"github.com/cjlucas/unnamedcast/api"
 	"github.com/cjlucas/unnamedcast/db"
+	"github.com/cjlucas/unnamedcast/worker/podcast"
 )
 
 const (
@@ -25,9 +26,15 @@ type Worker interface {
 }
 
 type Job struct {
-	KodaJob    *koda.Job
-	collection *db.JobCollection
-	dbID       db.ID
+	KodaJob     *koda.Job
+	collection  *db.JobCollection
+	dbID        db.ID
+	maxAttempts int
+}
+
+// LastAttempt reports whether the job will not be retried if it fails.
+func (j *Job) LastAttempt() bool {
+	return j.KodaJob == nil || j.KodaJob.NumAttempts >= j.maxAttempts
 }
 
 func (j *Job) Logf(format string, args ...interface{}) {
@@ -72,8 +79,9 @@ func (l *queueOptList) Set(s string) error {
 func wrapHandler(dbConn *db.DB, queue koda.Queue, f func(*Job) error) koda.HandlerFunc {
 	return func(j *koda.Job) error {
 		job := &Job{
-			KodaJob:    j,
-			collection: &dbConn.Jobs,
+			KodaJob:     j,
+			collection:  &dbConn.Jobs,
+			maxAttempts: queue.MaxAttempts,
 		}
 
 		var dbJob db.Job
@@ -89,8 +97,7 @@ func wrapHandler(dbConn *db.DB, queue koda.Queue, f func(*Job) error) koda.Handl
 		if err != nil {
 			job.Logf("Failed with error: %s", err)
 			// If job has failed on its last attempt, mark it dead
-			// Koda should provide a convenience function like LastAttempt() bool
-			if j.NumAttempts < queue.MaxAttempts {
+			if !job.LastAttempt() {
 				job.Logf("Will retry job in %s", queue.RetryInterval)
 				dbConn.Jobs.UpdateState(dbJob.ID, "queued")
 			} else {
@@ -128,7 +135,13 @@ func main() {
 	if err != nil {
 		panic(fmt.Sprintf("Invalid API_URL given: %s", apiURL))
 	}
-	api := api.API{Host: url.Host}
+
+	// The key needs the feeds, items, jobs and user_states scopes
+	apiKey := os.Getenv("API_KEY")
+	if apiKey == "" {
+		panic("API_KEY not specified")
+	}
+	api := api.API{Host: url.Host, Token: apiKey}
 
 	dbURL := os.Getenv("DB_URL")
 	if dbURL == "" {
@@ -139,9 +152,28 @@ func main() {
 		panic(fmt.Errorf("Could not connect to db: %s", err))
 	}
 
+	// Zero values fall back to podcast.DefaultLimits
+	var limits podcast.Limits
+	if val := os.Getenv("FEED_MAX_BYTES"); val != "" {
+		if limits.MaxBytes, err = strconv.ParseInt(val, 10, 64); err != nil {
+			panic(fmt.Sprintf("Invalid FEED_MAX_BYTES given: %s", val))
+		}
+	}
+	if val := os.Getenv("FEED_MAX_ITEMS"); val != "" {
+		if limits.MaxItems, err = strconv.Atoi(val); err != nil {
+			panic(fmt.Sprintf("Invalid FEED_MAX_ITEMS given: %s", val))
+		}
+	}
+
+	// Both are needed for the server to verify subscriptions
+	var webSub *WebSubConfig
+	if callbackURL, key := os.Getenv("WEBSUB_CALLBACK_URL"), os.Getenv("WEBSUB_KEY"); callbackURL != "" && key != "" {
+		webSub = &WebSubConfig{CallbackURL: callbackURL, Key: []byte(key)}
+	}
+
 	workers := map[string]Worker{
 		queueUpdateUserFeeds:   &UpdateUserFeedsWorker{API: api},
-		queueUpdateFeed:        &UpdateFeedWorker{API: api},
+		queueUpdateFeed:        &UpdateFeedWorker{API: api, Limits: limits, WebSub: webSub},
 		queueScrapeiTunesFeeds: &ScrapeiTunesFeeds{API: api},
 	}
 
@@ -0,0 +1,138 @@
+package podcast
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cjlucas/unnamedcast/api"
+)
+
+// Prefixes prepended to enclosure URLs by analytics services. Each one
+// redirects to the URL that follows it, so they can be stripped to find the
+// real location of the media. A "*" matches a single path segment, which
+// is typically a podcast specific ID.
+var trackingPrefixes = [][]string{
+	{"dts.podtrac.com", "redirect.mp3"},
+	{"dts.podtrac.com", "redirect.m4a"},
+	{"www.podtrac.com", "pts", "redirect.mp3"},
+	{"podtrac.com", "pts", "redirect.mp3"},
+	{"chtbl.com", "track", "*"},
+	{"chrt.fm", "track", "*"},
+	{"pdst.fm", "e"},
+	{"pscrb.fm", "rss", "p"},
+	{"verifi.podscribe.com", "rss", "p"},
+	{"op3.dev", "e"},
+	{"prfx.byspotify.com", "e"},
+	{"mgln.ai", "e", "*"},
+	{"arttrk.com", "p", "*"},
+	{"pfx.vpixl.com", "*"},
+	{"claritaspod.com", "measure"},
+	{"clrtpod.com", "m"},
+	{"tracking.swap.fm", "track", "*"},
+}
+
+// stripTrackingPrefix removes a single tracking prefix from path, which is
+// a URL without its scheme. ok is false if path has no tracking prefix.
+func stripTrackingPrefix(path string) (stripped string, ok bool) {
+	segments := strings.Split(path, "/")
+
+	for _, prefix := range trackingPrefixes {
+		if len(segments) <= len(prefix) {
+			continue
+		}
+
+		matched := true
+		for i, s := range prefix {
+			if i == 0 {
+				matched = strings.EqualFold(segments[i], s)
+			} else if s != "*" {
+				matched = segments[i] == s
+			}
+			if !matched {
+				break
+			}
+		}
+
+		if matched {
+			return strings.Join(segments[len(prefix):], "/"), true
+		}
+	}
+
+	return path, false
+}
+
+// MediaURLKey returns a key identifying the media at rawurl. Tracking
+// prefixes and the scheme are removed, as they are often changed without
+// the media itself changing. An empty string is returned if rawurl is not
+// an absolute URL.
+func MediaURLKey(rawurl string) string {
+	u, err := url.Parse(strings.TrimSpace(rawurl))
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	path := strings.ToLower(u.Host) + u.EscapedPath()
+	for {
+		// Some prefixes include the scheme of the URL they wrap
+		path = strings.TrimPrefix(path, "http://")
+		path = strings.TrimPrefix(path, "https://")
+
+		var ok bool
+		if path, ok = stripTrackingPrefix(path); !ok {
+			break
+		}
+	}
+
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return path
+}
+
+// ContentKey returns a key derived from an item's title and publication
+// date. An empty string is returned if the item has neither.
+func ContentKey(item *api.Item) string {
+	title := strings.TrimSpace(item.Title)
+	if title == "" && item.PublicationTime.IsZero() {
+		return ""
+	}
+
+	h := sha1.New()
+	h.Write([]byte(title))
+	h.Write([]byte{0})
+	if !item.PublicationTime.IsZero() {
+		h.Write([]byte(item.PublicationTime.UTC().Format(time.RFC3339)))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fallbackGUID returns an identifier for an item that has no GUID. The
+// item's media URL is used if it has one, otherwise a hash of its title
+// and publication date. An empty string is returned if the item has none
+// of these.
+func fallbackGUID(item *api.Item) string {
+	if key := MediaURLKey(item.URL); key != "" {
+		return "url:" + key
+	}
+	if key := ContentKey(item); key != "" {
+		return "sha1:" + key
+	}
+	return ""
+}
+
+// identifyItem gives item a fallback GUID if it does not have one,
+// returning warnings with any problem appended.
+func identifyItem(item *api.Item, warnings []string) []string {
+	if strings.TrimSpace(item.GUID) != "" {
+		return warnings
+	}
+
+	item.GUID = fallbackGUID(item)
+	if item.GUID == "" {
+		return append(warnings, "item has no GUID, media URL, title or publication date and was skipped")
+	}
+	return warnings
+}
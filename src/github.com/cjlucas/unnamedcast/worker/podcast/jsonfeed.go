@@ -0,0 +1,78 @@
+package podcast
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cjlucas/unnamedcast/api"
+	"github.com/cjlucas/unnamedcast/worker/jsonfeed"
+)
+
+func feedFromJSONFeed(doc *jsonfeed.Feed) *api.Feed {
+	var feed api.Feed
+
+	feed.Title = doc.Title
+	feed.Author = strings.Join(doc.AuthorNames(), ", ")
+	feed.ImageURL = doc.Icon
+	feed.Description = doc.Description
+	feed.Link = doc.HomePageURL
+	feed.Language = doc.Language
+	feed.Complete = doc.Expired
+	feed.HubURL = doc.WebSubHub()
+	feed.HubTopic = doc.FeedURL
+
+	return &feed
+}
+
+// itemFromJSONFeed converts a JSON Feed item. Any fields that could not be
+// parsed are described by the returned warnings.
+func itemFromJSONFeed(item *jsonfeed.Item) (api.Item, []string) {
+	var jsonItem api.Item
+	var warnings []string
+
+	jsonItem.GUID = item.GUID()
+	jsonItem.Title = item.Title
+	jsonItem.Link = item.URL
+	if jsonItem.Link == "" {
+		jsonItem.Link = item.ExternalURL
+	}
+	jsonItem.ImageURL = item.Image
+
+	// date_modified is better than nothing
+	date := item.DatePublished
+	if date == "" {
+		date = item.DateModified
+	}
+	if date == "" {
+		warnings = append(warnings, "publication date is missing")
+	} else if t, err := jsonfeed.ParseDate(date); err != nil {
+		warnings = append(warnings, fmt.Sprintf("could not parse date %q", date))
+	} else {
+		jsonItem.PublicationTime = t
+	}
+
+	// Feed authors have already been inherited by the decoder
+	jsonItem.Author = strings.Join(item.AuthorNames(), ", ")
+
+	if media := item.Media(); media != nil {
+		jsonItem.URL = media.URL
+		jsonItem.Size = media.SizeInBytes
+		jsonItem.Duration = time.Duration(media.DurationInSeconds * float64(time.Second))
+	}
+
+	jsonItem.Summary = item.Summary
+	if jsonItem.Summary == "" {
+		jsonItem.Summary = item.ContentText
+	}
+
+	jsonItem.Description = item.ContentHTML
+	if jsonItem.Description == "" {
+		jsonItem.Description = item.ContentText
+	}
+	if jsonItem.Description == "" {
+		jsonItem.Description = jsonItem.Summary
+	}
+
+	return jsonItem, warnings
+}
@@ -0,0 +1,141 @@
+// Package podcast maps RSS, Atom and JSON Feed documents onto the feeds
+// and items of the API. It is shared by the worker, which stores them, and
+// the server, which previews them.
+package podcast
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+
+	"github.com/cjlucas/unnamedcast/api"
+	"github.com/cjlucas/unnamedcast/worker/jsonfeed"
+	"github.com/cjlucas/unnamedcast/worker/rss"
+)
+
+// Limits bounds the resources used to process a single feed. Zero values
+// are replaced with the defaults.
+type Limits struct {
+	// MaxBytes is the maximum size of a feed document. Larger documents
+	// are rejected with ErrTooLarge.
+	MaxBytes int64
+
+	// MaxItems is the maximum number of items processed. Any further
+	// items are ignored.
+	MaxItems int
+}
+
+var DefaultLimits = Limits{
+	MaxBytes: 256 << 20,
+	MaxItems: 20000,
+}
+
+// WithDefaults returns l with its zero values replaced by DefaultLimits.
+func (l Limits) WithDefaults() Limits {
+	if l.MaxBytes <= 0 {
+		l.MaxBytes = DefaultLimits.MaxBytes
+	}
+	if l.MaxItems <= 0 {
+		l.MaxItems = DefaultLimits.MaxItems
+	}
+	return l
+}
+
+var ErrTooLarge = errors.New("feed exceeds maximum size")
+
+// LimitReader returns a reader that reads from r until more than n bytes
+// have been read, at which point ErrTooLarge is returned.
+func LimitReader(r io.Reader, n int64) io.Reader {
+	return &limitedReader{r: r, n: n}
+}
+
+type limitedReader struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	// Read at most one byte past the limit to detect an oversized feed
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+	if l.n < 0 {
+		return n, ErrTooLarge
+	}
+	return n, err
+}
+
+// isJSONFeed reports whether body holds a JSON Feed document. The
+// Content-Type is trusted if it names a JSON or XML type, otherwise the
+// body is sniffed.
+func isJSONFeed(contentType string, body []byte) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/feed+json", "application/json":
+		return true
+	case "application/rss+xml", "application/atom+xml", "application/xml", "text/xml":
+		return false
+	}
+
+	body = bytes.TrimLeft(body, "\xef\xbb\xbf \t\r\n")
+	return len(body) > 0 && body[0] == '{'
+}
+
+// sniffLen is the number of bytes examined by isJSONFeed.
+const sniffLen = 512
+
+// ItemFunc is called with each decoded item along with any problems found
+// while decoding it.
+type ItemFunc func(item *api.Item, warnings []string) error
+
+// Decode decodes an RSS, Atom or JSON Feed document within the given
+// limits, passing each item to fn as it is decoded. The number of items
+// skipped due to the limits is returned.
+func Decode(contentType string, r io.Reader, limits Limits, fn ItemFunc) (*api.Feed, int, error) {
+	limits = limits.WithDefaults()
+	br := bufio.NewReader(LimitReader(r, limits.MaxBytes))
+	head, _ := br.Peek(sniffLen)
+
+	if isJSONFeed(contentType, head) {
+		dec := jsonfeed.NewDecoder(br)
+		dec.MaxItems = limits.MaxItems
+		doc, err := dec.Decode(func(item *jsonfeed.Item) error {
+			out, warnings := itemFromJSONFeed(item)
+			return fn(&out, identifyItem(&out, warnings))
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+		return feedFromJSONFeed(doc), dec.Skipped, nil
+	}
+
+	dec := rss.NewDecoder(br)
+	dec.MaxItems = limits.MaxItems
+	channel, err := dec.Decode(func(item *rss.Item) error {
+		out, warnings := itemFromRSS(item)
+		return fn(&out, identifyItem(&out, warnings))
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return feedFromRSS(&rss.Document{Channel: *channel}), dec.Skipped, nil
+}
+
+// Parse parses an RSS, Atom or JSON Feed document within the default
+// limits, returning all of its items at once.
+func Parse(contentType string, r io.Reader) (*api.Feed, []api.Item, error) {
+	var items []api.Item
+	feed, _, err := Decode(contentType, r, Limits{}, func(item *api.Item, _ []string) error {
+		items = append(items, *item)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return feed, items, nil
+}
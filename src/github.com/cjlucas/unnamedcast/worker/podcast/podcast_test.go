@@ -0,0 +1,497 @@
+package podcast
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cjlucas/unnamedcast/api"
+	"github.com/cjlucas/unnamedcast/worker/rss"
+)
+
+func TestItemsFromRSSWithDescription(t *testing.T) {
+	buf, err := ioutil.ReadFile("testdata/nominal.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := rss.ParseFeed(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := itemsFromRSS(doc)
+
+	if items[0].Description != doc.Channel.Items[0].ContentEncoded {
+		t.Error("item.Description != item.ContentEncoded")
+	}
+}
+
+func TestItemsFromRSS_Atom(t *testing.T) {
+	buf, err := ioutil.ReadFile("testdata/atom.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := rss.ParseFeed(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feed := feedFromRSS(doc)
+	if feed.Title != "Example Atom Podcast" {
+		t.Errorf("Title mismatch: %s", feed.Title)
+	}
+	if feed.Author != "Jane Doe" {
+		t.Errorf("Author mismatch: %s", feed.Author)
+	}
+	if feed.ImageURL != "https://example.com/logo.png" {
+		t.Errorf("ImageURL mismatch: %s", feed.ImageURL)
+	}
+
+	items := itemsFromRSS(doc)
+	if len(items) != 2 {
+		t.Fatalf("Unexpected # of items: %d != 2", len(items))
+	}
+
+	item := items[0]
+	expected := api.Item{
+		GUID:            "urn:uuid:1225c695-cfb8-4ebb-aaaa-80da344efa6a",
+		Title:           "Episode 2: The Sequel",
+		Link:            "https://example.com/episodes/2",
+		Author:          "John Smith",
+		URL:             "https://example.com/episodes/2.mp3",
+		Size:            1337,
+		Summary:         "A short summary.",
+		Description:     `<div xmlns="http://www.w3.org/1999/xhtml"><p>Full <b>show notes</b>.</p></div>`,
+		PublicationTime: time.Date(2016, time.April, 10, 12, 0, 0, 0, time.UTC),
+		Duration:        time.Hour + 2*time.Minute + 3*time.Second,
+	}
+	item.PublicationTime = item.PublicationTime.UTC()
+	if !reflect.DeepEqual(item, expected) {
+		t.Errorf("Item mismatch:\n%#v\n!=\n%#v", item, expected)
+	}
+
+	// Entries inherit the feed author and treat rel-less links as alternate
+	item = items[1]
+	if item.Author != "Jane Doe" {
+		t.Errorf("Author mismatch: %s", item.Author)
+	}
+	if item.Link != "https://example.com/episodes/1" {
+		t.Errorf("Link mismatch: %s", item.Link)
+	}
+	if item.Title != "Episode 1: <i>Pilot</i>" {
+		t.Errorf("Title mismatch: %s", item.Title)
+	}
+	if item.PublicationTime.IsZero() {
+		t.Error("PublicationTime was not parsed from updated")
+	}
+}
+
+func TestParseFeed_UnknownFormat(t *testing.T) {
+	if _, err := rss.ParseFeed(strings.NewReader("<html></html>")); err == nil {
+		t.Error("expected error for unsupported document")
+	}
+}
+
+func TestParseFeed_JSONFeed(t *testing.T) {
+	buf, err := ioutil.ReadFile("testdata/jsonfeed.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Detected by sniffing when the Content-Type is not helpful
+	for _, contentType := range []string{"application/feed+json", "text/plain", ""} {
+		feed, items, err := Parse(contentType, bytes.NewReader(buf))
+		if err != nil {
+			t.Fatalf("Parse failed for %q: %s", contentType, err)
+		}
+
+		if feed.Title != "Example JSON Podcast" {
+			t.Errorf("Title mismatch: %s", feed.Title)
+		}
+		if feed.Author != "Jane Doe, John Smith" {
+			t.Errorf("Author mismatch: %s", feed.Author)
+		}
+		if feed.ImageURL != "https://example.org/artwork.png" {
+			t.Errorf("ImageURL mismatch: %s", feed.ImageURL)
+		}
+
+		if len(items) != 2 {
+			t.Fatalf("Unexpected # of items: %d != 2", len(items))
+		}
+
+		item := items[0]
+		item.PublicationTime = item.PublicationTime.UTC()
+		expected := api.Item{
+			GUID:            "https://example.org/episodes/2",
+			Title:           "Episode 2",
+			Link:            "https://example.org/episodes/2",
+			Author:          "Jane Doe, John Smith",
+			URL:             "https://example.org/episodes/2.m4a",
+			Size:            89970236,
+			Summary:         "A short summary.",
+			Description:     "<p>Show notes</p>",
+			PublicationTime: time.Date(2017, time.May, 17, 17, 0, 0, 0, time.UTC),
+			Duration:        6629 * time.Second,
+		}
+		if !reflect.DeepEqual(item, expected) {
+			t.Errorf("Item mismatch:\n%#v\n!=\n%#v", item, expected)
+		}
+
+		// Numeric IDs and the 1.0 author field are accepted
+		item = items[1]
+		if item.GUID != "1" {
+			t.Errorf("GUID mismatch: %s", item.GUID)
+		}
+		if item.Author != "Guest Host" {
+			t.Errorf("Author mismatch: %s", item.Author)
+		}
+		if item.Link != "https://example.org/episodes/1" {
+			t.Errorf("Link mismatch: %s", item.Link)
+		}
+		if item.Description != "Plain text notes" {
+			t.Errorf("Description mismatch: %s", item.Description)
+		}
+	}
+}
+
+func TestParseFeed_RSSContentType(t *testing.T) {
+	buf, err := ioutil.ReadFile("testdata/nominal.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, contentType := range []string{"application/rss+xml; charset=utf-8", "text/html", ""} {
+		feed, _, err := Parse(contentType, bytes.NewReader(buf))
+		if err != nil {
+			t.Fatalf("Parse failed for %q: %s", contentType, err)
+		}
+		if feed.Title != "Relay FM Master Feed" {
+			t.Errorf("Title mismatch: %s", feed.Title)
+		}
+	}
+}
+
+func TestParseFeed_PodcastNamespace(t *testing.T) {
+	buf, err := ioutil.ReadFile("testdata/podcast.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feed, items, err := Parse("application/rss+xml", bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal("Parse failed:", err)
+	}
+
+	if feed.PodcastGUID != "917393e3-1b1e-5cef-ace4-edaa54e1f810" {
+		t.Errorf("PodcastGUID mismatch: %s", feed.PodcastGUID)
+	}
+	if !feed.Locked {
+		t.Error("Expected feed to be locked")
+	}
+
+	funding := []api.Funding{{URL: "https://example.com/donate", Title: "Support the show!"}}
+	if !reflect.DeepEqual(feed.Funding, funding) {
+		t.Errorf("Funding mismatch: %#v != %#v", feed.Funding, funding)
+	}
+
+	persons := []api.Person{{
+		Name:     "Jane Doe",
+		Role:     "host",
+		ImageURL: "https://example.com/jane.jpg",
+		URL:      "https://example.com/jane",
+	}}
+	if !reflect.DeepEqual(feed.Persons, persons) {
+		t.Errorf("Persons mismatch: %#v != %#v", feed.Persons, persons)
+	}
+
+	if len(items) != 2 {
+		t.Fatalf("Unexpected # of items: %d != 2", len(items))
+	}
+
+	item := items[0]
+	transcripts := []api.Transcript{
+		{URL: "https://example.com/episodes/3.vtt", Type: "text/vtt", Language: "en", Rel: "captions"},
+		{URL: "https://example.com/episodes/3.json", Type: "application/json"},
+	}
+	if !reflect.DeepEqual(item.Transcripts, transcripts) {
+		t.Errorf("Transcripts mismatch: %#v != %#v", item.Transcripts, transcripts)
+	}
+
+	chapters := &api.Chapters{URL: "https://example.com/episodes/3/chapters.json", Type: "application/json+chapters"}
+	if !reflect.DeepEqual(item.Chapters, chapters) {
+		t.Errorf("Chapters mismatch: %#v != %#v", item.Chapters, chapters)
+	}
+
+	persons = []api.Person{{Name: "John Smith", Role: "guest", Group: "cast"}}
+	if !reflect.DeepEqual(item.Persons, persons) {
+		t.Errorf("Persons mismatch: %#v != %#v", item.Persons, persons)
+	}
+
+	if item.Season != 2 || item.SeasonName != "Volume One" {
+		t.Errorf("Season mismatch: %d (%s)", item.Season, item.SeasonName)
+	}
+	if item.Episode != 3.5 || item.EpisodeDisplay != "Ch. 3" {
+		t.Errorf("Episode mismatch: %v (%s)", item.Episode, item.EpisodeDisplay)
+	}
+
+	// Items without podcast elements are left empty
+	item = items[1]
+	if item.Transcripts != nil || item.Chapters != nil || item.Persons != nil {
+		t.Errorf("Unexpected podcast elements: %#v", item)
+	}
+}
+
+func TestParseFeed_ITunesMetadata(t *testing.T) {
+	buf, err := ioutil.ReadFile("testdata/itunes.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feed, items, err := Parse("application/rss+xml", bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal("Parse failed:", err)
+	}
+
+	checks := []struct {
+		Name     string
+		Actual   interface{}
+		Expected interface{}
+	}{
+		{"Description", feed.Description, "A show told in order."},
+		{"Link", feed.Link, "https://example.com/show"},
+		{"Language", feed.Language, "en-us"},
+		{"Copyright", feed.Copyright, "© 2017 Example"},
+		{"Explicit", feed.Explicit, true},
+		{"Type", feed.Type, "serial"},
+		{"Owner.Name", feed.Owner.Name, "Jane Doe"},
+		{"Owner.Email", feed.Owner.Email, "jane@example.com"},
+		{"Blocked", feed.Blocked, true},
+		{"Complete", feed.Complete, true},
+		{"URL", feed.URL, "https://example.com/new-feed.xml"},
+	}
+	for _, c := range checks {
+		if !reflect.DeepEqual(c.Actual, c.Expected) {
+			t.Errorf("%s mismatch: %#v != %#v", c.Name, c.Actual, c.Expected)
+		}
+	}
+
+	categories := []api.Category{
+		{Name: "Society & Culture", Subcategories: []string{"Documentary", "History"}},
+		{Name: "News"},
+	}
+	if !reflect.DeepEqual(feed.Categories, categories) {
+		t.Errorf("Categories mismatch: %#v != %#v", feed.Categories, categories)
+	}
+	if feed.Category.Name != "Society & Culture" || len(feed.Category.Subcategories) != 2 {
+		t.Errorf("Category mismatch: %#v", feed.Category)
+	}
+
+	if len(items) != 2 {
+		t.Fatalf("Unexpected # of items: %d != 2", len(items))
+	}
+
+	item := items[0]
+	if item.EpisodeType != "full" || item.Season != 2 || item.Episode != 1 || item.Explicit {
+		t.Errorf("Unexpected episode metadata: %q %d %v %v", item.EpisodeType, item.Season, item.Episode, item.Explicit)
+	}
+
+	item = items[1]
+	if item.EpisodeType != "trailer" || item.Season != 0 || item.Episode != 0 || !item.Explicit {
+		t.Errorf("Unexpected episode metadata: %q %d %v %v", item.EpisodeType, item.Season, item.Episode, item.Explicit)
+	}
+}
+
+func TestFeedFromRSS_NoSubcategories(t *testing.T) {
+	buf, err := ioutil.ReadFile("testdata/nominal.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	feed, _, err := Parse("application/rss+xml", bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal("Parse failed:", err)
+	}
+
+	if feed.Category.Name != "Technology" {
+		t.Errorf("Category mismatch: %s", feed.Category.Name)
+	}
+	if len(feed.Category.Subcategories) != 0 {
+		t.Errorf("Unexpected subcategories: %#v", feed.Category.Subcategories)
+	}
+}
+
+func TestDecodeFeed_Limits(t *testing.T) {
+	for _, name := range []string{"nominal.xml", "jsonfeed.json"} {
+		buf, err := ioutil.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		n := 0
+		_, skipped, err := Decode("", bytes.NewReader(buf), Limits{MaxItems: 1}, func(item *api.Item, _ []string) error {
+			n++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Decode failed for %s: %s", name, err)
+		}
+		if n != 1 || skipped == 0 {
+			t.Errorf("Item limit not enforced for %s: %d decoded, %d skipped", name, n, skipped)
+		}
+
+		limits := Limits{MaxBytes: int64(len(buf) / 2)}
+		_, _, err = Decode("", bytes.NewReader(buf), limits, func(item *api.Item, _ []string) error { return nil })
+		if err != ErrTooLarge {
+			t.Errorf("Unexpected error for %s: %v", name, err)
+		}
+
+		limits.MaxBytes = int64(len(buf))
+		_, _, err = Decode("", bytes.NewReader(buf), limits, func(item *api.Item, _ []string) error { return nil })
+		if err != nil {
+			t.Errorf("Decode failed for %s at exactly the limit: %s", name, err)
+		}
+	}
+}
+
+func TestDecodeFeed_JSONFeedVersionLast(t *testing.T) {
+	doc := `{
+		"items": [{"id": "1"}, {"id": "2", "authors": [{"name": "Guest"}]}],
+		"authors": [{"name": "Host"}],
+		"version": "https://jsonfeed.org/version/1.1"
+	}`
+
+	_, items, err := Parse("application/feed+json", strings.NewReader(doc))
+	if err != nil {
+		t.Fatal("Parse failed:", err)
+	}
+
+	if len(items) != 2 || items[0].Author != "Host" || items[1].Author != "Guest" {
+		t.Errorf("Unexpected items: %#v", items)
+	}
+
+	doc = `{"items": [{"id": "1"}], "version": "https://example.com/"}`
+	_, _, err = Parse("application/feed+json", strings.NewReader(doc))
+	if err == nil {
+		t.Error("Expected error for unknown version")
+	}
+}
+
+func TestItemFromRSS_Warnings(t *testing.T) {
+	cases := []struct {
+		Item     rss.Item
+		Warnings int
+	}{
+		{rss.Item{PublicationDate: "Mon, 11 Apr 2016 01:15:00 GMT", Duration: "1:02:03"}, 0},
+		{rss.Item{Duration: "1:02:03"}, 1},
+		{rss.Item{PublicationDate: "yesterday"}, 1},
+		{rss.Item{PublicationDate: "Mon, 11 Apr 2016 01:15:00 GMT", Duration: "an hour"}, 1},
+	}
+
+	for _, c := range cases {
+		item, warnings := itemFromRSS(&c.Item)
+		if len(warnings) != c.Warnings {
+			t.Errorf("Unexpected warnings for %#v: %v", c.Item, warnings)
+		}
+		if item.PublicationTime.IsZero() && len(warnings) == 0 {
+			t.Errorf("Zero publication time without a warning for %#v", c.Item)
+		}
+	}
+}
+
+func TestMediaURLKey(t *testing.T) {
+	const key = "example.com/episodes/1.mp3"
+
+	cases := []struct {
+		URL      string
+		Expected string
+	}{
+		{"http://example.com/episodes/1.mp3", key},
+		{"https://EXAMPLE.com/episodes/1.mp3", key},
+		{"https://dts.podtrac.com/redirect.mp3/example.com/episodes/1.mp3", key},
+		{"https://chtbl.com/track/ABC123/example.com/episodes/1.mp3", key},
+		{"https://pdst.fm/e/chtbl.com/track/ABC123/https://example.com/episodes/1.mp3", key},
+		{"https://example.com/episodes/1.mp3?source=rss", key + "?source=rss"},
+		{"https://example.com/track/ABC123/episodes/1.mp3", "example.com/track/ABC123/episodes/1.mp3"},
+		{"/episodes/1.mp3", ""},
+		{"", ""},
+	}
+
+	for _, c := range cases {
+		if out := MediaURLKey(c.URL); out != c.Expected {
+			t.Errorf("MediaURLKey(%q) = %q, expected %q", c.URL, out, c.Expected)
+		}
+	}
+}
+
+func TestParseFeed_MissingGUIDs(t *testing.T) {
+	const doc = `<rss><channel>
+		<item><title>Episode 1</title><enclosure url="https://dts.podtrac.com/redirect.mp3/example.com/1.mp3"/></item>
+		<item><title>Episode 2</title><pubDate>Mon, 11 Apr 2016 01:15:00 GMT</pubDate></item>
+		<item><title>Episode 3</title><pubDate>Mon, 18 Apr 2016 01:15:00 GMT</pubDate></item>
+		<item><guid> </guid></item>
+	</channel></rss>`
+
+	_, items, err := Parse("", strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if items[0].GUID != "url:example.com/1.mp3" {
+		t.Errorf("GUID mismatch: %s", items[0].GUID)
+	}
+	if !strings.HasPrefix(items[1].GUID, "sha1:") || items[1].GUID == items[2].GUID {
+		t.Errorf("Expected distinct hashed GUIDs: %s, %s", items[1].GUID, items[2].GUID)
+	}
+	if items[3].GUID != "" {
+		t.Errorf("Expected an empty GUID: %q", items[3].GUID)
+	}
+}
+
+func TestParseFeed_WebSubHub(t *testing.T) {
+	cases := []struct {
+		Doc   string
+		Hub   string
+		Topic string
+	}{
+		{
+			`<rss xmlns:atom="http://www.w3.org/2005/Atom"><channel>
+				<link>http://example.com</link>
+				<atom:link rel="self" href="http://example.com/feed.xml"/>
+				<atom:link rel="hub" href="https://pubsubhubbub.appspot.com/"/>
+			</channel></rss>`,
+			"https://pubsubhubbub.appspot.com/", "http://example.com/feed.xml",
+		},
+		{
+			`<feed xmlns="http://www.w3.org/2005/Atom">
+				<link rel="hub" href="https://hub.example.com/"/>
+				<link rel="self" href="http://example.com/atom.xml"/>
+			</feed>`,
+			"https://hub.example.com/", "http://example.com/atom.xml",
+		},
+		{
+			`{"version": "https://jsonfeed.org/version/1.1", "feed_url": "http://example.com/feed.json",
+			  "hubs": [{"type": "rssCloud", "url": "http://cloud.example.com"}, {"type": "WebSub", "url": "https://hub.example.com/"}],
+			  "items": []}`,
+			"https://hub.example.com/", "http://example.com/feed.json",
+		},
+		{`<rss><channel><link>http://example.com</link></channel></rss>`, "", ""},
+	}
+
+	for _, c := range cases {
+		feed, _, err := Parse("", strings.NewReader(c.Doc))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if feed.HubURL != c.Hub || feed.HubTopic != c.Topic {
+			t.Errorf("Hub mismatch: (%q, %q) != (%q, %q)", feed.HubURL, feed.HubTopic, c.Hub, c.Topic)
+		}
+		if c.Hub == "" && feed.Link != "http://example.com" {
+			t.Errorf("Link mismatch: %s", feed.Link)
+		}
+	}
+}
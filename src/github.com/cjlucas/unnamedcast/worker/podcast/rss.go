@@ -0,0 +1,171 @@
+package podcast
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/cjlucas/unnamedcast/api"
+	"github.com/cjlucas/unnamedcast/worker/rss"
+)
+
+func feedFromRSS(doc *rss.Document) *api.Feed {
+	channel := doc.Channel
+	var feed api.Feed
+
+	feed.Title = channel.Title
+	feed.ImageURL = channel.Image.URL
+	feed.Author = channel.Author
+	feed.Link = channel.Link()
+	feed.Language = strings.TrimSpace(channel.Language)
+	feed.Copyright = strings.TrimSpace(channel.Copyright)
+	feed.Explicit = rss.ParseExplicit(channel.ITunesExplicit)
+	feed.Type = strings.ToLower(strings.TrimSpace(channel.ITunesType))
+	feed.Owner.Name = strings.TrimSpace(channel.ITunesOwner.Name)
+	feed.Owner.Email = strings.TrimSpace(channel.ITunesOwner.Email)
+
+	feed.Blocked = rss.ParseYes(channel.ITunesBlock)
+	feed.Complete = rss.ParseYes(channel.ITunesComplete)
+
+	// Set only if the feed has moved. The worker decides whether to honor it.
+	feed.URL = strings.TrimSpace(channel.ITunesNewFeedURL)
+
+	feed.Description = strings.TrimSpace(channel.Description)
+	if feed.Description == "" {
+		feed.Description = strings.TrimSpace(channel.ITunesSummary)
+	}
+
+	for _, c := range channel.Categories {
+		category := api.Category{Name: c.Name}
+		for _, sub := range c.Subcategories {
+			category.Subcategories = append(category.Subcategories, sub.Name)
+		}
+		feed.Categories = append(feed.Categories, category)
+	}
+
+	if len(feed.Categories) > 0 {
+		feed.Category.Name = feed.Categories[0].Name
+		feed.Category.Subcategories = feed.Categories[0].Subcategories
+	}
+
+	feed.HubURL = channel.AtomLink("hub")
+	feed.HubTopic = channel.AtomLink("self")
+
+	feed.PodcastGUID = strings.TrimSpace(channel.PodcastGUID)
+	feed.Locked = channel.PodcastLocked.IsLocked()
+	feed.Persons = personsFromRSS(channel.PodcastPersons)
+	for _, f := range channel.PodcastFunding {
+		feed.Funding = append(feed.Funding, api.Funding{
+			URL:   f.URL,
+			Title: strings.TrimSpace(f.Title),
+		})
+	}
+
+	return &feed
+}
+
+func personsFromRSS(persons []rss.PodcastPerson) []api.Person {
+	var out []api.Person
+	for _, p := range persons {
+		out = append(out, api.Person{
+			Name:     strings.TrimSpace(p.Name),
+			Role:     p.Role,
+			Group:    p.Group,
+			ImageURL: p.Image,
+			URL:      p.Href,
+		})
+	}
+	return out
+}
+
+func itemsFromRSS(doc *rss.Document) []api.Item {
+	items := make([]api.Item, len(doc.Channel.Items))
+	for i := range doc.Channel.Items {
+		items[i], _ = itemFromRSS(&doc.Channel.Items[i])
+	}
+
+	return items
+}
+
+// itemFromRSS converts an RSS item. Any fields that could not be parsed
+// are described by the returned warnings.
+func itemFromRSS(item *rss.Item) (api.Item, []string) {
+	var jsonItem api.Item
+	var warnings []string
+
+	jsonItem.GUID = item.GUID
+	jsonItem.Title = item.Title
+	jsonItem.Author = item.Author
+	jsonItem.URL = item.Enclosure.URL
+	jsonItem.Size = item.Enclosure.Length
+
+	if item.PublicationDate == "" {
+		warnings = append(warnings, "publication date is missing")
+	} else if t, err := rss.ParseDate(item.PublicationDate); err != nil {
+		warnings = append(warnings, err.Error())
+	} else {
+		jsonItem.PublicationTime = t
+	}
+
+	if d, err := rss.ParseDuration(item.Duration); err != nil {
+		warnings = append(warnings, err.Error())
+	} else {
+		jsonItem.Duration = d
+	}
+
+	jsonItem.ImageURL = item.Image.URL
+	jsonItem.Link = item.Link
+
+	for _, t := range item.PodcastTranscripts {
+		jsonItem.Transcripts = append(jsonItem.Transcripts, api.Transcript{
+			URL:      t.URL,
+			Type:     t.Type,
+			Language: t.Language,
+			Rel:      t.Rel,
+		})
+	}
+	if c := item.PodcastChapters; c != nil {
+		jsonItem.Chapters = &api.Chapters{URL: c.URL, Type: c.Type}
+	}
+	jsonItem.Persons = personsFromRSS(item.PodcastPersons)
+	jsonItem.Season = item.PodcastSeason.Number()
+	jsonItem.SeasonName = item.PodcastSeason.Name
+	jsonItem.Episode = item.PodcastEpisode.Number()
+	jsonItem.EpisodeDisplay = item.PodcastEpisode.Display
+
+	jsonItem.EpisodeType = strings.ToLower(strings.TrimSpace(item.ITunesEpisodeType))
+	jsonItem.Explicit = rss.ParseExplicit(item.ITunesExplicit)
+	if jsonItem.Season == 0 {
+		jsonItem.Season, _ = strconv.Atoi(strings.TrimSpace(item.ITunesSeason))
+	}
+	if jsonItem.Episode == 0 {
+		n, _ := strconv.Atoi(strings.TrimSpace(item.ITunesEpisode))
+		jsonItem.Episode = float64(n)
+	}
+
+	// Choose one description and one summary
+	// break when first preferred description is found
+
+	chooseOne := func(s *string, choices []string) {
+		for _, c := range choices {
+			if c != "" {
+				*s = c
+				break
+			}
+		}
+	}
+
+	chooseOne(&jsonItem.Summary, []string{
+		item.ITunesSummary,
+		item.ITunesSubtitle,
+		item.Description,
+	})
+
+	chooseOne(&jsonItem.Description, []string{
+		item.ContentEncoded,
+		item.ITunesSummary,
+		item.Description,
+		jsonItem.Summary,
+	})
+
+	return jsonItem, warnings
+}
@@ -0,0 +1,171 @@
+package rss
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// The subset of Atom 1.0 (RFC 4287) needed to populate a Channel.
+// iTunes extension elements are honored as they are in RSS feeds.
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr"`
+	Length int    `xml:"length,attr"`
+}
+
+type atomText struct {
+	Type     string `xml:"type,attr"`
+	Text     string `xml:",chardata"`
+	InnerXML string `xml:",innerxml"`
+}
+
+// String returns the text content. XHTML content is returned as markup.
+func (t *atomText) String() string {
+	if t.Type == "xhtml" {
+		return strings.TrimSpace(t.InnerXML)
+	}
+	return strings.TrimSpace(t.Text)
+}
+
+type atomImage struct {
+	URL string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	ID        string       `xml:"id"`
+	Title     atomText     `xml:"title"`
+	Links     []atomLink   `xml:"link"`
+	Authors   []atomPerson `xml:"author"`
+	Summary   atomText     `xml:"summary"`
+	Content   atomText     `xml:"content"`
+	Published string       `xml:"published"`
+	Updated   string       `xml:"updated"`
+
+	ITunesSubtitle string    `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd subtitle"`
+	ITunesSummary  string    `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd summary"`
+	ITunesDuration string    `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	ITunesImage    atomImage `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+
+	ITunesEpisodeType string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episodeType"`
+	ITunesSeason      string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd season"`
+	ITunesEpisode     string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episode"`
+	ITunesExplicit    string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd explicit"`
+}
+
+type atomFeed struct {
+	Title    atomText     `xml:"title"`
+	Subtitle atomText     `xml:"subtitle"`
+	Rights   atomText     `xml:"rights"`
+	Links    []atomLink   `xml:"link"`
+	Authors  []atomPerson `xml:"author"`
+	Logo     string       `xml:"logo"`
+	Icon     string       `xml:"icon"`
+
+	ITunesAuthor     string           `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author"`
+	ITunesImage      atomImage        `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	ITunesCategories []ITunesCategory `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd category"`
+	ITunesExplicit   string           `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd explicit"`
+	ITunesType       string           `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd type"`
+}
+
+func firstNonEmpty(choices ...string) string {
+	for _, s := range choices {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+func atomAuthor(authors []atomPerson) string {
+	names := make([]string, 0, len(authors))
+	for _, a := range authors {
+		if name := strings.TrimSpace(a.Name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// atomLinkRel returns the first link with the given relation. Per RFC 4287,
+// links without a rel attribute are alternate links.
+func atomLinkRel(links []atomLink, rel string) *atomLink {
+	for i := range links {
+		l := &links[i]
+		if l.Rel == rel || (l.Rel == "" && rel == "alternate") {
+			return l
+		}
+	}
+	return nil
+}
+
+func (e *atomEntry) item(feedAuthor string) Item {
+	var item Item
+
+	item.GUID = strings.TrimSpace(e.ID)
+	item.Title = e.Title.String()
+	item.Author = firstNonEmpty(atomAuthor(e.Authors), feedAuthor)
+	item.Description = e.Summary.String()
+	item.ContentEncoded = e.Content.String()
+	item.ITunesSubtitle = e.ITunesSubtitle
+	item.ITunesSummary = e.ITunesSummary
+	item.PublicationDate = strings.TrimSpace(firstNonEmpty(e.Published, e.Updated))
+	item.Duration = e.ITunesDuration
+	item.Image.URL = e.ITunesImage.URL
+	item.ITunesEpisodeType = e.ITunesEpisodeType
+	item.ITunesSeason = e.ITunesSeason
+	item.ITunesEpisode = e.ITunesEpisode
+	item.ITunesExplicit = e.ITunesExplicit
+
+	if l := atomLinkRel(e.Links, "alternate"); l != nil {
+		item.Link = l.Href
+	}
+
+	if l := atomLinkRel(e.Links, "enclosure"); l != nil {
+		item.Enclosure.URL = l.Href
+		item.Enclosure.Length = l.Length
+		item.Enclosure.Type = l.Type
+	}
+
+	return item
+}
+
+func (f *atomFeed) author() string {
+	return firstNonEmpty(f.ITunesAuthor, atomAuthor(f.Authors))
+}
+
+func (f *atomFeed) channel() Channel {
+	var channel Channel
+
+	channel.Title = f.Title.String()
+	channel.Author = f.author()
+	channel.Image.URL = strings.TrimSpace(firstNonEmpty(f.ITunesImage.URL, f.Logo, f.Icon))
+	channel.Description = f.Subtitle.String()
+	channel.Copyright = f.Rights.String()
+	channel.Categories = f.ITunesCategories
+	channel.ITunesExplicit = f.ITunesExplicit
+	channel.ITunesType = f.ITunesType
+
+	if l := atomLinkRel(f.Links, "alternate"); l != nil {
+		channel.Links = []rssLink{{Href: l.Href}}
+	}
+
+	// Kept as atom:links, as they would be found in an RSS feed
+	for _, rel := range []string{"hub", "self"} {
+		if l := atomLinkRel(f.Links, rel); l != nil {
+			channel.Links = append(channel.Links, rssLink{
+				XMLName:  xml.Name{Space: atomNamespace, Local: "link"},
+				Rel:      rel,
+				HrefAttr: l.Href,
+			})
+		}
+	}
+
+	return channel
+}
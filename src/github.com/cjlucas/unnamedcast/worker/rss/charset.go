@@ -0,0 +1,126 @@
+package rss
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Legacy single-byte charsets. Bytes below 0x80 are ASCII in all of them,
+// so each table maps the upper half of the byte range to runes.
+
+var latin1 = func() *[128]rune {
+	var t [128]rune
+	for i := range t {
+		t[i] = rune(0x80 + i)
+	}
+	return &t
+}()
+
+// windows1252 differs from ISO-8859-1 in the 0x80-0x9F range, which holds
+// punctuation (curly quotes, dashes, etc.) instead of control characters.
+// The five unassigned bytes are mapped to the C1 controls, as browsers do.
+var windows1252 = func() *[128]rune {
+	t := *latin1
+	copy(t[:0x20], []rune{
+		'€', '\u0081', '‚', 'ƒ', '„', '…', '†', '‡',
+		'ˆ', '‰', 'Š', '‹', 'Œ', '\u008d', 'Ž', '\u008f',
+		'\u0090', '‘', '’', '“', '”', '•', '–', '—',
+		'˜', '™', 'š', '›', 'œ', '\u009d', 'ž', 'Ÿ',
+	})
+	return &t
+}()
+
+// iso885915 replaces eight ISO-8859-1 characters, adding the euro sign.
+var iso885915 = func() *[128]rune {
+	t := *latin1
+	for b, r := range map[byte]rune{
+		0xa4: '€', 0xa6: 'Š', 0xa8: 'š', 0xb4: 'Ž',
+		0xb8: 'ž', 0xbc: 'Œ', 0xbd: 'œ', 0xbe: 'Ÿ',
+	} {
+		t[b-0x80] = r
+	}
+	return &t
+}()
+
+var charsets = map[string]*[128]rune{
+	"iso-8859-1": latin1,
+	"iso8859-1":  latin1,
+	"iso_8859-1": latin1,
+	"latin1":     latin1,
+	"latin-1":    latin1,
+	"l1":         latin1,
+
+	// Most documents labelled as ASCII are really Windows-1252
+	"us-ascii": windows1252,
+	"ascii":    windows1252,
+
+	"windows-1252": windows1252,
+	"cp1252":       windows1252,
+	"x-cp1252":     windows1252,
+
+	"iso-8859-15": iso885915,
+	"iso8859-15":  iso885915,
+	"latin-9":     iso885915,
+	"latin9":      iso885915,
+}
+
+type charsetReader struct {
+	r     *bufio.Reader
+	table *[128]rune
+
+	// Encoded bytes not yet returned by Read
+	pending []byte
+	buf     [utf8.UTFMax]byte
+}
+
+func (r *charsetReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.pending) > 0 {
+			c := copy(p[n:], r.pending)
+			r.pending = r.pending[c:]
+			n += c
+			continue
+		}
+
+		// Avoid blocking once some data is available
+		if n > 0 && r.r.Buffered() == 0 {
+			break
+		}
+
+		b, err := r.r.ReadByte()
+		if err != nil {
+			return n, err
+		}
+
+		if b < utf8.RuneSelf {
+			p[n] = b
+			n++
+			continue
+		}
+
+		size := utf8.EncodeRune(r.buf[:], r.table[b-0x80])
+		r.pending = r.buf[:size]
+	}
+
+	return n, nil
+}
+
+// CharsetReader returns a reader that converts input from the given
+// charset to UTF-8. It is suitable for use as xml.Decoder.CharsetReader.
+func CharsetReader(charset string, input io.Reader) (io.Reader, error) {
+	label := strings.ToLower(strings.TrimSpace(charset))
+	if label == "utf-8" || label == "utf8" {
+		return input, nil
+	}
+
+	table, ok := charsets[label]
+	if !ok {
+		return nil, fmt.Errorf("unsupported charset: %s", charset)
+	}
+
+	return &charsetReader{r: bufio.NewReader(input), table: table}, nil
+}
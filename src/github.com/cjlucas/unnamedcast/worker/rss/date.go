@@ -0,0 +1,175 @@
+package rss
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Feeds in the wild use many variations of the RFC 822 dates required by
+// RSS. Before parsing, dates are normalized: the weekday is dropped (it is
+// often misspelled or wrong) and named zones are replaced with numeric
+// offsets, since time.Parse gives unknown zone abbreviations an offset of
+// zero.
+
+var weekdayRegexp = regexp.MustCompile(`^[A-Za-z]+\.?,?\s*(\d)`)
+
+// Trailing comments such as "-0700 (PDT)"
+var zoneCommentRegexp = regexp.MustCompile(`\s*\([^)]*\)$`)
+
+// Offsets such as "+05:30"
+var colonOffsetRegexp = regexp.MustCompile(`([+-]\d\d):(\d\d)$`)
+
+// Zone abbreviations seen in feeds. Where an abbreviation is ambiguous,
+// the RFC 822 (North American) meaning is used.
+var zoneOffsets = map[string]string{
+	"UT":   "+0000",
+	"UTC":  "+0000",
+	"GMT":  "+0000",
+	"Z":    "+0000",
+	"EST":  "-0500",
+	"EDT":  "-0400",
+	"CST":  "-0600",
+	"CDT":  "-0500",
+	"MST":  "-0700",
+	"MDT":  "-0600",
+	"PST":  "-0800",
+	"PDT":  "-0700",
+	"AKST": "-0900",
+	"AKDT": "-0800",
+	"HST":  "-1000",
+	"BST":  "+0100",
+	"CET":  "+0100",
+	"CEST": "+0200",
+	"EET":  "+0200",
+	"EEST": "+0300",
+	"JST":  "+0900",
+	"AEST": "+1000",
+	"AEDT": "+1100",
+}
+
+// Layouts tried after normalization. Fractional seconds are accepted by
+// time.Parse even when the layout does not include them. Dates without a
+// zone are taken to be UTC.
+var dateLayouts = []string{
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04 -0700",
+	"2 Jan 06 15:04:05 -0700",
+	"2 Jan 06 15:04 -0700",
+	"2 January 2006 15:04:05 -0700",
+	"2 January 2006 15:04 -0700",
+	"2 Jan 2006 15:04:05",
+	"2 Jan 2006 15:04",
+	"2 Jan 06 15:04:05",
+	"2 January 2006 15:04:05",
+	"2 Jan 2006",
+	"2 January 2006",
+	"2006-01-02 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+func normalizeDate(date string) (string, error) {
+	date = strings.Join(strings.Fields(date), " ")
+	date = weekdayRegexp.ReplaceAllString(date, "$1")
+	date = zoneCommentRegexp.ReplaceAllString(date, "")
+	date = colonOffsetRegexp.ReplaceAllString(date, "$1$2")
+
+	i := strings.LastIndex(date, " ")
+	if i == -1 {
+		return date, nil
+	}
+
+	zone := date[i+1:]
+	if strings.IndexFunc(zone, isLetter) == -1 {
+		return date, nil
+	}
+
+	offset, ok := zoneOffsets[strings.ToUpper(zone)]
+	if !ok {
+		// Month names are letters too
+		if _, err := time.Parse("Jan", zone); err == nil {
+			return date, nil
+		}
+		if _, err := time.Parse("January", zone); err == nil {
+			return date, nil
+		}
+		return "", fmt.Errorf("unknown time zone %q", zone)
+	}
+
+	return date[:i+1] + offset, nil
+}
+
+func isLetter(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+// ParseDate parses a publication date. RFC 3339 dates are accepted along
+// with RFC 822 dates and their common variations: missing or misspelled
+// weekdays, two digit years, named zones, missing zones and fractional
+// seconds.
+func ParseDate(date string) (time.Time, error) {
+	date = strings.TrimSpace(date)
+	if date == "" {
+		return time.Time{}, errors.New("date is empty")
+	}
+
+	if t, err := time.Parse(time.RFC3339, date); err == nil {
+		return t, nil
+	}
+
+	normalized, err := normalizeDate(date)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not parse date %q: %s", date, err)
+	}
+
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, normalized); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("could not parse date %q", date)
+}
+
+// ParseDuration parses an itunes:duration value given in seconds or as
+// HH:MM:SS, H:MM:SS, MM:SS or M:SS. Seconds may be fractional.
+func ParseDuration(duration string) (time.Duration, error) {
+	duration = strings.TrimSpace(duration)
+	if duration == "" {
+		return 0, nil
+	}
+
+	split := strings.Split(duration, ":")
+	if len(split) > 3 {
+		return 0, fmt.Errorf("could not parse duration %q", duration)
+	}
+
+	secs := 0.0
+	for i, s := range split {
+		var val float64
+		var err error
+
+		// Only the seconds may be fractional
+		if i == len(split)-1 {
+			val, err = strconv.ParseFloat(s, 64)
+		} else {
+			var n uint64
+			n, err = strconv.ParseUint(s, 10, 0)
+			val = float64(n)
+		}
+
+		if err != nil || val < 0 || math.IsInf(val, 0) || math.IsNaN(val) {
+			return 0, fmt.Errorf("could not parse duration %q", duration)
+		}
+
+		secs = secs*60 + val
+	}
+
+	return time.Duration(secs * float64(time.Second)), nil
+}
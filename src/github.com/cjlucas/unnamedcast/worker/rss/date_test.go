@@ -0,0 +1,86 @@
+package rss_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cjlucas/unnamedcast/worker/rss"
+)
+
+func TestParseDate(t *testing.T) {
+	edt := time.FixedZone("", -4*60*60)
+	pst := time.FixedZone("", -8*60*60)
+
+	cases := []struct {
+		Input    string
+		Expected time.Time
+	}{
+		{"Mon, 11 Apr 2016 01:15:00 GMT", time.Date(2016, 4, 11, 1, 15, 0, 0, time.UTC)},
+		{"Mon, 02 Jan 2006 15:04:05 -0700", time.Date(2006, 1, 2, 22, 4, 5, 0, time.UTC)},
+		{"2016-04-10T08:00:00-04:00", time.Date(2016, 4, 10, 8, 0, 0, 0, edt)},
+		{"2016-04-10T08:00:00.250Z", time.Date(2016, 4, 10, 8, 0, 0, 250e6, time.UTC)},
+		{"11 Apr 2016 01:15:00 +0000", time.Date(2016, 4, 11, 1, 15, 0, 0, time.UTC)},
+		{"Tues, 11 Apr 2016 01:15:00 GMT", time.Date(2016, 4, 11, 1, 15, 0, 0, time.UTC)},
+		{"Mon, 11 Apr 16 01:15:00 GMT", time.Date(2016, 4, 11, 1, 15, 0, 0, time.UTC)},
+		{"Sun, 10 Apr 2016 21:15:00 EDT", time.Date(2016, 4, 10, 21, 15, 0, 0, edt)},
+		{"Sun, 10 Apr 2016 17:15:00 PST", time.Date(2016, 4, 10, 17, 15, 0, 0, pst)},
+		{"Sun, 10 Apr 2016 17:15:00 pst", time.Date(2016, 4, 10, 17, 15, 0, 0, pst)},
+		{"Mon, 11 Apr 2016 01:15:00.5 GMT", time.Date(2016, 4, 11, 1, 15, 0, 5e8, time.UTC)},
+		{"Mon, 11 Apr 2016 01:15 GMT", time.Date(2016, 4, 11, 1, 15, 0, 0, time.UTC)},
+		{"Mon,  11 April 2016 01:15:00  +05:30", time.Date(2016, 4, 10, 19, 45, 0, 0, time.UTC)},
+		{"Mon, 11 Apr 2016 01:15:00 -0400 (EDT)", time.Date(2016, 4, 11, 1, 15, 0, 0, edt)},
+		{"Mon, 11 Apr 2016 01:15:00", time.Date(2016, 4, 11, 1, 15, 0, 0, time.UTC)},
+		{"11 Apr 2016", time.Date(2016, 4, 11, 0, 0, 0, 0, time.UTC)},
+		{"2016-04-11 01:15:00", time.Date(2016, 4, 11, 1, 15, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		out, err := rss.ParseDate(c.Input)
+		if err != nil {
+			t.Errorf("ParseDate(%q) failed: %s", c.Input, err)
+			continue
+		}
+		if !out.Equal(c.Expected) {
+			t.Errorf("ParseDate(%q) = %s, expected %s", c.Input, out, c.Expected)
+		}
+	}
+
+	for _, input := range []string{"", "yesterday", "Mon, 11 Apr 2016 01:15:00 XYZ", "2016-13-45"} {
+		if out, err := rss.ParseDate(input); err == nil {
+			t.Errorf("Expected ParseDate(%q) to fail, got %s", input, out)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected time.Duration
+	}{
+		{"", 0},
+		{"3723", 3723 * time.Second},
+		{"3723.5", 3723*time.Second + 500*time.Millisecond},
+		{"1:02:03", time.Hour + 2*time.Minute + 3*time.Second},
+		{"1:02:03.5", time.Hour + 2*time.Minute + 3500*time.Millisecond},
+		{"62:03", 62*time.Minute + 3*time.Second},
+		{"2:03", 2*time.Minute + 3*time.Second},
+		{" 2:03 ", 2*time.Minute + 3*time.Second},
+	}
+
+	for _, c := range cases {
+		out, err := rss.ParseDuration(c.Input)
+		if err != nil {
+			t.Errorf("ParseDuration(%q) failed: %s", c.Input, err)
+			continue
+		}
+		if out != c.Expected {
+			t.Errorf("ParseDuration(%q) = %s, expected %s", c.Input, out, c.Expected)
+		}
+	}
+
+	for _, input := range []string{"abc", "1:2:3:4", "-5", "1.5:00", "NaN", "1::2"} {
+		if out, err := rss.ParseDuration(input); err == nil {
+			t.Errorf("Expected ParseDuration(%q) to fail, got %s", input, out)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package rss
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+// Decoder reads an RSS 2.0 or Atom 1.0 document, passing each item to a
+// callback as soon as it is decoded. Only one item is held in memory at a
+// time, so feeds with very large back catalogs can be processed in constant
+// memory.
+type Decoder struct {
+	// MaxItems is the maximum number of items passed to the callback.
+	// Any further items are skipped without being decoded. Zero means
+	// there is no limit.
+	MaxItems int
+
+	// Skipped is the number of items skipped due to MaxItems.
+	Skipped int
+
+	r     io.Reader
+	fn    func(*Item) error
+	count int
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// item passes an item to the callback, or skips it if MaxItems is reached.
+func (d *Decoder) item(dec *xml.Decoder, decodeItem func() (Item, error)) error {
+	if d.MaxItems > 0 && d.count >= d.MaxItems {
+		d.Skipped++
+		return dec.Skip()
+	}
+
+	item, err := decodeItem()
+	if err != nil {
+		return err
+	}
+
+	d.count++
+	return d.fn(&item)
+}
+
+// itemStream is decoded in place of Channel.Items. Each item element is
+// handed to the Decoder rather than appended to a slice.
+type itemStream struct {
+	d *Decoder
+}
+
+func (s *itemStream) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	return s.d.item(dec, func() (Item, error) {
+		var item Item
+		err := dec.DecodeElement(&item, &start)
+		return item, err
+	})
+}
+
+type channelStream struct {
+	Channel
+	Items itemStream `xml:"item"`
+}
+
+type documentStream struct {
+	Channel channelStream `xml:"channel"`
+}
+
+// entryStream decodes the entry elements of an Atom feed.
+type entryStream struct {
+	d    *Decoder
+	feed *atomFeed
+}
+
+func (s *entryStream) UnmarshalXML(dec *xml.Decoder, start xml.StartElement) error {
+	return s.d.item(dec, func() (Item, error) {
+		var entry atomEntry
+		if err := dec.DecodeElement(&entry, &start); err != nil {
+			return Item{}, err
+		}
+		// Feed level elements are expected to precede the entries
+		return entry.item(s.feed.author()), nil
+	})
+}
+
+type atomFeedStream struct {
+	atomFeed
+	Entries entryStream `xml:"entry"`
+}
+
+// Decode reads the document, calling fn with each item in document order.
+// Decoding stops at the first error returned by fn. The returned Channel
+// does not hold any items.
+func (d *Decoder) Decode(fn func(*Item) error) (*Channel, error) {
+	d.fn = fn
+
+	dec := xml.NewDecoder(d.r)
+	dec.CharsetReader = CharsetReader
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "rss":
+			doc := documentStream{}
+			doc.Channel.Items.d = d
+			if err := dec.DecodeElement(&doc, &start); err != nil {
+				return nil, err
+			}
+			return &doc.Channel.Channel, nil
+		case "feed":
+			feed := atomFeedStream{}
+			feed.Entries = entryStream{d: d, feed: &feed.atomFeed}
+			if err := dec.DecodeElement(&feed, &start); err != nil {
+				return nil, err
+			}
+			channel := feed.channel()
+			return &channel, nil
+		default:
+			return nil, fmt.Errorf("unsupported feed format: %s", start.Name.Local)
+		}
+	}
+}
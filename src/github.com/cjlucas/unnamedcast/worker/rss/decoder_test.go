@@ -0,0 +1,106 @@
+package rss_test
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/cjlucas/unnamedcast/worker/rss"
+)
+
+func TestParseFeed_Charsets(t *testing.T) {
+	cases := []struct {
+		Charset  string
+		Title    []byte
+		Expected string
+	}{
+		{"ISO-8859-1", []byte("Caf\xe9 \xabLatin\xbb"), "Café «Latin»"},
+		{"windows-1252", []byte("\x93Quoted\x94 \x96 \x80100"), "“Quoted” – €100"},
+		{"us-ascii", []byte("It\x92s"), "It’s"},
+		{"ISO-8859-15", []byte("\xa4 \xbd"), "€ œ"},
+		{"UTF-8", []byte("Café"), "Café"},
+	}
+
+	for _, c := range cases {
+		var doc bytes.Buffer
+		fmt.Fprintf(&doc, `<?xml version="1.0" encoding="%s"?>`, c.Charset)
+		doc.WriteString("<rss><channel><title>")
+		doc.Write(c.Title)
+		doc.WriteString("</title><item><title>")
+		doc.Write(c.Title)
+		doc.WriteString("</title></item></channel></rss>")
+
+		out, err := rss.ParseFeed(&doc)
+		if err != nil {
+			t.Errorf("ParseFeed failed for %s: %s", c.Charset, err)
+			continue
+		}
+
+		if out.Channel.Title != c.Expected {
+			t.Errorf("Title mismatch for %s: %q != %q", c.Charset, out.Channel.Title, c.Expected)
+		}
+		if len(out.Channel.Items) != 1 || out.Channel.Items[0].Title != c.Expected {
+			t.Errorf("Item title mismatch for %s: %#v", c.Charset, out.Channel.Items)
+		}
+	}
+}
+
+func TestParseFeed_UnsupportedCharset(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="KOI8-R"?><rss><channel></channel></rss>`
+	if _, err := rss.ParseFeed(strings.NewReader(doc)); err == nil {
+		t.Error("Expected error for unsupported charset")
+	}
+}
+
+func TestDecoder_MaxItems(t *testing.T) {
+	var doc bytes.Buffer
+	doc.WriteString("<rss><channel>")
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(&doc, "<item><guid>%d</guid></item>", i)
+	}
+	// Channel elements after the items are still decoded
+	doc.WriteString("<title>Title</title></channel></rss>")
+
+	dec := rss.NewDecoder(&doc)
+	dec.MaxItems = 3
+
+	var guids []string
+	channel, err := dec.Decode(func(item *rss.Item) error {
+		guids = append(guids, item.GUID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal("Decode failed:", err)
+	}
+
+	if strings.Join(guids, ",") != "0,1,2" {
+		t.Errorf("Unexpected items: %v", guids)
+	}
+	if dec.Skipped != 7 {
+		t.Errorf("Skipped mismatch: %d != 7", dec.Skipped)
+	}
+	if channel.Title != "Title" {
+		t.Errorf("Title mismatch: %s", channel.Title)
+	}
+	if channel.Items != nil {
+		t.Errorf("Expected no items on channel: %#v", channel.Items)
+	}
+}
+
+func TestDecoder_CallbackError(t *testing.T) {
+	doc := "<rss><channel><item/><item/></channel></rss>"
+	stop := fmt.Errorf("stop")
+
+	n := 0
+	_, err := rss.NewDecoder(strings.NewReader(doc)).Decode(func(item *rss.Item) error {
+		n++
+		return stop
+	})
+	if err != stop {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Callback called %d times", n)
+	}
+}
@@ -2,61 +2,141 @@ package rss
 
 import (
 	"encoding/xml"
-	"fmt"
 	"io"
-	"io/ioutil"
-	"strconv"
 	"strings"
-	"time"
 )
 
+type Item struct {
+	GUID        string `xml:"guid"`
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Author      string `xml:"author"`
+	Description string `xml:"description"`
+
+	// content:encoded
+	ContentEncoded string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+
+	// itunes:subtitle
+	ITunesSubtitle string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd subtitle"`
+
+	// itunes:summary
+	ITunesSummary string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd summary"`
+
+	Enclosure struct {
+		URL    string `xml:"url,attr"`
+		Length int    `xml:"length,attr"`
+		Type   string `xml:"type,attr"`
+	} `xml:"enclosure"`
+
+	// Format is RFC2822
+	PublicationDate string `xml:"pubDate"`
+
+	// Represented as an integer (in seconds) or HH:MM:SS, H:MM:SS, MM:SS, or M:SS
+	Duration string `xml:"duration"`
+
+	Image struct {
+		URL string `xml:"href,attr"`
+	} `xml:"image"`
+
+	// itunes:episodeType is one of full, trailer or bonus
+	ITunesEpisodeType string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episodeType"`
+	ITunesSeason      string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd season"`
+	ITunesEpisode     string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episode"`
+	ITunesExplicit    string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd explicit"`
+
+	PodcastTranscripts []PodcastTranscript `xml:"https://podcastindex.org/namespace/1.0 transcript"`
+	PodcastChapters    *PodcastChapters    `xml:"https://podcastindex.org/namespace/1.0 chapters"`
+	PodcastPersons     []PodcastPerson     `xml:"https://podcastindex.org/namespace/1.0 person"`
+	PodcastSeason      PodcastSeason       `xml:"https://podcastindex.org/namespace/1.0 season"`
+	PodcastEpisode     PodcastEpisode      `xml:"https://podcastindex.org/namespace/1.0 episode"`
+}
+
+// ITunesCategory is an itunes:category element. Subcategories are nested
+// itunes:category elements.
+type ITunesCategory struct {
+	Name          string           `xml:"text,attr"`
+	Subcategories []ITunesCategory `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd category"`
+}
+
+const atomNamespace = "http://www.w3.org/2005/Atom"
+
+// rssLink holds a link element. Its name is kept so that links from other
+// namespaces (e.g. atom:link) can be told apart.
+type rssLink struct {
+	XMLName xml.Name
+	Href    string `xml:",chardata"`
+
+	// atom:link elements give their target as an attribute
+	Rel      string `xml:"rel,attr"`
+	HrefAttr string `xml:"href,attr"`
+}
+
 type Channel struct {
-	Title  string `xml:"title"`
-	Author string `xml:"author"`
+	Title       string    `xml:"title"`
+	Author      string    `xml:"author"`
+	Description string    `xml:"description"`
+	Links       []rssLink `xml:"link"`
+	Language    string    `xml:"language"`
+	Copyright   string    `xml:"copyright"`
 
 	Image struct {
 		URL string `xml:"href,attr"`
 	} `xml:"image"`
 
-	Items []struct {
-		GUID        string `xml:"guid"`
-		Title       string `xml:"title"`
-		Link        string `xml:"link"`
-		Author      string `xml:"author"`
-		Description string `xml:"description"`
+	Items []Item `xml:"item"`
+
+	Categories []ITunesCategory `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd category"`
+
+	ITunesSummary string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd summary"`
 
-		// content:encoded
-		ContentEncoded string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	ITunesOwner struct {
+		Name  string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd name"`
+		Email string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd email"`
+	} `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd owner"`
 
-		// itunes:subtitle
-		ITunesSubtitle string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd subtitle"`
+	ITunesExplicit string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd explicit"`
 
-		// itunes:summary
-		ITunesSummary string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd summary"`
+	// itunes:type is either episodic or serial
+	ITunesType string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd type"`
 
-		Enclosure struct {
-			URL    string `xml:"url,attr"`
-			Length int    `xml:"length,attr"`
-			Type   string `xml:"type,attr"`
-		} `xml:"enclosure"`
+	// Yes if the feed should not appear in directories
+	ITunesBlock string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd block"`
 
-		// Format is RFC2822
-		PublicationDate string `xml:"pubDate"`
+	// Yes if no more episodes will be published
+	ITunesComplete string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd complete"`
 
-		// Represented as an integer (in seconds) or HH:MM:SS, H:MM:SS, MM:SS, or M:SS
-		Duration string `xml:"duration"`
+	// The feed's new location
+	ITunesNewFeedURL string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd new-feed-url"`
 
-		Image struct {
-			URL string `xml:"href,attr"`
-		} `xml:"image"`
-	} `xml:"item"`
+	PodcastGUID    string           `xml:"https://podcastindex.org/namespace/1.0 guid"`
+	PodcastLocked  PodcastLocked    `xml:"https://podcastindex.org/namespace/1.0 locked"`
+	PodcastFunding []PodcastFunding `xml:"https://podcastindex.org/namespace/1.0 funding"`
+	PodcastPersons []PodcastPerson  `xml:"https://podcastindex.org/namespace/1.0 person"`
+}
+
+// Link returns the channel's RSS link.
+func (c *Channel) Link() string {
+	for _, l := range c.Links {
+		if l.XMLName.Space == "" {
+			if href := strings.TrimSpace(l.Href); href != "" {
+				return href
+			}
+		}
+	}
+	return ""
+}
 
-	Category struct {
-		Name          string `xml:"text,attr"`
-		Subcategories []struct {
-			Name string `xml:"text,attr"`
+// AtomLink returns the target of the first atom:link with the given
+// relation, such as the hub and self links used by WebSub.
+func (c *Channel) AtomLink(rel string) string {
+	for _, l := range c.Links {
+		if l.XMLName.Space == atomNamespace && strings.EqualFold(l.Rel, rel) {
+			if href := strings.TrimSpace(l.HrefAttr); href != "" {
+				return href
+			}
 		}
-	} `xml:"category"`
+	}
+	return ""
 }
 
 type Document struct {
@@ -64,60 +144,34 @@ type Document struct {
 	Channel Channel  `xml:"channel"`
 }
 
-var pubDateFmts = []string{
-	"Mon, 2 Jan 2006 15:04:05 MST",
-	"Mon, 2 Jan 2006 15:04:05 -0700",
-	"Mon, 02 Jan 2006 15:04:05 MST",
-	"Mon, 02 Jan 2006 15:04:05 -0700",
-}
-
+// ParseFeed parses an RSS 2.0 or Atom 1.0 document. Atom feeds are mapped
+// onto the same Channel as RSS feeds. The entire document is held in memory,
+// use a Decoder to process large feeds.
 func ParseFeed(r io.Reader) (*Document, error) {
-	data, err := ioutil.ReadAll(r)
+	var items []Item
+	channel, err := NewDecoder(r).Decode(func(item *Item) error {
+		items = append(items, *item)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	var doc Document
-	if err := xml.Unmarshal(data, &doc); err != nil {
-		return nil, err
-	}
-
-	return &doc, nil
+	channel.Items = items
+	return &Document{Channel: *channel}, nil
 }
 
-func ParseDuration(duration string) (time.Duration, error) {
-	if duration == "" {
-		return time.Duration(0), nil
-	}
-
-	// Simple case, an integer in seconds
-	if val, err := strconv.ParseInt(duration, 10, 0); err == nil {
-		return time.Duration(val), nil
-	}
-
-	// parse HH:MM:SS format backwards with incrementing multiplier
-	split := strings.Split(duration, ":")
-	secs := 0
-	curMultiplier := 1
-	for i := range split {
-		val, err := strconv.ParseInt(split[len(split)-i-1], 10, 0)
-		if err != nil {
-			return time.Duration(0), fmt.Errorf("Could not parse duration: %s", duration)
-		}
-
-		secs += int(val) * curMultiplier
-		curMultiplier *= 60
-	}
-
-	return time.Duration(secs), nil
+// ParseYes parses the value of an iTunes element that is set to Yes.
+func ParseYes(s string) bool {
+	return strings.EqualFold(strings.TrimSpace(s), "yes")
 }
 
-func ParseDate(date string) time.Time {
-	for _, fmt := range pubDateFmts {
-		if t, err := time.Parse(fmt, date); err == nil {
-			return t
-		}
+// ParseExplicit parses the value of an itunes:explicit element. Older feeds
+// use yes/explicit/clean rather than true/false.
+func ParseExplicit(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "true", "yes", "explicit":
+		return true
 	}
-
-	return time.Time{}.UTC()
+	return false
 }
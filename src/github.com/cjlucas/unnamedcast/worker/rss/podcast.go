@@ -0,0 +1,67 @@
+package rss
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Elements of the Podcasting 2.0 namespace.
+// See https://github.com/Podcastindex-org/podcast-namespace
+
+type PodcastPerson struct {
+	Name  string `xml:",chardata"`
+	Role  string `xml:"role,attr"`
+	Group string `xml:"group,attr"`
+	Image string `xml:"img,attr"`
+	Href  string `xml:"href,attr"`
+}
+
+type PodcastFunding struct {
+	URL   string `xml:"url,attr"`
+	Title string `xml:",chardata"`
+}
+
+type PodcastTranscript struct {
+	URL      string `xml:"url,attr"`
+	Type     string `xml:"type,attr"`
+	Language string `xml:"language,attr"`
+	Rel      string `xml:"rel,attr"`
+}
+
+type PodcastChapters struct {
+	URL  string `xml:"url,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type PodcastLocked struct {
+	Value string `xml:",chardata"`
+	Owner string `xml:"owner,attr"`
+}
+
+// IsLocked reports whether the feed may not be imported to other platforms.
+func (l *PodcastLocked) IsLocked() bool {
+	return ParseYes(l.Value)
+}
+
+type PodcastSeason struct {
+	Value string `xml:",chardata"`
+	Name  string `xml:"name,attr"`
+}
+
+// Number returns the season number, or 0 if it is missing or malformed.
+func (s *PodcastSeason) Number() int {
+	n, _ := strconv.Atoi(strings.TrimSpace(s.Value))
+	return n
+}
+
+type PodcastEpisode struct {
+	Value   string `xml:",chardata"`
+	Display string `xml:"display,attr"`
+}
+
+// Number returns the episode number, which may be fractional, or 0 if it
+// is missing or malformed.
+func (e *PodcastEpisode) Number() float64 {
+	n, _ := strconv.ParseFloat(strings.TrimSpace(e.Value), 64)
+	return n
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	minCheckInterval     = 15 * time.Minute
+	maxCheckInterval     = 24 * time.Hour
+	defaultCheckInterval = time.Hour
+
+	// completeCheckInterval is the time between checks of a feed that is
+	// marked complete.
+	completeCheckInterval = 7 * 24 * time.Hour
+
+	// checkLease is how long a feed is held back from being scheduled
+	// again once an update has been enqueued for it. The update sets the
+	// feed's actual next check time when it finishes.
+	checkLease = time.Hour
+
+	maxFailureBackoff = 24 * time.Hour
+
+	// A feed is disabled once it has failed disableAfterFailures times in
+	// a row without a successful update for disableAfter.
+	disableAfterFailures = 10
+	disableAfter         = 30 * 24 * time.Hour
+
+	// websubCheckInterval is the time between checks of a feed that is
+	// subscribed to through a WebSub hub.
+	websubCheckInterval = 24 * time.Hour
+
+	// hubLeaseDuration is the requested length of WebSub subscriptions,
+	// which are renewed once they are within hubRenewalWindow of expiring.
+	hubLeaseDuration = 7 * 24 * time.Hour
+	hubRenewalWindow = 24 * time.Hour
+
+	// hubVerifyWindow is how long a hub has to verify a requested
+	// subscription.
+	hubVerifyWindow = time.Hour
+
+	// cadenceSamples is the number of recent publications used to find the
+	// rate at which a feed publishes.
+	cadenceSamples = 10
+
+	// checksPerCadence is the number of times a feed is checked within its
+	// typical time between publications.
+	checksPerCadence = 24
+)
+
+type timeSlice []time.Time
+
+func (s timeSlice) Len() int           { return len(s) }
+func (s timeSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s timeSlice) Less(i, j int) bool { return s[i].Before(s[j]) }
+
+type durationSlice []time.Duration
+
+func (s durationSlice) Len() int           { return len(s) }
+func (s durationSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s durationSlice) Less(i, j int) bool { return s[i] < s[j] }
+
+// cadence returns the median time between the most recent publications
+// in pubTimes. Zero is returned if there are too few to tell.
+func cadence(pubTimes []time.Time) time.Duration {
+	var times timeSlice
+	for _, t := range pubTimes {
+		if !t.IsZero() {
+			times = append(times, t)
+		}
+	}
+	sort.Sort(sort.Reverse(times))
+
+	if len(times) > cadenceSamples+1 {
+		times = times[:cadenceSamples+1]
+	}
+	if len(times) < 2 {
+		return 0
+	}
+
+	gaps := make(durationSlice, len(times)-1)
+	for i := range gaps {
+		gaps[i] = times[i].Sub(times[i+1])
+	}
+	sort.Sort(gaps)
+
+	return gaps[len(gaps)/2]
+}
+
+// checkInterval returns the time until a feed should next be checked, given
+// the publication times of its items. Feeds are checked several times for
+// each episode they typically publish, and half as often once they have
+// been quiet for much longer than usual.
+func checkInterval(pubTimes []time.Time, complete bool, now time.Time) time.Duration {
+	if complete {
+		return completeCheckInterval
+	}
+
+	c := cadence(pubTimes)
+	if c <= 0 {
+		return defaultCheckInterval
+	}
+
+	interval := c / checksPerCadence
+
+	var latest time.Time
+	for _, t := range pubTimes {
+		if t.After(latest) {
+			latest = t
+		}
+	}
+	if now.Sub(latest) > 3*c {
+		interval *= 2
+	}
+
+	if interval < minCheckInterval {
+		return minCheckInterval
+	}
+	if interval > maxCheckInterval {
+		return maxCheckInterval
+	}
+	return interval
+}
+
+// failureBackoff returns the time until a feed that has failed to update
+// the given number of times in a row should be retried.
+func failureBackoff(failures int) time.Duration {
+	backoff := minCheckInterval
+	for i := 1; i < failures && backoff < maxFailureBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > maxFailureBackoff {
+		return maxFailureBackoff
+	}
+	return backoff
+}
@@ -1,18 +1,28 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"image"
+	"io"
+	"io/ioutil"
 	"math"
 	"net/http"
+	"net/url"
+	"os"
 	"regexp"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/cjlucas/unnamedcast/api"
+	"github.com/cjlucas/unnamedcast/publicnet"
+	"github.com/cjlucas/unnamedcast/websub"
 	"github.com/cjlucas/unnamedcast/worker/itunes"
-	"github.com/cjlucas/unnamedcast/worker/rss"
+	"github.com/cjlucas/unnamedcast/worker/podcast"
 
 	"image/color"
 	_ "image/jpeg"
@@ -194,8 +204,39 @@ func (w *ScrapeiTunesFeeds) Work(j *Job) error {
 	return nil
 }
 
+// maxItemWarnings is the number of item warnings logged per job. Only a
+// count of the rest is logged.
+const maxItemWarnings = 50
+
+// itemBatchSize is the number of decoded items matched to the stored items
+// at a time.
+const itemBatchSize = 100
+
+// fetchTimeout bounds the time taken by a request for a URL found in a
+// feed, including reading its body.
+const fetchTimeout = 2 * time.Minute
+
+// publicClient only connects to public addresses, as the URLs fetched by
+// the worker are given by feeds.
+var publicClient = publicnet.NewClient(fetchTimeout)
+
 type UpdateFeedWorker struct {
-	API api.API
+	API    api.API
+	Limits podcast.Limits
+
+	// Client is used to fetch feeds, their images and to reach their hubs.
+	// publicClient is used if it is nil.
+	Client *http.Client
+
+	// WebSub is nil if WebSub is disabled
+	WebSub *WebSubConfig
+}
+
+func (w *UpdateFeedWorker) client() *http.Client {
+	if w.Client != nil {
+		return w.Client
+	}
+	return publicClient
 }
 
 type UpdateFeedPayload struct {
@@ -203,18 +244,315 @@ type UpdateFeedPayload struct {
 	Force  bool   `json:"force"`
 }
 
-func (w *UpdateFeedWorker) guidItemsMap(items []api.Item) map[string]api.Item {
-	guidMap := make(map[string]api.Item)
+// fetchFeed fetches the feed at url with the given request headers,
+// following redirects. If the redirect chain begins with permanent
+// redirects, the last URL reached through them is returned as the feed's
+// new location.
+func (w *UpdateFeedWorker) fetchFeed(url string, header http.Header) (*http.Response, string, error) {
+	var movedTo string
+	permanent := true
+
+	client := *w.client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+
+		switch req.Response.StatusCode {
+		case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+			if permanent {
+				movedTo = req.URL.String()
+			}
+		default:
+			permanent = false
+		}
+		return nil
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	return resp, movedTo, nil
+}
+
+// statusError is returned when the feed's server responds with an
+// unexpected status code.
+type statusError struct {
+	Code   int
+	Status string
+}
+
+func (e *statusError) Error() string {
+	return "unexpected response: " + e.Status
+}
+
+// conditionalHeaders returns the headers needed to only fetch the feed if
+// it has been modified since it was last scraped.
+func conditionalHeaders(feed *api.Feed) http.Header {
+	header := make(http.Header)
+	if feed.SourceETag != "" {
+		header.Set("If-None-Match", feed.SourceETag)
+	}
+	if !feed.SourceLastModified.IsZero() {
+		header.Set("If-Modified-Since", feed.SourceLastModified.UTC().Format(http.TimeFormat))
+	}
+	return header
+}
+
+// spoolBody copies r to a temporary file, returning the file positioned at
+// its start along with the SHA-256 hash of its contents. The caller must
+// close and remove the file.
+func spoolBody(r io.Reader, maxBytes int64) (*os.File, string, error) {
+	f, err := ioutil.TempFile("", "feed")
+	if err != nil {
+		return nil, "", err
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), podcast.LimitReader(r, maxBytes))
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, "", err
+	}
+
+	return f, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// skipScrape records that the feed was not updated because it has not
+// been modified since it was last scraped. counter is one of the feed's
+// skip counters.
+func (w *UpdateFeedWorker) skipScrape(j *Job, feed *api.Feed, statusCode int, counter *int) error {
+	*counter++
+
+	feed.LastScrapedTime = time.Now()
+
+	interval := feed.CheckInterval
+	if interval <= 0 {
+		interval = defaultCheckInterval
+	}
+
+	// The longer interval of subscribed feeds only holds while the hub is
+	// pushing to the server
+	if feed.HubURL != "" && !feed.Complete && interval >= websubCheckInterval &&
+		!feed.HubLeaseExpiry.After(feed.LastScrapedTime) {
+		interval = defaultCheckInterval
+		feed.CheckInterval = interval
+	}
+
+	feed.NextCheckTime = feed.LastScrapedTime.Add(interval)
+	markHealthy(feed, statusCode)
+	if err := w.API.UpdateFeed(feed); err != nil {
+		return err
+	}
+
+	if w.WebSub != nil && feed.HubURL != "" && feed.HubLeaseExpiry.Sub(time.Now()) < hubRenewalWindow {
+		w.subscribeToHub(j, feed)
+	}
+
+	return nil
+}
+
+// markHealthy records that the feed was scraped successfully at its
+// LastScrapedTime, re-enabling it if it was disabled.
+func markHealthy(feed *api.Feed, statusCode int) {
+	feed.LastSuccessTime = feed.LastScrapedTime
+	feed.LastStatusCode = statusCode
+	feed.LastError = ""
+	feed.ConsecutiveFailures = 0
+	feed.Disabled = false
+}
+
+// disableReason returns why the feed should be disabled after a failed
+// update, or an empty string if it should not be.
+func disableReason(feed *api.Feed, now time.Time) string {
+	if feed.LastStatusCode == http.StatusGone {
+		return "feed is gone"
+	}
+	if feed.ConsecutiveFailures < disableAfterFailures {
+		return ""
+	}
 
-	for _, item := range items {
-		guidMap[item.GUID] = item
+	// Feeds scraped before successes were recorded
+	lastSuccess := feed.LastSuccessTime
+	if lastSuccess.IsZero() {
+		lastSuccess = feed.LastScrapedTime
 	}
+	if lastSuccess.IsZero() {
+		lastSuccess = feed.CreationTime
+	}
+
+	if now.Sub(lastSuccess) < disableAfter {
+		return ""
+	}
+	if feed.LastSuccessTime.IsZero() && feed.LastScrapedTime.IsZero() {
+		return fmt.Sprintf("feed has failed %d times and has never been updated", feed.ConsecutiveFailures)
+	}
+	return fmt.Sprintf("feed has failed %d times since it was last updated on %s",
+		feed.ConsecutiveFailures, lastSuccess.UTC().Format(time.RFC3339))
+}
+
+// WebSubConfig enables WebSub subscriptions for feeds that advertise a hub.
+type WebSubConfig struct {
+	// CallbackURL is the public URL of the server's WebSub callback. The
+	// ID of the feed is appended to it.
+	CallbackURL string
+
+	// Key from which subscription secrets are derived. It must match the
+	// server's key.
+	Key []byte
+}
+
+// subscribeToHub asks the feed's hub to push updates to the server. Errors
+// are logged, as the feed can still be polled.
+func (w *UpdateFeedWorker) subscribeToHub(j *Job, feed *api.Feed) {
+	// The server only accepts verifications of subscriptions it knows were
+	// requested
+	feed.HubPendingUntil = time.Now().Add(hubVerifyWindow)
+	if err := w.API.UpdateFeed(feed); err != nil {
+		j.Logf("Could not subscribe to hub %s (error: %s)", feed.HubURL, err)
+		return
+	}
+
+	err := websub.Subscribe(w.client(), &websub.Subscription{
+		Hub:          feed.HubURL,
+		Topic:        feed.HubTopic,
+		Callback:     strings.TrimSuffix(w.WebSub.CallbackURL, "/") + "/" + feed.ID,
+		Secret:       websub.Secret(w.WebSub.Key, feed.ID),
+		LeaseSeconds: int(hubLeaseDuration / time.Second),
+	})
+	if err != nil {
+		j.Logf("Could not subscribe to hub %s (error: %s)", feed.HubURL, err)
+		return
+	}
+
+	j.Logf("Subscribed to hub %s", feed.HubURL)
+}
+
+// recordFailure records that the feed failed to update with updateErr,
+// delaying its next check by an amount that grows with each consecutive
+// failure. Feeds that are gone or have been failing for too long are
+// disabled.
+func (w *UpdateFeedWorker) recordFailure(j *Job, feedID string, updateErr error) {
+	feed, err := w.API.GetFeed(feedID)
+	if err == nil {
+		now := time.Now()
+		feed.ConsecutiveFailures++
+		feed.NextCheckTime = now.Add(failureBackoff(feed.ConsecutiveFailures))
+		feed.LastError = updateErr.Error()
+		feed.LastStatusCode = 0
+		if se, ok := updateErr.(*statusError); ok {
+			feed.LastStatusCode = se.Code
+		}
 
-	return guidMap
+		if reason := disableReason(feed, now); reason != "" && !feed.Disabled {
+			j.Logf("Disabling feed (%s)", reason)
+			feed.Disabled = true
+		}
+		err = w.API.UpdateFeed(feed)
+	}
+
+	if err != nil {
+		j.Logf("Could not record failure (error: %s)", err)
+	}
+}
+
+// moveFeed reports whether the feed can move to newURL. A feed cannot
+// move to a URL that belongs to another feed.
+func (w *UpdateFeedWorker) moveFeed(j *Job, feedID, newURL string) bool {
+	if u, err := url.Parse(newURL); err != nil || !u.IsAbs() || (u.Scheme != "http" && u.Scheme != "https") {
+		j.Logf("Ignoring invalid new feed URL: %s", newURL)
+		return false
+	}
+
+	other, err := w.API.FeedForURL(newURL)
+	if err != nil {
+		j.Logf("Could not look up new feed URL, will not move (error: %s)", err)
+		return false
+	}
+	if other != nil && other.ID != feedID {
+		j.Logf("Feed %s already exists at %s, will not move", other.ID, newURL)
+		return false
+	}
+
+	j.Logf("Feed has moved to %s", newURL)
+	return true
+}
+
+// markRemovedItems marks the stored items that were not found in the feed
+// as removed. Nothing is marked if the feed has no items at all, as that is
+// more likely to be a problem with the feed than the removal of every
+// episode.
+func (w *UpdateFeedWorker) markRemovedItems(j *Job, feedID string, index *itemIndex) error {
+	if len(index.matched) == 0 {
+		return nil
+	}
+
+	keepIDs := make([]string, 0, len(index.matched))
+	for id := range index.matched {
+		keepIDs = append(keepIDs, id)
+	}
+
+	numRemoved, err := w.API.MarkRemovedFeedItems(feedID, keepIDs)
+	if err != nil {
+		return err
+	}
+
+	if numRemoved > 0 {
+		j.Logf("Marked %d items as removed", numRemoved)
+	}
+	return nil
+}
+
+// matchItems creates or updates a batch of decoded items, matching them to
+// the stored items with the same GUIDs or identity keys. It returns the IDs
+// of the items it created.
+func (w *UpdateFeedWorker) matchItems(j *Job, feedID string, index *itemIndex, items []api.Item) ([]string, error) {
+	stored, err := w.API.FindFeedItems(feedID, itemKeys(items))
+	if err != nil {
+		return nil, err
+	}
+	index.Load(stored)
+
+	var newItemIDs []string
+	for i := range items {
+		item := &items[i]
+
+		if id, reGUIDed := index.Match(item); id != "" {
+			if reGUIDed {
+				j.Logf("Item %s changed its GUID to %q", id, item.GUID)
+			}
+			item.ID = id
+			if _, err := w.API.UpdateFeedItem(feedID, item); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if err := w.API.CreateFeedItem(feedID, item); err != nil {
+			return nil, err
+		}
+		index.Add(item)
+		newItemIDs = append(newItemIDs, item.ID)
+	}
+
+	return newItemIDs, nil
 }
 
 func (w *UpdateFeedWorker) fetchImage(url string) (image.Image, error) {
-	resp, err := http.Get(url)
+	resp, err := w.client().Get(url)
 	if err != nil {
 		return nil, err
 	}
@@ -351,67 +689,155 @@ func (w *UpdateFeedWorker) Work(j *Job) error {
 		return err
 	}
 
-	resp, err := http.Get(origFeed.URL)
+	// Failures are only counted once all retries are exhausted
+	if err := w.update(j, &payload, origFeed); err != nil {
+		// Retrying will not bring back a feed that is gone
+		if se, ok := err.(*statusError); ok && se.Code == http.StatusGone {
+			j.Logf("Failed with error: %s", err)
+			w.recordFailure(j, payload.FeedID, err)
+			return nil
+		}
+
+		if j.LastAttempt() {
+			w.recordFailure(j, payload.FeedID, err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (w *UpdateFeedWorker) update(j *Job, payload *UpdateFeedPayload, origFeed *api.Feed) error {
+	var header http.Header
+	if !payload.Force {
+		header = conditionalHeaders(origFeed)
+	}
+
+	resp, movedTo, err := w.fetchFeed(origFeed.URL, header)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		j.Logf("Feed has not been modified since last scrape, will not update")
+		return w.skipScrape(j, origFeed, resp.StatusCode, &origFeed.ScrapesSkippedNotModified)
+	}
+
+	if resp.StatusCode/100 != 2 {
+		return &statusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	// Not every server honors conditional requests, so the validators
+	// are compared here as well
 	etag := resp.Header.Get("ETag")
 	if !payload.Force && etag != "" && etag == origFeed.SourceETag {
 		j.Logf("ETag has not changed since last scrape, will not update")
-		return nil
+		return w.skipScrape(j, origFeed, resp.StatusCode, &origFeed.ScrapesSkippedNotModified)
 	}
 
 	var lastModifiedTime time.Time
-	if val := resp.Header.Get("Last-Modified"); !payload.Force && val != "" {
-		lastModifiedTime, err = time.Parse(time.RFC1123, val)
+	lastModified := resp.Header.Get("Last-Modified")
+	if lastModified != "" {
+		lastModifiedTime, err = http.ParseTime(lastModified)
 		if err != nil {
 			j.Logf("Failed to parse Last-Modified header: %s", err)
-		} else {
+		} else if !payload.Force {
 			t1 := lastModifiedTime.Truncate(time.Second)
 			t2 := origFeed.SourceLastModified.Truncate(time.Second)
 			if t1.Equal(t2) {
 				j.Logf("Last-Modified has not changed since last scrape, will not update")
-				return nil
+				return w.skipScrape(j, origFeed, resp.StatusCode, &origFeed.ScrapesSkippedNotModified)
 			}
 		}
 	}
 
-	doc, err := rss.ParseFeed(resp.Body)
-	if err != nil {
-		return err
-	}
+	// Without either validator, the body is hashed to find out whether it
+	// has changed. It is spooled to disk first as it has to be read twice.
+	body := io.Reader(resp.Body)
+	var contentHash string
+	if etag == "" && lastModified == "" {
+		f, hash, err := spoolBody(resp.Body, w.Limits.WithDefaults().MaxBytes)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
 
-	origItems, err := w.API.GetFeedItems(payload.FeedID)
-	if err != nil {
-		return err
+		if !payload.Force && hash == origFeed.SourceContentHash {
+			j.Logf("Content has not changed since last scrape, will not update")
+			return w.skipScrape(j, origFeed, resp.StatusCode, &origFeed.ScrapesSkippedContentHash)
+		}
+
+		body = f
+		contentHash = hash
 	}
 
-	existingFeedItemMap := w.guidItemsMap(origItems)
-	newFeedItemMap := w.guidItemsMap(itemsFromRSS(doc))
+	index := newItemIndex(nil)
 
-	var newItems []api.Item
-	var existingItems []api.Item
-	for guid, item := range newFeedItemMap {
-		if origItem, ok := existingFeedItemMap[guid]; ok {
-			item.ID = origItem.ID
-			existingItems = append(existingItems, item)
-		} else {
-			newItems = append(newItems, item)
+	// Items are created or updated in batches as they are decoded so that
+	// neither the feed nor its stored items are held in memory in their
+	// entirety
+	var batch []api.Item
+	var newItemIDs []string
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
 		}
-	}
-
-	for i := range newItems {
-		if err := w.API.CreateFeedItem(payload.FeedID, &newItems[i]); err != nil {
+		ids, err := w.matchItems(j, payload.FeedID, index, batch)
+		if err != nil {
 			return err
 		}
+		newItemIDs = append(newItemIDs, ids...)
+		batch = batch[:0]
+		return nil
 	}
 
-	for _, item := range existingItems {
-		if _, err = w.API.UpdateFeedItem(payload.FeedID, &item); err != nil {
-			return err
+	var pubTimes []time.Time
+	numWarnings := 0
+	contentType := resp.Header.Get("Content-Type")
+	feed, skipped, err := podcast.Decode(contentType, body, w.Limits, func(item *api.Item, warnings []string) error {
+		for _, warning := range warnings {
+			if numWarnings++; numWarnings <= maxItemWarnings {
+				j.Logf("Warning: item %q: %s", item.GUID, warning)
+			}
 		}
+
+		// Nothing to identify the item by, it would be duplicated on
+		// every update
+		if item.GUID == "" {
+			return nil
+		}
+
+		if !item.PublicationTime.IsZero() {
+			pubTimes = append(pubTimes, item.PublicationTime)
+		}
+
+		item.MediaURLKey = podcast.MediaURLKey(item.URL)
+		item.ContentKey = podcast.ContentKey(item)
+
+		if batch = append(batch, *item); len(batch) >= itemBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return err
+	}
+
+	if numWarnings > maxItemWarnings {
+		j.Logf("%d more item warnings were not logged", numWarnings-maxItemWarnings)
+	}
+
+	// Items beyond the limit may still be in the feed, so removals are only
+	// detected when no items were skipped
+	if skipped > 0 {
+		j.Logf("Skipped %d items beyond the limit of %d", skipped, w.Limits.WithDefaults().MaxItems)
+	} else if err := w.markRemovedItems(j, payload.FeedID, index); err != nil {
+		return err
 	}
 
 	// NOTE: Disabled for now until http unauthorized request bug is resolved
@@ -426,8 +852,6 @@ func (w *UpdateFeedWorker) Work(j *Job) error {
 	// 	}
 	// }
 
-	feed := feedFromRSS(doc)
-
 	if feed.ImageURL != "" {
 		img, err := w.fetchImage(feed.ImageURL)
 		j.Logf("Fetched image with error: %v", err)
@@ -436,17 +860,62 @@ func (w *UpdateFeedWorker) Work(j *Job) error {
 		}
 	}
 
+	// itunes:new-feed-url takes precedence over a permanent redirect
+	newURL := feed.URL
+	if newURL == "" {
+		newURL = movedTo
+	}
+
 	feed.ID = payload.FeedID
 	feed.URL = origFeed.URL
+	if newURL != "" && newURL != origFeed.URL && w.moveFeed(j, origFeed.ID, newURL) {
+		feed.URL = newURL
+	}
 	feed.ITunesRatingCount = origFeed.ITunesRatingCount
 	feed.ITunesReviewCount = origFeed.ITunesReviewCount
 	feed.LastScrapedTime = time.Now()
+	markHealthy(feed, resp.StatusCode)
 	feed.SourceETag = etag
 	feed.SourceLastModified = lastModifiedTime
+	feed.SourceContentHash = contentHash
+	feed.ScrapesSkippedNotModified = origFeed.ScrapesSkippedNotModified
+	feed.ScrapesSkippedContentHash = origFeed.ScrapesSkippedContentHash
+	feed.CheckInterval = checkInterval(pubTimes, feed.Complete, feed.LastScrapedTime)
+
+	// Hubs may also be advertised in the response headers
+	hub, self := websub.DiscoverLinks(resp.Header)
+	if feed.HubURL == "" {
+		feed.HubURL = hub
+	}
+	if feed.HubTopic == "" {
+		feed.HubTopic = self
+	}
+	if feed.HubURL == "" {
+		feed.HubTopic = ""
+	} else if feed.HubTopic == "" {
+		feed.HubTopic = feed.URL
+	}
+
+	// Feeds with a working subscription are pushed to, polling them is
+	// only a safety net
+	hubUnchanged := feed.HubURL == origFeed.HubURL && feed.HubTopic == origFeed.HubTopic
+	if hubUnchanged && origFeed.HubLeaseExpiry.After(feed.LastScrapedTime) && feed.CheckInterval < websubCheckInterval {
+		feed.CheckInterval = websubCheckInterval
+	}
+
+	feed.NextCheckTime = feed.LastScrapedTime.Add(feed.CheckInterval)
 	if err := w.API.UpdateFeed(feed); err != nil {
 		return err
 	}
 
+	// The hub verifies the subscription by calling the server, which may
+	// happen before this returns. Subscribing after the feed is updated
+	// keeps the update from clearing the verified lease.
+	if w.WebSub != nil && feed.HubURL != "" &&
+		(!hubUnchanged || origFeed.HubLeaseExpiry.Sub(time.Now()) < hubRenewalWindow) {
+		w.subscribeToHub(j, feed)
+	}
+
 	users, err := w.API.GetFeedsUsers(payload.FeedID)
 	if err != nil {
 		return fmt.Errorf("Failed to get users' feeds: %s", err)
@@ -459,9 +928,9 @@ func (w *UpdateFeedWorker) Work(j *Job) error {
 
 	for i := range users {
 		user := &users[i]
-		for k := range newItems {
+		for _, id := range newItemIDs {
 			err := w.API.UpdateUserItemState(user.ID, api.ItemState{
-				ItemID:           newItems[k].ID,
+				ItemID:           id,
 				State:            api.StateUnplayed,
 				ModificationTime: time.Now().UTC(),
 			})
@@ -475,95 +944,42 @@ func (w *UpdateFeedWorker) Work(j *Job) error {
 	return nil
 }
 
+// UpdateUserFeedsWorker schedules updates of the subscribed feeds that are
+// due. Each feed is enqueued once regardless of its number of subscribers.
 type UpdateUserFeedsWorker struct {
 	API api.API
 }
 
 func (w *UpdateUserFeedsWorker) Work(job *Job) error {
-	users, err := w.API.GetUsers()
+	feeds, err := w.API.GetDueFeeds()
 	if err != nil {
 		return err
 	}
 
-	job.Logf("Fetched %d users", len(users))
+	job.Logf("Fetched %d due feeds", len(feeds))
 
-	for i := range users {
-		feedIDs := users[i].FeedIDs
-		for _, id := range feedIDs {
-			j := api.Job{
-				Queue:   queueUpdateFeed,
-				Payload: &UpdateFeedPayload{FeedID: id},
-			}
-			if err = w.API.CreateJob(&j); err != nil {
-				job.Logf("Failed to add update feed job (error: %s)", err)
-				continue
-			}
+	for i := range feeds {
+		// Hold the feed back so it is not enqueued again while this
+		// update is pending
+		leased, err := w.API.LeaseDueFeed(feeds[i].ID, time.Now().Add(checkLease))
+		if err != nil {
+			job.Logf("Failed to update next check time of feed %s (error: %s)", feeds[i].ID, err)
+			continue
 		}
-	}
-
-	return nil
-}
-
-func feedFromRSS(doc *rss.Document) *api.Feed {
-	channel := doc.Channel
-	var feed api.Feed
-
-	feed.Title = channel.Title
-	feed.ImageURL = channel.Image.URL
-	feed.Author = channel.Author
-
-	feed.Category.Name = channel.Category.Name
-	feed.Category.Subcategories = make(
-		[]string,
-		len(channel.Category.Subcategories))
-
-	for _, c := range channel.Category.Subcategories {
-		feed.Category.Subcategories = append(feed.Category.Subcategories, c.Name)
-	}
-
-	return &feed
-}
-
-func itemsFromRSS(doc *rss.Document) []api.Item {
-	items := make([]api.Item, len(doc.Channel.Items))
-	for i, item := range doc.Channel.Items {
-		jsonItem := &items[i]
-
-		jsonItem.GUID = item.GUID
-		jsonItem.Title = item.Title
-		jsonItem.Author = item.Author
-		jsonItem.URL = item.Enclosure.URL
-		jsonItem.Size = item.Enclosure.Length
-		jsonItem.PublicationTime = rss.ParseDate(item.PublicationDate)
-		jsonItem.Duration, _ = rss.ParseDuration(item.Duration)
-		jsonItem.ImageURL = item.Image.URL
-		jsonItem.Link = item.Link
-
-		// Choose one description and one summary
-		// break when first preferred description is found
-
-		chooseOne := func(s *string, choices []string) {
-			for _, c := range choices {
-				if c != "" {
-					*s = c
-					break
-				}
-			}
+		if !leased {
+			job.Logf("Feed %s is no longer due, will not update", feeds[i].ID)
+			continue
 		}
 
-		chooseOne(&jsonItem.Summary, []string{
-			item.ITunesSummary,
-			item.ITunesSubtitle,
-			item.Description,
-		})
-
-		chooseOne(&jsonItem.Description, []string{
-			item.ContentEncoded,
-			item.ITunesSummary,
-			item.Description,
-			jsonItem.Summary,
-		})
+		j := api.Job{
+			Queue:   queueUpdateFeed,
+			Payload: &UpdateFeedPayload{FeedID: feeds[i].ID},
+		}
+		if err := w.API.CreateJob(&j); err != nil {
+			job.Logf("Failed to add update feed job (error: %s)", err)
+			continue
+		}
 	}
 
-	return items
+	return nil
 }
@@ -1,26 +1,639 @@
 package main
 
 import (
-	"bytes"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/cjlucas/unnamedcast/worker/rss"
+	"github.com/cjlucas/unnamedcast/api"
+	"github.com/cjlucas/unnamedcast/websub"
+	"github.com/cjlucas/unnamedcast/worker/podcast"
 )
 
-func TestItemsFromRSSWithDescription(t *testing.T) {
-	buf, err := ioutil.ReadFile("testdata/nominal.xml")
+func TestFetchFeed_Redirects(t *testing.T) {
+	mux := http.NewServeMux()
+	redirect := func(from, to string, code int) {
+		mux.HandleFunc(from, func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, to, code)
+		})
+	}
+	mux.HandleFunc("/feed", func(w http.ResponseWriter, r *http.Request) {})
+
+	redirect("/moved", "/moved-again", http.StatusMovedPermanently)
+	redirect("/moved-again", "/feed", http.StatusPermanentRedirect)
+	redirect("/moved-then-found", "/found", http.StatusMovedPermanently)
+	redirect("/found", "/feed", http.StatusFound)
+	redirect("/found-then-moved", "/moved", http.StatusFound)
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cases := []struct {
+		Path    string
+		MovedTo string
+	}{
+		{"/feed", ""},
+		{"/moved", "/feed"},
+		{"/moved-then-found", "/found"},
+		{"/found-then-moved", ""},
+	}
+
+	w := UpdateFeedWorker{Client: http.DefaultClient}
+	for _, c := range cases {
+		resp, movedTo, err := w.fetchFeed(srv.URL+c.Path, nil)
+		if err != nil {
+			t.Fatalf("fetchFeed failed for %s: %s", c.Path, err)
+		}
+		resp.Body.Close()
+
+		expected := ""
+		if c.MovedTo != "" {
+			expected = srv.URL + c.MovedTo
+		}
+		if movedTo != expected {
+			t.Errorf("movedTo mismatch for %s: %q != %q", c.Path, movedTo, expected)
+		}
+	}
+}
+
+func TestFetchFeed_PublicOnly(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Loopback server was reached")
+	}))
+	defer srv.Close()
+
+	var w UpdateFeedWorker
+	if _, _, err := w.fetchFeed(srv.URL, nil); err == nil {
+		t.Fatal("Expected an error")
+	}
+}
+
+func TestItemIndex(t *testing.T) {
+	pubTime := time.Date(2016, time.April, 11, 1, 15, 0, 0, time.UTC)
+	index := newItemIndex([]api.Item{
+		{ID: "1", GUID: "a", URL: "http://example.com/1.mp3"},
+		{ID: "2", GUID: "b", Title: "Episode 2", PublicationTime: pubTime},
+		{ID: "3", GUID: "c", URL: "http://example.com/3.mp3"},
+	})
+
+	cases := []struct {
+		Item     api.Item
+		ID       string
+		ReGUIDed bool
+	}{
+		{api.Item{GUID: "a"}, "1", false},
+		// New GUID, same media behind a tracking prefix
+		{api.Item{GUID: "c2", URL: "https://chtbl.com/track/X/example.com/3.mp3"}, "3", true},
+		// New GUID, same title and publication date
+		{api.Item{GUID: "b2", Title: "Episode 2", PublicationTime: pubTime}, "2", true},
+		// Matched again by its new GUID
+		{api.Item{GUID: "b2"}, "2", false},
+		// Item 1 has already been matched
+		{api.Item{GUID: "d", URL: "http://example.com/1.mp3"}, "", false},
+		{api.Item{GUID: "e", Title: "Episode 5"}, "", false},
+	}
+
+	for _, c := range cases {
+		id, reGUIDed := index.Match(&c.Item)
+		if id != c.ID || reGUIDed != c.ReGUIDed {
+			t.Errorf("Match(%q) = (%q, %t), expected (%q, %t)", c.Item.GUID, id, reGUIDed, c.ID, c.ReGUIDed)
+		}
+	}
+}
+
+func TestMarkRemovedItems(t *testing.T) {
+	var keepIDs [][]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/api/feeds/feed/items/mark_removed" {
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL)
+		}
+		var body struct {
+			KeepIDs []string `json:"keep_ids"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+			return
+		}
+		keepIDs = append(keepIDs, body.KeepIDs)
+		w.Write([]byte(`{"removed": 1}`))
+	}))
+	defer srv.Close()
+
+	w := UpdateFeedWorker{API: api.API{Host: strings.TrimPrefix(srv.URL, "http://")}}
+
+	// Nothing is marked when the feed is empty
+	index := newItemIndex([]api.Item{{ID: "1", GUID: "a"}, {ID: "2", GUID: "b"}})
+	if err := w.markRemovedItems(&Job{}, "feed", index); err != nil {
+		t.Fatal(err)
+	}
+	if len(keepIDs) != 0 {
+		t.Fatalf("Expected no requests, got %d", len(keepIDs))
+	}
+
+	index.Match(&api.Item{GUID: "a"})
+	if err := w.markRemovedItems(&Job{}, "feed", index); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keepIDs) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(keepIDs))
+	}
+	if !reflect.DeepEqual(keepIDs[0], []string{"1"}) {
+		t.Errorf("Unexpected keep_ids: %v", keepIDs[0])
+	}
+}
+
+func TestMatchItems(t *testing.T) {
+	stored := map[string]api.Item{
+		"a":  {ID: "1", GUID: "a"},
+		"b":  {ID: "2", GUID: "b", URL: "http://example.com/2.mp3"},
+		"zz": {ID: "3", GUID: "zz"},
+	}
+
+	var lookups []api.ItemKeys
+	var updated, created []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/api/feeds/feed/items/find":
+			var keys api.ItemKeys
+			if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
+				t.Error(err)
+				return
+			}
+			lookups = append(lookups, keys)
+
+			var items []api.Item
+			for _, guid := range keys.GUIDs {
+				if item, ok := stored[guid]; ok {
+					items = append(items, item)
+				}
+			}
+			for _, key := range keys.MediaURLKeys {
+				if key == "example.com/2.mp3" {
+					items = append(items, stored["b"])
+				}
+			}
+			json.NewEncoder(w).Encode(items)
+		case r.Method == "PUT":
+			updated = append(updated, strings.TrimPrefix(r.URL.Path, "/api/feeds/feed/items/"))
+			w.Write([]byte("{}"))
+		case r.Method == "POST" && r.URL.Path == "/api/feeds/feed/items":
+			var item api.Item
+			if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+				t.Error(err)
+				return
+			}
+			item.ID = "new-" + item.GUID
+			created = append(created, item.ID)
+			json.NewEncoder(w).Encode(&item)
+		default:
+			t.Errorf("Unexpected request: %s %s", r.Method, r.URL)
+		}
+	}))
+	defer srv.Close()
+
+	w := UpdateFeedWorker{API: api.API{Host: strings.TrimPrefix(srv.URL, "http://")}}
+	index := newItemIndex(nil)
+
+	newIDs, err := w.matchItems(&Job{}, "feed", index, []api.Item{
+		{GUID: "a"},
+		// New GUID, same media
+		{GUID: "b2", URL: "https://example.com/2.mp3"},
+		{GUID: "c"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(lookups) != 1 {
+		t.Fatalf("Expected 1 lookup, got %d", len(lookups))
+	}
+	if !reflect.DeepEqual(lookups[0].GUIDs, []string{"a", "b2", "c"}) {
+		t.Errorf("Unexpected GUIDs looked up: %v", lookups[0].GUIDs)
+	}
+	if !reflect.DeepEqual(updated, []string{"1", "2"}) {
+		t.Errorf("Unexpected updates: %v", updated)
+	}
+	if !reflect.DeepEqual(newIDs, []string{"new-c"}) || !reflect.DeepEqual(created, newIDs) {
+		t.Errorf("Unexpected new items: %v, %v", newIDs, created)
+	}
+
+	// Items of earlier batches remain matched
+	for _, id := range []string{"1", "2", "new-c"} {
+		if !index.Matched(id) {
+			t.Errorf("Item %s is not matched", id)
+		}
+	}
+	if index.Matched("3") {
+		t.Error("Item 3 is matched")
+	}
+}
+
+func TestFetchFeed_Conditional(t *testing.T) {
+	lastModified := time.Date(2016, time.April, 11, 1, 15, 0, 0, time.UTC)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if t, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil && !lastModified.After(t) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("<rss></rss>"))
+	}))
+	defer srv.Close()
+
+	cases := []struct {
+		Feed     api.Feed
+		Expected int
+	}{
+		{api.Feed{}, http.StatusOK},
+		{api.Feed{SourceETag: `"v1"`}, http.StatusNotModified},
+		{api.Feed{SourceETag: `"v0"`}, http.StatusOK},
+		{api.Feed{SourceLastModified: lastModified}, http.StatusNotModified},
+		{api.Feed{SourceLastModified: lastModified.Add(-time.Hour)}, http.StatusOK},
+	}
+
+	w := UpdateFeedWorker{Client: http.DefaultClient}
+	for _, c := range cases {
+		resp, _, err := w.fetchFeed(srv.URL, conditionalHeaders(&c.Feed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != c.Expected {
+			t.Errorf("Status code mismatch for %#v: %d != %d", c.Feed, resp.StatusCode, c.Expected)
+		}
+	}
+}
+
+func TestSpoolBody(t *testing.T) {
+	const body = "<rss></rss>"
+
+	f, hash, err := spoolBody(strings.NewReader(body), 1024)
 	if err != nil {
 		t.Fatal(err)
 	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if hash != "6339076820c62d5f3a4ab6482ec6ed22e917c76fb02eadabaec58a191e16b0dd" {
+		t.Errorf("Hash mismatch: %s", hash)
+	}
 
-	doc, err := rss.ParseFeed(bytes.NewReader(buf))
+	data, err := ioutil.ReadAll(f)
 	if err != nil {
 		t.Fatal(err)
 	}
-	items := itemsFromRSS(doc)
+	if string(data) != body {
+		t.Errorf("Body mismatch: %q", data)
+	}
+
+	if _, _, err := spoolBody(strings.NewReader(body), 4); err != podcast.ErrTooLarge {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestCheckInterval(t *testing.T) {
+	now := time.Date(2017, time.June, 1, 0, 0, 0, 0, time.UTC)
+	every := func(d time.Duration, n int, latest time.Time) []time.Time {
+		var times []time.Time
+		for i := 0; i < n; i++ {
+			times = append(times, latest.Add(-time.Duration(i)*d))
+		}
+		return times
+	}
+
+	day := 24 * time.Hour
+	cases := []struct {
+		Name     string
+		PubTimes []time.Time
+		Complete bool
+		Expected time.Duration
+	}{
+		{"no items", nil, false, defaultCheckInterval},
+		{"one item", every(day, 1, now), false, defaultCheckInterval},
+		{"daily", every(day, 30, now.Add(-time.Hour)), false, time.Hour},
+		{"weekly", every(7*day, 30, now.Add(-day)), false, 7 * time.Hour},
+		{"weekly on hiatus", every(7*day, 30, now.Add(-60*day)), false, 14 * time.Hour},
+		{"hourly", every(time.Hour, 30, now), false, minCheckInterval},
+		{"yearly", every(365*day, 5, now.Add(-day)), false, maxCheckInterval},
+		{"complete", every(day, 30, now), true, completeCheckInterval},
+	}
+
+	for _, c := range cases {
+		if out := checkInterval(c.PubTimes, c.Complete, now); out != c.Expected {
+			t.Errorf("%s: checkInterval = %s, expected %s", c.Name, out, c.Expected)
+		}
+	}
+}
+
+func TestCadence_UsesRecentItems(t *testing.T) {
+	now := time.Date(2017, time.June, 1, 0, 0, 0, 0, time.UTC)
+
+	// A feed that used to be monthly and is now daily, out of order
+	var times []time.Time
+	for i := 0; i < 20; i++ {
+		times = append(times, now.Add(-time.Duration(i)*24*time.Hour))
+		times = append(times, now.Add(-time.Duration(i+30)*30*24*time.Hour))
+	}
+	times = append(times, time.Time{})
+
+	if out := cadence(times); out != 24*time.Hour {
+		t.Errorf("cadence = %s, expected %s", out, 24*time.Hour)
+	}
+}
+
+func TestFailureBackoff(t *testing.T) {
+	cases := []struct {
+		Failures int
+		Expected time.Duration
+	}{
+		{0, minCheckInterval},
+		{1, minCheckInterval},
+		{2, 2 * minCheckInterval},
+		{3, 4 * minCheckInterval},
+		{10, maxFailureBackoff},
+		{1000, maxFailureBackoff},
+	}
+
+	for _, c := range cases {
+		if out := failureBackoff(c.Failures); out != c.Expected {
+			t.Errorf("failureBackoff(%d) = %s, expected %s", c.Failures, out, c.Expected)
+		}
+	}
+}
+
+func TestDisableReason(t *testing.T) {
+	now := time.Now()
+	longAgo := now.Add(-disableAfter - time.Hour)
+	recently := now.Add(-time.Hour)
+
+	cases := []struct {
+		Feed    api.Feed
+		Disable bool
+	}{
+		{api.Feed{LastStatusCode: http.StatusGone, ConsecutiveFailures: 1, LastSuccessTime: recently}, true},
+		{api.Feed{LastStatusCode: http.StatusNotFound, ConsecutiveFailures: 1, LastSuccessTime: longAgo}, false},
+		{api.Feed{ConsecutiveFailures: disableAfterFailures, LastSuccessTime: longAgo}, true},
+		{api.Feed{ConsecutiveFailures: disableAfterFailures, LastSuccessTime: recently}, false},
+		{api.Feed{ConsecutiveFailures: disableAfterFailures - 1, LastSuccessTime: longAgo}, false},
+
+		// Falls back to the last scrape, then to the feed's creation
+		{api.Feed{ConsecutiveFailures: disableAfterFailures, LastScrapedTime: recently}, false},
+		{api.Feed{ConsecutiveFailures: disableAfterFailures, CreationTime: recently}, false},
+		{api.Feed{ConsecutiveFailures: disableAfterFailures, CreationTime: longAgo}, true},
+	}
+
+	for i, c := range cases {
+		if reason := disableReason(&c.Feed, now); (reason != "") != c.Disable {
+			t.Errorf("case %d: disableReason = %q, expected disabled = %t", i, reason, c.Disable)
+		}
+	}
+}
+
+func TestRecordFailure(t *testing.T) {
+	var updated api.Feed
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			json.NewEncoder(w).Encode(&api.Feed{
+				ID:                  "1",
+				ConsecutiveFailures: 2,
+				LastSuccessTime:     time.Now().Add(-time.Hour),
+			})
+		case "PUT":
+			json.NewDecoder(r.Body).Decode(&updated)
+		}
+	}))
+	defer srv.Close()
+
+	w := UpdateFeedWorker{API: api.API{Host: strings.TrimPrefix(srv.URL, "http://")}}
+
+	w.recordFailure(&Job{}, "1", errors.New("connection refused"))
+	if updated.ConsecutiveFailures != 3 {
+		t.Errorf("ConsecutiveFailures mismatch: %d != 3", updated.ConsecutiveFailures)
+	}
+	if updated.LastError != "connection refused" || updated.LastStatusCode != 0 {
+		t.Errorf("Unexpected last error: %q (%d)", updated.LastError, updated.LastStatusCode)
+	}
+	if updated.Disabled {
+		t.Error("Feed was disabled")
+	}
+
+	w.recordFailure(&Job{}, "1", &statusError{Code: http.StatusGone, Status: "410 Gone"})
+	if updated.LastStatusCode != http.StatusGone {
+		t.Errorf("LastStatusCode mismatch: %d != %d", updated.LastStatusCode, http.StatusGone)
+	}
+	if !updated.Disabled {
+		t.Error("Feed was not disabled")
+	}
+}
+
+func TestSkipScrape_ExpiredLease(t *testing.T) {
+	var updated api.Feed
+	var subscribed bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "PUT":
+			json.NewDecoder(r.Body).Decode(&updated)
+		case r.URL.Path == "/hub":
+			r.ParseForm()
+			subscribed = r.PostForm.Get("hub.mode") == "subscribe"
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer srv.Close()
+
+	w := UpdateFeedWorker{
+		API:    api.API{Host: strings.TrimPrefix(srv.URL, "http://")},
+		Client: http.DefaultClient,
+		WebSub: &WebSubConfig{CallbackURL: srv.URL + "/api/websub/", Key: []byte("key")},
+	}
+
+	feed := api.Feed{
+		ID:             "1",
+		HubURL:         srv.URL + "/hub",
+		HubTopic:       "http://example.com/feed.xml",
+		HubLeaseExpiry: time.Now().Add(-time.Minute),
+		CheckInterval:  websubCheckInterval,
+	}
+	if err := w.skipScrape(&Job{}, &feed, http.StatusNotModified, &feed.ScrapesSkippedNotModified); err != nil {
+		t.Fatal(err)
+	}
 
-	if items[0].Description != doc.Channel.Items[0].ContentEncoded {
-		t.Error("item.Description != item.ContentEncoded")
+	if updated.CheckInterval != defaultCheckInterval {
+		t.Errorf("CheckInterval mismatch: %s != %s", updated.CheckInterval, defaultCheckInterval)
+	}
+	if d := updated.NextCheckTime.Sub(time.Now()); d > defaultCheckInterval {
+		t.Errorf("Feed is checked too late: %s", d)
+	}
+	if !subscribed {
+		t.Error("Subscription was not renewed")
+	}
+}
+
+func TestUpdateFeed_ErrorStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "<rss></rss>", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	w := UpdateFeedWorker{Client: http.DefaultClient}
+	err := w.update(&Job{}, &UpdateFeedPayload{FeedID: "1"}, &api.Feed{ID: "1", URL: srv.URL})
+
+	se, ok := err.(*statusError)
+	if !ok {
+		t.Fatalf("Expected a status error, got %v", err)
+	}
+	if se.Code != http.StatusInternalServerError {
+		t.Errorf("Code mismatch: %d != %d", se.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestUpdateUserFeedsWorker(t *testing.T) {
+	var requests []string
+	var leases []time.Time
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/api/feeds":
+			if r.URL.Query().Get("due") != "true" {
+				t.Errorf("Expected only due feeds to be requested: %s", r.URL)
+			}
+			json.NewEncoder(w).Encode([]api.Feed{{ID: "1"}, {ID: "2"}})
+		case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/lease"):
+			var body struct {
+				Until time.Time `json:"until"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			leases = append(leases, body.Until)
+
+			// Feed 2 was leased by another worker in the meantime
+			if r.URL.Path == "/api/feeds/2/lease" {
+				w.WriteHeader(http.StatusConflict)
+			}
+		case r.Method == "POST" && r.URL.Path == "/api/jobs":
+			var job api.Job
+			json.NewDecoder(r.Body).Decode(&job)
+			json.NewEncoder(w).Encode(&job)
+		}
+	}))
+	defer srv.Close()
+
+	w := UpdateUserFeedsWorker{API: api.API{Host: strings.TrimPrefix(srv.URL, "http://")}}
+	if err := w.Work(&Job{}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"GET /api/feeds",
+		"POST /api/feeds/1/lease",
+		"POST /api/jobs",
+		"POST /api/feeds/2/lease",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Requests mismatch: %v", requests)
+	}
+
+	for _, until := range leases {
+		if until.Sub(time.Now()) < checkLease-time.Minute {
+			t.Errorf("Feed was not held back: %s", until)
+		}
+	}
+}
+
+func TestSubscribeToHub(t *testing.T) {
+	key := []byte("key")
+	feed := api.Feed{
+		ID:       "feed1",
+		HubTopic: "http://example.com/feed.xml",
+	}
+
+	// A server that only confirms the subscription it was told to expect
+	var pendingUntil time.Time
+	var verified bool
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PUT" && r.URL.Path == "/api/feeds/feed1" {
+			var body api.Feed
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			pendingUntil = body.HubPendingUntil
+			return
+		}
+
+		q := r.URL.Query()
+		if r.URL.Path != "/api/websub/feed1" || q.Get("hub.mode") != "subscribe" || q.Get("hub.topic") != feed.HubTopic ||
+			!time.Now().Before(pendingUntil) {
+			http.NotFound(w, r)
+			return
+		}
+		verified = true
+		w.Write([]byte(q.Get("hub.challenge")))
+	}))
+	defer callback.Close()
+
+	// A fake hub that verifies the subscription before accepting it
+	var form url.Values
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+
+		verifyURL := form.Get("hub.callback") + "?" + url.Values{
+			"hub.mode":          {"subscribe"},
+			"hub.topic":         {form.Get("hub.topic")},
+			"hub.challenge":     {"challenge"},
+			"hub.lease_seconds": {form.Get("hub.lease_seconds")},
+		}.Encode()
+
+		resp, err := http.Get(verifyURL)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK || string(body) != "challenge" {
+			http.Error(w, "verification failed", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer hub.Close()
+	feed.HubURL = hub.URL
+
+	w := UpdateFeedWorker{
+		API:    api.API{Host: strings.TrimPrefix(callback.URL, "http://")},
+		Client: http.DefaultClient,
+		WebSub: &WebSubConfig{
+			CallbackURL: callback.URL + "/api/websub/",
+			Key:         key,
+		},
+	}
+	w.subscribeToHub(&Job{}, &feed)
+
+	if !verified {
+		t.Fatal("Subscription was not verified")
+	}
+	if form.Get("hub.secret") != websub.Secret(key, feed.ID) {
+		t.Errorf("Secret mismatch: %s", form.Get("hub.secret"))
+	}
+	if form.Get("hub.lease_seconds") != "604800" {
+		t.Errorf("Lease mismatch: %s", form.Get("hub.lease_seconds"))
 	}
 }